@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenCapsConnections(t *testing.T) {
+	ln, err := listen("127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	first := dial()
+	defer first.Close()
+	held := <-accepted
+
+	second := dial() // queued in the backlog
+	defer second.Close()
+	select {
+	case <-accepted:
+		t.Fatal("accepted a second connection over the cap")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	held.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("queued connection not accepted after one was released")
+	}
+}
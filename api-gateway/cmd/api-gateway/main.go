@@ -2,60 +2,270 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"golang.org/x/net/netutil"
+	"golang.org/x/time/rate"
+
 	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/errpage"
 	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/logging"
 	"my_app/api-gateway/internal/middleware"
 	"my_app/api-gateway/internal/proxy"
 	"my_app/api-gateway/internal/server"
 )
 
 func main() {
-	cfg := config.Load()
+	configPath := flag.String("config", os.Getenv("GATEWAY_CONFIG"), "JSON or YAML config file; environment variables override its values")
+	flag.Parse()
+	config.SetFile(*configPath)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("[config] %v", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat, cfg.LogUTC); err != nil {
+		log.Fatalf("[config] %v", err)
+	}
+	if err := middleware.SetLogTimeFormat(cfg.LogTimeFormat, cfg.LogUTC); err != nil {
+		log.Fatalf("[config] %v", err)
+	}
+	if cfg.ErrorTemplateDir != "" {
+		if err := errpage.LoadDir(cfg.ErrorTemplateDir); err != nil {
+			slog.Warn("[errpage] loading templates failed, using defaults", "dir", cfg.ErrorTemplateDir, "err", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tlsConfig, err := proxy.TLSConfig(cfg.UpstreamCAFile, cfg.UpstreamInsecureSkipVerify)
+	if err != nil {
+		slog.Error("[config] loading UPSTREAM_CA_FILE failed", "err", err)
+		os.Exit(1)
+	}
+	if cfg.UpstreamInsecureSkipVerify {
+		slog.Warn("[config] UPSTREAM_INSECURE_SKIP_VERIFY is set: upstream TLS certificates are not verified")
+	}
+	// One pooled transport for all proxied requests; Eureka gets a copy
+	// with the same pool settings but without the host guard.
+	pooled := http.DefaultTransport.(*http.Transport).Clone()
+	pooled.MaxIdleConns = cfg.UpstreamMaxIdleConns
+	pooled.MaxIdleConnsPerHost = cfg.UpstreamMaxIdleConnsPerHost
+	pooled.MaxConnsPerHost = cfg.UpstreamMaxConnsPerHost
+	pooled.IdleConnTimeout = cfg.UpstreamIdleConnTimeout
+	pooled.TLSHandshakeTimeout = cfg.UpstreamTLSHandshakeTimeout
+	pooled.ResponseHeaderTimeout = cfg.UpstreamResponseHeaderTimeout
+	pooled.DialContext = (&net.Dialer{Timeout: cfg.UpstreamDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	pooled.TLSClientConfig = tlsConfig
 
-	httpClient := &http.Client{Timeout: cfg.RequestTimeout}
-	eurekaClient := eureka.NewEurekaClient(cfg.EurekaServerURL, cfg.RequestTimeout)
+	guard := proxy.NewHostGuard(cfg.UpstreamAllowedHosts)
+	transport := pooled.Clone()
+	transport.ExpectContinueTimeout = cfg.ExpectContinueTimeout
+	// Re-check every resolved address at dial time so DNS rebinding can't
+	// reach metadata endpoints.
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.UpstreamDialTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   guard.Control,
+	}).DialContext
+	httpClient := &http.Client{Timeout: cfg.RequestTimeout, Transport: transport}
+	eurekaClient := eureka.NewEurekaClient(cfg.EurekaServerURLs, cfg.RequestTimeout)
+	eurekaClient.SetTransport(pooled.Clone())
+	eurekaClient.SetBasicAuth(cfg.EurekaUsername, cfg.EurekaPassword)
+	eurekaClient.SetFormatHint(cfg.EurekaFormatHint)
+	eurekaClient.SetRegisterFormat(cfg.EurekaRegisterFormat)
+	eurekaClient.SetCacheTTL(cfg.EurekaCacheTTL)
+	if cfg.EurekaDeltaInterval > 0 {
+		go eurekaClient.SyncRegistry(ctx, cfg.EurekaDeltaInterval, cfg.EurekaFullFetchInterval)
+	}
 	ip := config.LocalIP()
 
+	readiness := &server.Readiness{}
+
+	// eurekaDone is closed once the register/heartbeat loop has exited, so
+	// shutdown never deregisters while a registration is still in flight.
+	eurekaDone := make(chan struct{})
 	go func() {
+		defer close(eurekaDone)
+		if !cfg.EurekaRegister {
+			slog.Info("[eureka] registration disabled, discovery only")
+			readiness.SetRegistered(true)
+			return
+		}
 		for {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			err := eurekaClient.Register(ctx, cfg, ip)
+			regCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := eurekaClient.Register(regCtx, cfg, ip)
 			cancel()
 			if err == nil {
 				break
 			}
-			log.Printf("[eureka] register failed: %v. Retrying in 5s...", err)
-			time.Sleep(5 * time.Second)
+			slog.Warn("[eureka] register failed, retrying in 5s", "err", err)
+			if sleepCtx(ctx, 5*time.Second) != nil {
+				return
+			}
 		}
-		log.Printf("[eureka] registered %s (%s)", cfg.AppName, cfg.InstanceID)
+		slog.Info("[eureka] registered", "app", cfg.AppName, "instance", cfg.InstanceID)
+		readiness.SetRegistered(true)
 
 		t := time.NewTicker(30 * time.Second)
 		defer t.Stop()
-		for range t.C {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := eurekaClient.Heartbeat(ctx, cfg); err != nil {
-				log.Printf("[eureka] heartbeat failed: %v", err)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			hbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := eurekaClient.Heartbeat(hbCtx, cfg); err != nil {
+				slog.Warn("[eureka] heartbeat failed", "err", err)
 			}
 			cancel()
 		}
 	}()
 
-	proxyClient := proxy.New(httpClient)
-	rateLimiter := middleware.NewRateLimiter(100, 200) // 100 req/s, burst 200
+	proxyClient := proxy.New(httpClient, proxy.Options{
+		Breaker: proxy.BreakerConfig{
+			Interval:            cfg.CBInterval,
+			Timeout:             cfg.CBTimeout,
+			Strategy:            cfg.CBStrategy,
+			ConsecutiveFailures: cfg.CBConsecutiveFailures,
+			FailureRatio:        cfg.CBFailureRatio,
+			MinRequests:         cfg.CBMinRequests,
+		},
+		StreamMaxBuffered: cfg.StreamMaxBufferBytes,
+		CopyBufferSize:    cfg.ProxyCopyBufferBytes,
+		Retry: proxy.RetryConfig{
+			MaxAttempts:   cfg.RetryMaxAttempts,
+			Backoff:       cfg.RetryBackoff,
+			Jitter:        cfg.RetryJitter,
+			NonIdempotent: cfg.RetryNonIdempotent,
+		},
+		MaxFailovers:   cfg.UpstreamFailoverAttempts,
+		UpstreamHeader: cfg.UpstreamHeader,
+		MaxBuffered:    cfg.MaxRequestBody,
+		Guard:          guard,
+	})
+	rateLimiter := middleware.NewRateLimiter(rate.Limit(cfg.RateLimitRPS), cfg.RateLimitBurst, cfg.RateLimitIdleTTL)
+	concurrencyLimiter := middleware.NewConcurrencyLimiter(cfg.MaxConcurrentPerClient, cfg.LoadHintHighRatio)
 
-	mux := server.NewMux(cfg, eurekaClient, proxyClient, httpClient)
+	apiKeys := middleware.NewAPIKeys(cfg.APIKeys, cfg.APIKeyPublicPaths)
+	jwtAuth := middleware.NewJWTAuth(middleware.JWTConfig{
+		JWKSURL:       cfg.JWTJWKSURL,
+		Issuer:        cfg.JWTIssuer,
+		Audience:      cfg.JWTAudience,
+		Public:        cfg.JWTPublicPaths,
+		Refresh:       cfg.JWKSRefresh,
+		ForwardUserID: cfg.JWTForwardUserID,
+	})
 
-	// Chain middlewares: Logging -> RateLimit -> Mux
-	handler := rateLimiter.Middleware(mux)
-	handler = middleware.StructuredLoggingMiddleware(handler)
+	reloader := server.NewReloader(cfg)
+	reloader.Hot("rate_limit", func(c config.Config) string {
+		return fmt.Sprintf("%g/s burst %d", c.RateLimitRPS, c.RateLimitBurst)
+	}, func(c config.Config) {
+		rateLimiter.SetLimit(rate.Limit(c.RateLimitRPS), c.RateLimitBurst)
+	})
+	reloader.Hot("log_level", func(c config.Config) string {
+		return c.LogLevel
+	}, func(c config.Config) {
+		_ = logging.SetLevel(c.LogLevel)
+	})
+	reloader.Hot("api_keys", func(c config.Config) string {
+		// A fingerprint, so rotations are detected without logging keys
+		sum := sha256.Sum256([]byte(strings.Join(c.APIKeys, "\n")))
+		return fmt.Sprintf("%d key(s) %x", len(c.APIKeys), sum[:4])
+	}, func(c config.Config) {
+		apiKeys.SetKeys(c.APIKeys)
+	})
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			changes, err := reloader.Reload()
+			if err != nil {
+				slog.Error("[reload] SIGHUP received, keeping current configuration", "err", err)
+				continue
+			}
+			slog.Info("[reload] SIGHUP received", "changed", len(changes))
+		}
+	}()
+
+	// Background work behind the mux outlives the signal context, so it
+	// keeps running while the gateway drains
+	lifetime, endLifetime := context.WithCancel(context.Background())
+	mux := server.NewMux(lifetime, cfg, eurekaClient, proxyClient, httpClient, readiness, reloader)
+
+	// Chain middlewares (outermost first): Recovery -> RequestID -> Logging -> Gzip ->
+	// Forwarded -> MethodPolicy -> URL length -> Body size -> RateLimit -> APIKey -> JWT ->
+	// Concurrency -> Timeout -> Mux
+	handler := middleware.TimeoutMiddleware(mux, cfg.GatewayTimeout, cfg.GatewayTimeoutExempt)
+	handler = concurrencyLimiter.Middleware(handler)
+	handler = jwtAuth.Middleware(handler)
+	handler = apiKeys.Middleware(handler)
+	handler = rateLimiter.Middleware(handler)
+	handler = middleware.MaxBodyMiddleware(handler, cfg.MaxRequestBody)
+	handler = middleware.MaxURLLengthMiddleware(handler, cfg.MaxURLLength)
+	handler = middleware.MethodPolicyMiddleware(handler)
+	handler = middleware.ForwardedMiddleware(handler, middleware.NewTrustedProxies(cfg.TrustedProxies))
+	handler = middleware.NewGzip(cfg.GzipLevel).Middleware(handler)
+	if cfg.InstanceHeader {
+		handler = middleware.InstanceHeaderMiddleware(handler, cfg.InstanceID)
+	}
+	sampler := middleware.NewLogSampler(cfg.AccessLogSampleRate, uint64(time.Now().UnixNano()))
+	handler = middleware.StructuredLoggingMiddleware(handler, sampler)
+	handler = middleware.RequestIDMiddleware(handler)
+	handler = middleware.RecoveryMiddleware(handler)
 
 	addr := ":" + cfg.Port
-	log.Printf("api-gateway listening on %s (eureka=%s, agentApp=%s)", addr, cfg.EurekaServerURL, cfg.AgentAppName)
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: addr, Handler: handler}
+	ln, err := listen(addr, cfg.MaxConnections)
+	if err != nil {
+		slog.Error("listen failed", "addr", addr, "err", err)
+		os.Exit(1)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	readiness.SetReady(true)
+	slog.Info("api-gateway listening", "addr", addr, "eureka", strings.Join(cfg.EurekaServerURLs, ","), "agentApp", cfg.AgentAppName,
+		"version", server.Version, "commit", server.Commit, "buildTime", server.BuildTime)
+
+	select {
+	case err := <-errc:
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	runShutdown(shutdownSteps(cfg, readiness, eurekaClient, eurekaDone, srv))
+	endLifetime()
+	rateLimiter.Stop()
+	slog.Info("api-gateway stopped")
+}
+
+// listen opens the TCP listener, accepting at most maxConns connections at
+// once (0 = unlimited).
+func listen(addr string, maxConns int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if maxConns > 0 {
+		// Connections beyond the cap wait in the kernel backlog until one frees up
+		ln = netutil.LimitListener(ln, maxConns)
 	}
+	return ln, nil
 }
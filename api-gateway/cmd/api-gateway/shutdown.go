@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/server"
+)
+
+// shutdownStep is one phase of the graceful shutdown sequence.
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// runShutdown executes steps in order, each bounded by its own timeout.
+// A failing step is logged and does not prevent the remaining steps.
+func runShutdown(steps []shutdownStep) {
+	begin := time.Now()
+	slog.Info("[shutdown] starting", "steps", len(steps))
+	defer func() { slog.Info("[shutdown] complete", "elapsed", time.Since(begin)) }()
+	for _, s := range steps {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		start := time.Now()
+		slog.Debug("[shutdown] step", "step", s.name)
+		if err := s.run(ctx); err != nil {
+			slog.Warn("[shutdown] step failed", "step", s.name, "elapsed", time.Since(start), "err", err)
+		} else {
+			slog.Info("[shutdown] step done", "step", s.name, "elapsed", time.Since(start))
+		}
+		cancel()
+	}
+}
+
+// registry is the part of the Eureka client shutdown uses.
+type registry interface {
+	SetStatus(ctx context.Context, cfg config.Config, status string) error
+	Deregister(ctx context.Context, cfg config.Config) error
+}
+
+// httpServer is the part of *http.Server shutdown uses.
+type httpServer interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownSteps is the graceful shutdown sequence: stop advertising
+// readiness, take the instance out of Eureka, wait for clients to notice,
+// then stop the server. eurekaDone is closed once the register/heartbeat
+// loop has exited.
+func shutdownSteps(cfg config.Config, readiness *server.Readiness, registry registry, eurekaDone <-chan struct{}, srv httpServer) []shutdownStep {
+	return []shutdownStep{
+		{name: "start draining", timeout: time.Second, run: func(context.Context) error {
+			readiness.StartDraining()
+			return nil
+		}},
+		{name: "mark out of service in eureka", timeout: cfg.DeregisterTimeout, run: func(ctx context.Context) error {
+			select {
+			case <-eurekaDone:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !cfg.EurekaRegister {
+				return nil
+			}
+			return registry.SetStatus(ctx, cfg, "OUT_OF_SERVICE")
+		}},
+		{name: "eureka drain", timeout: cfg.EurekaDrainPeriod + time.Second, run: func(ctx context.Context) error {
+			if !cfg.EurekaRegister {
+				return nil
+			}
+			return sleepCtx(ctx, cfg.EurekaDrainPeriod)
+		}},
+		{name: "deregister from eureka", timeout: cfg.DeregisterTimeout, run: func(ctx context.Context) error {
+			select {
+			case <-eurekaDone:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !cfg.EurekaRegister {
+				return nil
+			}
+			return registry.Deregister(ctx, cfg)
+		}},
+		{name: "pre-stop delay", timeout: cfg.ShutdownQuietPeriod + time.Second, run: func(ctx context.Context) error {
+			return sleepCtx(ctx, cfg.ShutdownQuietPeriod)
+		}},
+		{name: "stop http server", timeout: cfg.ShutdownTimeout, run: srv.Shutdown},
+	}
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
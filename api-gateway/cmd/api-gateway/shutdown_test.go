@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/server"
+)
+
+// events records what the fakes were asked to do, in order.
+type events struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (e *events) add(ev string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.list = append(e.list, ev)
+}
+
+type fakeRegistry struct {
+	events    *events
+	readiness *server.Readiness
+	statusErr error
+
+	statusAt, deregisterAt time.Time
+}
+
+func (f *fakeRegistry) SetStatus(ctx context.Context, cfg config.Config, status string) error {
+	if f.readiness.Draining() {
+		f.events.add("draining")
+	}
+	f.events.add("status " + status)
+	f.statusAt = time.Now()
+	return f.statusErr
+}
+
+func (f *fakeRegistry) Deregister(ctx context.Context, cfg config.Config) error {
+	f.events.add("deregister")
+	f.deregisterAt = time.Now()
+	return nil
+}
+
+type fakeServer struct{ events *events }
+
+func (f *fakeServer) Shutdown(ctx context.Context) error {
+	f.events.add("shutdown")
+	return nil
+}
+
+// shutdownConfig registers with Eureka and keeps every wait short.
+func shutdownConfig() config.Config {
+	return config.Config{
+		EurekaRegister:      true,
+		DeregisterTimeout:   time.Second,
+		EurekaDrainPeriod:   10 * time.Millisecond,
+		ShutdownQuietPeriod: 10 * time.Millisecond,
+		ShutdownTimeout:     time.Second,
+	}
+}
+
+func TestShutdownStepsRunInOrder(t *testing.T) {
+	ev := &events{}
+	readiness := &server.Readiness{}
+	readiness.SetRegistered(true)
+	readiness.SetReady(true)
+	eurekaDone := make(chan struct{})
+	close(eurekaDone)
+
+	cfg := shutdownConfig()
+	reg := &fakeRegistry{events: ev, readiness: readiness}
+	runShutdown(shutdownSteps(cfg, readiness, reg, eurekaDone, &fakeServer{events: ev}))
+
+	want := []string{"draining", "status OUT_OF_SERVICE", "deregister", "shutdown"}
+	if !reflect.DeepEqual(ev.list, want) {
+		t.Fatalf("shutdown did %q, want %q", ev.list, want)
+	}
+	if readiness.Ready() {
+		t.Fatal("still reporting ready after shutdown")
+	}
+	// Clients get the drain period to see OUT_OF_SERVICE before deregistering
+	if gap := reg.deregisterAt.Sub(reg.statusAt); gap < cfg.EurekaDrainPeriod {
+		t.Fatalf("deregistered %v after going out of service, want at least %v", gap, cfg.EurekaDrainPeriod)
+	}
+}
+
+func TestShutdownContinuesAfterFailedStep(t *testing.T) {
+	ev := &events{}
+	readiness := &server.Readiness{}
+	eurekaDone := make(chan struct{})
+	close(eurekaDone)
+	reg := &fakeRegistry{events: ev, readiness: readiness, statusErr: errors.New("eureka unreachable")}
+
+	runShutdown(shutdownSteps(shutdownConfig(), readiness, reg, eurekaDone, &fakeServer{events: ev}))
+
+	if n := len(ev.list); n == 0 || ev.list[n-1] != "shutdown" {
+		t.Fatalf("shutdown did %q, want the server stopped after a failed step", ev.list)
+	}
+}
+
+func TestShutdownWaitsForHeartbeatLoop(t *testing.T) {
+	ev := &events{}
+	readiness := &server.Readiness{}
+	eurekaDone := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		ev.add("heartbeat stopped")
+		close(eurekaDone)
+	})
+
+	runShutdown(shutdownSteps(shutdownConfig(), readiness, &fakeRegistry{events: ev, readiness: readiness}, eurekaDone, &fakeServer{events: ev}))
+
+	if len(ev.list) == 0 || ev.list[0] != "heartbeat stopped" {
+		t.Fatalf("shutdown did %q, want Eureka calls only after the heartbeat loop stopped", ev.list)
+	}
+}
@@ -3,6 +3,17 @@ module my_app/api-gateway
 go 1.24.0
 
 require (
-	github.com/sony/gobreaker v1.0.0 // indirect
-	golang.org/x/time v0.14.0 // indirect
+	github.com/sony/gobreaker v1.0.0
+	go.opentelemetry.io/otel v1.35.0
+	go.opentelemetry.io/otel/trace v1.35.0
+	golang.org/x/net v0.38.0
+	golang.org/x/time v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.35.0 // indirect
 )
@@ -2,32 +2,356 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"my_app/api-gateway/internal/swagger"
 )
 
+// ServiceConfig is a backend whose OpenAPI document is aggregated.
+type ServiceConfig struct {
+	Name     string `json:"name"` // shown in the docs and used in /api-docs/{Name}/openapi.json
+	AppName  string `json:"app"`  // Eureka app name
+	BaseURL  string `json:"url"`  // fallback if Eureka has no instances
+	SpecPath string `json:"spec"` // path the service serves its OpenAPI spec at
+}
+
 // Config holds application configuration
 type Config struct {
-	Port            string
-	EurekaServerURL string
-	AppName         string
-	InstanceID      string
-	PreferIP        bool
+	Port             string
+	EurekaServerURLs []string // peers, without credentials; see EurekaUsername
+	AppName          string
+	InstanceID       string
+	PreferIP         bool
+	StatusPagePath   string // registered as statusPageUrl, served in actuator /info shape
+
+	// EurekaUsername and EurekaPassword authenticate registry requests with
+	// HTTP basic auth. They default to any user:pass@ in EUREKA_SERVER_URL.
+	EurekaUsername string
+	EurekaPassword string
+
+	// Heartbeat query parameters. Some Eureka servers mark an instance
+	// dirty (and flap its status) unless the heartbeat carries ?status=UP
+	// and the lastDirtyTimestamp sent at registration.
+	EurekaHeartbeatStatus    string // "" (EUREKA_HEARTBEAT_STATUS=none) omits the parameter
+	EurekaHeartbeatLastDirty bool
+
+	// EurekaCacheTTL is how long resolved instance lists are reused before
+	// asking Eureka again (0 resolves on every request).
+	EurekaCacheTTL time.Duration
+
+	// EurekaDeltaInterval enables a local copy of the whole registry,
+	// updated from /apps/delta this often and refetched in full every
+	// EurekaFullFetchInterval; instances are then resolved from it instead
+	// of per app. 0 disables it.
+	EurekaDeltaInterval     time.Duration
+	EurekaFullFetchInterval time.Duration
+
+	// EurekaFormatHint ("suffix", "query" or "none") additionally requests
+	// JSON via the URL for servers that ignore the Accept header.
+	EurekaFormatHint string
+
+	// EurekaRegister registers the gateway with Eureka and heartbeats it.
+	// When false the gateway only uses Eureka for discovery and reports
+	// ready without registering.
+	EurekaRegister bool
+
+	// EurekaRegisterFormat is the registration payload encoding, "xml" or
+	// "json".
+	EurekaRegisterFormat string
+
+	// EurekaMetadata is sent as the instance's metadata when registering
+	// (e.g. zone, version, weight), from "k1=v1,k2=v2".
+	EurekaMetadata map[string]string
+
+	// Services are the backends aggregated at /api-docs: the agent first,
+	// then DOCS_SERVICES entries, e.g.
+	// DOCS_SERVICES="users=USERS-SERVICE;billing=BILLING,url=http://billing:8080,spec=/v3/api-docs".
+	Services []ServiceConfig
 
 	// Agent service discovery
-	AgentAppName   string
-	AgentBaseURL   string // fallback if Eureka has no instances
-	RequestTimeout time.Duration
+	AgentAppName  string
+	AgentBaseURL  string // fallback if Eureka has no instances
+	AgentSpecPath string // path the agent serves its OpenAPI spec at
+	// AgentStaticWeight is the percentage (0-100) of agent traffic sent to
+	// AgentBaseURL even when Eureka has instances, for gradual migrations.
+	AgentStaticWeight int
+	// AgentStreamAppName/AgentStreamBaseURL locate the backend serving
+	// /agent/stream; they default to the agent's own settings.
+	AgentStreamAppName string
+	AgentStreamBaseURL string
+	// ShadowBaseURL receives a copy of ShadowPercent (0-100) of /agent
+	// requests; its responses are discarded and only status mismatches
+	// are logged. Credentials (Authorization, X-Api-Key, Cookie) are
+	// stripped from shadow requests unless ShadowForwardCredentials is set.
+	ShadowBaseURL            string
+	ShadowPercent            int
+	ShadowForwardCredentials bool
+	// AgentRequestHeaders are set on every request proxied to the agent
+	// ("Name=value" pairs); AgentRedactFields are JSON fields whose values
+	// are redacted from agent responses.
+	AgentRequestHeaders map[string]string
+	AgentRedactFields   []string
+	// Upstream timeouts, by phase:
+	//   - UpstreamDialTimeout: establishing the TCP connection
+	//   - UpstreamTLSHandshakeTimeout: the TLS handshake
+	//   - UpstreamResponseHeaderTimeout: from sending the request to the
+	//     response headers (0 = none)
+	//   - RequestTimeout: the whole exchange including the body, for
+	//     every proxied request except streams (/agent/stream), whose
+	//     body may take as long as the upstream needs
+	UpstreamDialTimeout           time.Duration
+	UpstreamTLSHandshakeTimeout   time.Duration
+	UpstreamResponseHeaderTimeout time.Duration
+	RequestTimeout                time.Duration
+	// AdminTimeout bounds admin and diagnostic endpoints (dependency
+	// checks, probes), which should not inherit the long proxy timeout.
+	AdminTimeout time.Duration
+	// GatewayTimeout is the deadline for a whole request, after which the
+	// client gets 504 (0 disables it). It defaults to just above
+	// RequestTimeout so an upstream timeout is reported first. Paths in
+	// GatewayTimeoutExempt, streaming endpoints by default, have none.
+	GatewayTimeout       time.Duration
+	GatewayTimeoutExempt []string
+	// ExpectContinueTimeout is how long the proxy waits for an upstream's
+	// 100 Continue before sending a request body anyway.
+	ExpectContinueTimeout time.Duration
+
+	// Circuit breaker.
+	//
+	// CBInterval is the cyclic period of the closed state: gobreaker clears
+	// its counts every interval, so failures only accumulate towards
+	// tripping while they fall inside one window. With the "consecutive"
+	// strategy the breaker trips after CBConsecutiveFailures failures in a
+	// row within the same interval; a single success resets the streak.
+	// With the "ratio" strategy it trips once at least CBMinRequests
+	// requests were seen in the interval and the share of failures exceeds
+	// CBFailureRatio. An interval of 0 never clears the counts.
+	CBInterval            time.Duration
+	CBTimeout             time.Duration // how long the breaker stays open
+	CBStrategy            string        // "consecutive" (default) or "ratio"
+	CBConsecutiveFailures uint32
+	CBFailureRatio        float64
+	CBMinRequests         uint32
+
+	// Retries of transient upstream failures (network errors, 502/503/504)
+	// for idempotent requests. RetryMaxAttempts counts the first attempt,
+	// so 1 disables retries. An open circuit breaker is never retried.
+	RetryMaxAttempts int
+	RetryBackoff     time.Duration
+	// RetryJitter randomizes retry delays by up to this fraction either way.
+	RetryJitter float64
+	// RetryNonIdempotent also retries POST/PATCH (requests carrying an
+	// Idempotency-Key are retried regardless).
+	RetryNonIdempotent bool
+
+	// UpstreamFailoverAttempts is how many other instances a request may be
+	// moved to when an instance refuses the connection (0 fails fast).
+	UpstreamFailoverAttempts int
+
+	// LBStrategy orders an app's instances per request: "round_robin",
+	// "random", "least_conn" (fewest requests in flight) or "affinity"
+	// (same instance for the same LBAffinityKey, round-robin without one).
+	// LBAffinityKey is "header:<Name>" or "ip" (the client IP).
+	LBStrategy    string
+	LBAffinityKey string
+
+	// HealthCheckInterval enables active health checks: every instance of
+	// the upstream apps is probed at its healthCheckUrl this often, and one
+	// that fails HealthCheckUnhealthyThreshold probes in a row is skipped
+	// until a probe succeeds again. 0 disables them.
+	HealthCheckInterval           time.Duration
+	HealthCheckUnhealthyThreshold int
+
+	// AvailabilityZone is the gateway's own zone. When set, instances whose
+	// Eureka metadata has the same "zone" are preferred, and other zones
+	// are only used when none of those is available. It is also registered
+	// as the gateway's zone unless EurekaMetadata sets one.
+	AvailabilityZone string
+
+	// ProxyCopyBufferBytes is the size of the pooled buffers used to relay
+	// upstream response bodies.
+	ProxyCopyBufferBytes int
+
+	// StreamMaxBufferBytes caps how far a streamed upstream response may
+	// run ahead of a slow client before the stream is aborted (0 = no cap).
+	StreamMaxBufferBytes int64
+
+	// Graceful shutdown. On SIGTERM the gateway starts draining (readiness
+	// fails, liveness stays OK, requests are still served), marks itself
+	// OUT_OF_SERVICE in Eureka and waits EurekaDrainPeriod for clients to
+	// notice, deregisters (each Eureka call bounded by DeregisterTimeout),
+	// waits ShutdownQuietPeriod (PRE_STOP_DELAY) for load balancers to stop
+	// routing to it, then stops the HTTP server within ShutdownTimeout.
+	DeregisterTimeout   time.Duration
+	EurekaDrainPeriod   time.Duration
+	ShutdownQuietPeriod time.Duration
+	ShutdownTimeout     time.Duration
+
+	// UpstreamAllowedHosts restricts which hosts the proxy may contact
+	// (names, "*.suffix" wildcards, IPs or CIDRs; empty allows any host).
+	// Link-local and cloud metadata addresses are always refused, and at
+	// dial time allowed names must resolve into the listed IPs/CIDRs (or,
+	// with none listed, anywhere but loopback).
+	UpstreamAllowedHosts []string
+
+	// UpstreamCAFile is a PEM bundle trusted for HTTPS upstreams (and
+	// HTTPS Eureka peers) on top of the system roots.
+	// UpstreamInsecureSkipVerify disables certificate checks; dev only.
+	UpstreamCAFile             string
+	UpstreamInsecureSkipVerify bool
+
+	// Connection pool of the upstream transport. MaxConnsPerHost 0 means
+	// no limit; idle connections are closed after IdleConnTimeout.
+	UpstreamMaxIdleConns        int
+	UpstreamMaxIdleConnsPerHost int
+	UpstreamMaxConnsPerHost     int
+	UpstreamIdleConnTimeout     time.Duration
+
+	// TrustedProxies lists the IPs/CIDRs of reverse proxies whose
+	// X-Forwarded-Proto and X-Forwarded-For headers are honored (empty
+	// trusts none, so clients are keyed by their socket address).
+	TrustedProxies []string
+
+	// AdminToken guards sensitive admin endpoints via the X-Admin-Token
+	// header. Those endpoints are disabled while it is empty.
+	AdminToken string
+
+	// APIKeys, when set, are required in X-Api-Key on every path except
+	// APIKeyPublicPaths ("*" suffix = prefix match). Several keys may be
+	// valid at once to rotate them; the set is reloadable.
+	APIKeys           []string
+	APIKeyPublicPaths []string
+
+	// JWTJWKSURL, when set, requires a Bearer JWT signed by one of the keys
+	// published there on every path except JWTPublicPaths. JWTIssuer and
+	// JWTAudience, if set, must match the iss and aud claims.
+	JWTJWKSURL       string
+	JWTIssuer        string
+	JWTAudience      string
+	JWTPublicPaths   []string
+	JWKSRefresh      time.Duration
+	JWTForwardUserID bool // send the token's sub upstream as X-User-Id
+
+	// AccessLogSampleRate logs one in N successful requests (errors are
+	// always logged); 1 logs everything.
+	AccessLogSampleRate int
+
+	// LogTimeFormat is the structured log timestamp format: "rfc3339",
+	// "rfc3339nano" or "unixms". LogUTC renders timestamps in UTC.
+	LogTimeFormat string
+	LogUTC        bool
+	// LogLevel is the minimum level logged: "debug", "info", "warn" or
+	// "error". LogFormat is "text" or "json".
+	LogLevel  string
+	LogFormat string
+
+	// SLOSuccessCodes maps route patterns to the statuses logged as
+	// slo_success, e.g. SLO_SUCCESS_CODES="/agent=2xx,3xx,404;/health=200".
+	// Routes not listed count 2xx and 3xx as success.
+	SLOSuccessCodes map[string]string
+
+	// ProxyRoutes declares catch-all upstream routes as prefix -> spec,
+	// e.g. PROXY_ROUTES="/svc=users-service,strip;/legacy=http://10.0.0.5:8080".
+	// The spec is an Eureka app name or base URL, optionally followed by
+	// ",strip" to drop the prefix before forwarding.
+	ProxyRoutes map[string]string
+
+	// InstanceHeader adds X-Gateway-Instance (the InstanceID) to every
+	// response; UpstreamHeader adds X-Gateway-Upstream with the host of the
+	// upstream instance that served a proxied request.
+	InstanceHeader bool
+	UpstreamHeader bool
+
+	// GzipLevel is the response compression level, 1 (fastest) to 9
+	// (smallest); 0 disables compression.
+	GzipLevel int
+
+	// ErrorTemplateDir holds optional "<status>.json"/"<status>.html"
+	// templates for gateway-generated error responses.
+	ErrorTemplateDir string
+
+	// DocsCacheTTL is how long aggregated OpenAPI specs are served from
+	// cache before being refreshed in the background (0 disables the cache).
+	DocsCacheTTL time.Duration
+
+	// DocsAllowOrigin is the Access-Control-Allow-Origin sent with the
+	// OpenAPI documents ("" sends none), and DocsMaxAge how long browsers
+	// may cache them. Each document also carries an ETag.
+	DocsAllowOrigin string
+	DocsMaxAge      time.Duration
+
+	// DependencyCacheTTL is how long /health/dependencies reuses its last
+	// probe results.
+	DependencyCacheTTL time.Duration
+
+	// MaxConnections caps simultaneously accepted TCP connections
+	// (0 = unlimited).
+	MaxConnections int
+
+	// MaxURLLength is the longest request URI (path plus query, in bytes)
+	// accepted before answering 414; defaults to 8192, 0 disables the check.
+	MaxURLLength int
+
+	// MaxRequestBody caps request bodies, and upstream responses the
+	// gateway buffers, in bytes (0 = unlimited).
+	MaxRequestBody int64
+
+	// Per-client rate limit (requests/second and burst). Reloadable via
+	// SIGHUP or POST /admin/reload.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// RateLimitIdleTTL is how long a client's limiter is kept after its
+	// last request (0 keeps them forever).
+	RateLimitIdleTTL time.Duration
+
+	// MaxConcurrentPerClient caps in-flight requests per client key (0, the
+	// default, = unlimited and no X-Gateway-Load header)
+	MaxConcurrentPerClient int
+	// LoadHintHighRatio is the share of MaxConcurrentPerClient at which the
+	// X-Gateway-Load response header reports "high".
+	LoadHintHighRatio float64
+
+	// Swagger UI
+	SwaggerUIVersion string // swagger-ui-dist release, e.g. "5.9.0"
+	SwaggerUITheme   string // "light" or "dark"
 }
 
-func getenv(key, def string) string {
-	v := strings.TrimSpace(os.Getenv(key))
-	if v == "" {
-		return def
+// loader reads the settings for one Load: the environment first, then the
+// config file's defaults. Values it cannot parse are collected in problems
+// rather than replaced by defaults.
+type loader struct {
+	defaults map[string]string // environment-style settings from the file
+	services []ServiceConfig   // docs services listed in the file
+	problems []string
+}
+
+func (l *loader) getenv(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
 	}
-	return v
+	if v, ok := l.defaults[key]; ok {
+		return v
+	}
+	return def
+}
+
+// getenvLegacy is getenv for a setting that was renamed; the old name
+// from the flat Flask-era gateway is still honoured, with a warning.
+func (l *loader) getenvLegacy(key, legacy, def string) string {
+	if v := l.getenv(key, ""); v != "" {
+		return v
+	}
+	if v := l.getenv(legacy, ""); v != "" {
+		slog.Warn("[config] deprecated setting", "name", legacy, "use", key)
+		return v
+	}
+	return def
 }
 
 // LocalIP returns the best-effort local IP for service registration.
@@ -42,17 +366,50 @@ func LocalIP() string {
 			return ip.String()
 		}
 	}
-	// Best-effort: pick first non-loopback IPv4.
+	// Best-effort: pick from the interfaces, optionally pinned to one.
+	name := strings.TrimSpace(os.Getenv("NETWORK_INTERFACE"))
+	if ip := pickIP(interfaceAddrs(), name); ip != "" {
+		return ip
+	}
+	if name != "" {
+		slog.Warn("[config] NETWORK_INTERFACE has no usable address", "interface", name)
+	}
+	return "127.0.0.1"
+}
+
+// ifaceAddrs is one network interface with its addresses.
+type ifaceAddrs struct {
+	name  string
+	flags net.Flags
+	addrs []net.Addr
+}
+
+func interfaceAddrs() []ifaceAddrs {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "127.0.0.1"
+		return nil
 	}
+	out := make([]ifaceAddrs, 0, len(ifaces))
 	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+		addrs, _ := iface.Addrs()
+		out = append(out, ifaceAddrs{name: iface.Name, flags: iface.Flags, addrs: addrs})
+	}
+	return out
+}
+
+// pickIP returns the first IPv4 address of an up, non-loopback interface
+// (only the one named only, if set), falling back to a global IPv6 address
+// for IPv6-only hosts. "" means none qualifies.
+func pickIP(ifaces []ifaceAddrs, only string) string {
+	var v6 string
+	for _, iface := range ifaces {
+		if only != "" && iface.name != only {
 			continue
 		}
-		addrs, _ := iface.Addrs()
-		for _, a := range addrs {
+		if iface.flags&net.FlagUp == 0 || (only == "" && iface.flags&net.FlagLoopback != 0) {
+			continue
+		}
+		for _, a := range iface.addrs {
 			var ip net.IP
 			switch v := a.(type) {
 			case *net.IPNet:
@@ -63,52 +420,426 @@ func LocalIP() string {
 			if ip == nil {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil {
-				continue
+			if ip4 := ip.To4(); ip4 != nil {
+				return ip4.String()
+			}
+			// Link-local addresses need a zone, which URLs can't carry
+			if v6 == "" && !ip.IsLinkLocalUnicast() {
+				v6 = ip.String()
 			}
-			return ip.String()
 		}
 	}
-	return "127.0.0.1"
+	return v6
+}
+
+// parseBad records that key's value s is not a valid kind.
+func (l *loader) parseBad(key, kind, s string) {
+	l.problems = append(l.problems, fmt.Sprintf("%s: invalid %s %q", key, kind, s))
+}
+
+func (l *loader) getenvInt(key string, def int) int {
+	s := l.getenv(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		l.parseBad(key, "integer", s)
+		return def
+	}
+	return v
+}
+
+func (l *loader) getenvUint32(key string, def uint32) uint32 {
+	s := l.getenv(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		l.parseBad(key, "unsigned integer", s)
+		return def
+	}
+	return uint32(v)
+}
+
+func (l *loader) getenvInt64(key string, def int64) int64 {
+	s := l.getenv(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		l.parseBad(key, "integer", s)
+		return def
+	}
+	return v
 }
 
-func mustParseDuration(s string, def time.Duration) time.Duration {
-	s = strings.TrimSpace(s)
+func (l *loader) getenvFloat(key string, def float64) float64 {
+	s := l.getenv(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		l.parseBad(key, "number", s)
+		return def
+	}
+	return v
+}
+
+func (l *loader) getenvDuration(key string, def time.Duration) time.Duration {
+	s := l.getenv(key, "")
 	if s == "" {
 		return def
 	}
 	d, err := time.ParseDuration(s)
 	if err != nil {
+		l.parseBad(key, "duration", s)
 		return def
 	}
 	return d
 }
 
-// Load reads environment variables and returns a Config.
-func Load() Config {
-	port := getenv("PORT", "8080")
-	appName := getenv("APP_NAME", "API-GATEWAY")
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// splitPairs parses "k1=v1,k2=v2", skipping malformed items.
+func splitPairs(s string) map[string]string {
+	out := map[string]string{}
+	for _, item := range splitList(s) {
+		k, v, ok := strings.Cut(item, "=")
+		if k = strings.TrimSpace(k); ok && k != "" {
+			out[k] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// parseServices parses DOCS_SERVICES entries ("name=APP,url=...,spec=...")
+// in order, skipping invalid ones.
+func parseServices(s string) []ServiceConfig {
+	var out []ServiceConfig
+	for _, item := range strings.Split(s, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.Split(item, ",")
+		name, app, _ := strings.Cut(parts[0], "=")
+		svc := ServiceConfig{Name: strings.TrimSpace(name), AppName: strings.TrimSpace(app), SpecPath: "/openapi.json"}
+		for k, v := range splitPairs(strings.Join(parts[1:], ",")) {
+			switch k {
+			case "url":
+				svc.BaseURL = strings.TrimRight(v, "/")
+			case "spec":
+				svc.SpecPath = specPath(v)
+			default:
+				slog.Warn("[config] DOCS_SERVICES: unknown option", "service", svc.Name, "option", k)
+			}
+		}
+		if !validServiceName(svc.Name) || (svc.AppName == "" && svc.BaseURL == "") {
+			slog.Warn("[config] DOCS_SERVICES: skipping invalid entry", "entry", item)
+			continue
+		}
+		out = append(out, svc)
+	}
+	return out
+}
+
+// validServiceName reports whether name is usable as a URL path segment.
+func validServiceName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validMetadataKey reports whether key can be a metadata element name in
+// the XML registration payload.
+func validMetadataKey(key string) bool {
+	for i, c := range key {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		if !letter && (i == 0 || !(c >= '0' && c <= '9' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return key != "" && !strings.HasPrefix(strings.ToLower(key), "xml")
+}
+
+// splitPatternMap parses "pattern=value;pattern=value", as used by
+// SLO_SUCCESS_CODES (value: status codes) and PROXY_ROUTES (value: target
+// and options). Items without a pattern or "=" are skipped.
+func splitPatternMap(s string) map[string]string {
+	out := map[string]string{}
+	for _, item := range strings.Split(s, ";") {
+		pattern, value, ok := strings.Cut(item, "=")
+		if pattern = strings.TrimSpace(pattern); ok && pattern != "" {
+			out[pattern] = strings.TrimSpace(value)
+		}
+	}
+	return out
+}
+
+// splitUserinfo removes user:pass@ from rawURL, so credentials never end
+// up in logs, and returns them separately.
+func splitUserinfo(rawURL string) (stripped, user, pass string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, "", ""
+	}
+	user = u.User.Username()
+	pass, _ = u.User.Password()
+	u.User = nil
+	return u.String(), user, pass
+}
+
+func clampPercent(v int) int {
+	return min(max(v, 0), 100)
+}
+
+// specPath normalizes an OpenAPI spec path to start with a slash.
+func specPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// Load reads environment variables, falling back to the SetFile file, and
+// returns a validated Config. A *ValidationError lists every invalid value
+// at once.
+func Load() (Config, error) {
+	l := &loader{}
+	var problems []string
+	if configFile != "" {
+		var err error
+		l.defaults, l.services, problems, err = readStructuredFile(configFile)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	port := l.getenv("PORT", "8080")
+	appName := l.getenv("APP_NAME", "API-GATEWAY")
 	ip := LocalIP()
-	instanceID := getenv("INSTANCE_ID", fmt.Sprintf("%s:%s:%s", strings.ToLower(appName), ip, port))
+	instanceID := l.getenv("INSTANCE_ID", fmt.Sprintf("%s:%s:%s", strings.ToLower(appName), ip, port))
+
+	agentAppName := l.getenvLegacy("AGENT_APP_NAME", "FLASK_APP_NAME", "AGENT-SERVICE")
+	agentBaseURL := strings.TrimRight(l.getenvLegacy("AGENT_BASE_URL", "FLASK_BASE_URL", ""), "/")
 
-	agentAppName := getenv("AGENT_APP_NAME", "")
-	if agentAppName == "" {
-		agentAppName = getenv("FLASK_APP_NAME", "AGENT-SERVICE")
+	agentSpecPath := specPath(l.getenv("AGENT_OPENAPI_PATH", "/openapi.json"))
+	services := append([]ServiceConfig{{
+		Name:     "agent-service",
+		AppName:  agentAppName,
+		BaseURL:  agentBaseURL,
+		SpecPath: agentSpecPath,
+	}}, mergeServices(l.services, parseServices(l.getenv("DOCS_SERVICES", "")))...)
+
+	agentStreamAppName := l.getenv("AGENT_STREAM_APP_NAME", agentAppName)
+	agentStreamBaseURL := strings.TrimRight(l.getenv("AGENT_STREAM_BASE_URL", ""), "/")
+	if agentStreamBaseURL == "" && agentStreamAppName == agentAppName {
+		agentStreamBaseURL = agentBaseURL
+	}
+
+	heartbeatStatus := strings.ToUpper(l.getenv("EUREKA_HEARTBEAT_STATUS", "UP"))
+	if heartbeatStatus == "NONE" {
+		heartbeatStatus = ""
 	}
-	agentBaseURL := strings.TrimRight(getenv("AGENT_BASE_URL", ""), "/")
-	if agentBaseURL == "" {
-		agentBaseURL = strings.TrimRight(getenv("FLASK_BASE_URL", ""), "/")
+
+	swaggerUIVersion := l.getenv("SWAGGER_UI_VERSION", swagger.DefaultVersion)
+	if !swagger.ValidVersion(swaggerUIVersion) {
+		slog.Warn("[config] invalid SWAGGER_UI_VERSION", "value", swaggerUIVersion, "using", swagger.DefaultVersion)
+		swaggerUIVersion = swagger.DefaultVersion
+	}
+	swaggerUITheme := strings.ToLower(l.getenv("SWAGGER_UI_THEME", "light"))
+	if !swagger.ValidTheme(swaggerUITheme) {
+		slog.Warn("[config] invalid SWAGGER_UI_THEME", "value", swaggerUITheme, "using", "light")
+		swaggerUITheme = "light"
+	}
+
+	// A ratio outside (0, 1] would report "high" for every request or never
+	loadHintHighRatio := l.getenvFloat("LOAD_HINT_HIGH_RATIO", 0.8)
+	if !(loadHintHighRatio > 0 && loadHintHighRatio <= 1) {
+		slog.Warn("[config] invalid LOAD_HINT_HIGH_RATIO", "value", loadHintHighRatio, "using", 0.8)
+		loadHintHighRatio = 0.8
 	}
 
-	return Config{
-		Port:            port,
-		EurekaServerURL: strings.TrimRight(getenv("EUREKA_SERVER_URL", "http://localhost:8761/eureka"), "/"),
-		AppName:         appName,
-		InstanceID:      instanceID,
-		PreferIP:        strings.ToLower(getenv("PREFER_IP", "true")) == "true",
-		AgentAppName:    agentAppName,
-		AgentBaseURL:    agentBaseURL,
-		RequestTimeout:  mustParseDuration(getenv("REQUEST_TIMEOUT", "120s"), 120*time.Second),
+	// EUREKA_SERVER_URL lists the peers of an HA cluster, comma-separated;
+	// credentials are taken from the first one that carries them.
+	var eurekaURLs []string
+	var eurekaUser, eurekaPass string
+	for _, raw := range splitList(l.getenv("EUREKA_SERVER_URL", "http://localhost:8761/eureka")) {
+		u, user, pass := splitUserinfo(strings.TrimRight(raw, "/"))
+		if eurekaUser == "" {
+			eurekaUser, eurekaPass = user, pass
+		}
+		eurekaURLs = append(eurekaURLs, u)
+	}
+
+	statusPagePath := specPath(l.getenv("STATUS_PAGE_PATH", "/info"))
+	// Paths open without credentials unless API_KEY_PUBLIC_PATHS or
+	// JWT_PUBLIC_PATHS say otherwise
+	publicPaths := "/,/health,/health/*,/ready,/version,/openapi.json,/api-docs/*,/swagger-ui," + statusPagePath
+	requestTimeout := l.getenvDuration("REQUEST_TIMEOUT", 120*time.Second)
+
+	cfg := Config{
+		Port:             port,
+		EurekaServerURLs: eurekaURLs,
+		EurekaUsername:   l.getenv("EUREKA_USERNAME", eurekaUser),
+		EurekaPassword:   l.getenv("EUREKA_PASSWORD", eurekaPass),
+		AppName:          appName,
+		InstanceID:       instanceID,
+		PreferIP:         strings.ToLower(l.getenv("PREFER_IP", "true")) == "true",
+		StatusPagePath:   statusPagePath,
+		AgentAppName:     agentAppName,
+		AgentBaseURL:     agentBaseURL,
+		AgentSpecPath:    agentSpecPath,
+		Services:         services,
+		RequestTimeout:   requestTimeout,
+		AdminTimeout:     l.getenvDuration("ADMIN_TIMEOUT", 10*time.Second),
+
+		UpstreamDialTimeout:           l.getenvDuration("UPSTREAM_DIAL_TIMEOUT", 30*time.Second),
+		UpstreamTLSHandshakeTimeout:   l.getenvDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		UpstreamResponseHeaderTimeout: l.getenvDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 0),
+
+		GatewayTimeout:       l.getenvDuration("GATEWAY_TIMEOUT", requestTimeout+5*time.Second),
+		GatewayTimeoutExempt: splitList(l.getenv("GATEWAY_TIMEOUT_EXEMPT", "/agent/stream")),
+
+		AgentStreamAppName:  agentStreamAppName,
+		AgentStreamBaseURL:  agentStreamBaseURL,
+		AgentStaticWeight:   clampPercent(l.getenvInt("AGENT_STATIC_WEIGHT", 0)),
+		ShadowBaseURL:       strings.TrimRight(l.getenv("SHADOW_BASE_URL", ""), "/"),
+		ShadowPercent:       clampPercent(l.getenvInt("SHADOW_PERCENT", 0)),
+		AgentRequestHeaders: splitPairs(l.getenv("AGENT_REQUEST_HEADERS", "")),
+		AgentRedactFields:   splitList(l.getenv("AGENT_REDACT_FIELDS", "")),
+
+		ShadowForwardCredentials: strings.ToLower(l.getenv("SHADOW_FORWARD_CREDENTIALS", "false")) == "true",
+
+		EurekaHeartbeatStatus:    heartbeatStatus,
+		EurekaHeartbeatLastDirty: strings.ToLower(l.getenv("EUREKA_HEARTBEAT_LAST_DIRTY", "true")) == "true",
+
+		EurekaCacheTTL:       l.getenvDuration("EUREKA_CACHE_TTL", 30*time.Second),
+		EurekaFormatHint:     strings.ToLower(l.getenv("EUREKA_FORMAT_HINT", "none")),
+		EurekaRegister:       strings.ToLower(l.getenv("EUREKA_REGISTER", "true")) == "true",
+		EurekaRegisterFormat: strings.ToLower(l.getenv("EUREKA_REGISTER_FORMAT", "xml")),
+		EurekaMetadata:       splitPairs(l.getenv("EUREKA_METADATA", "")),
+
+		EurekaDeltaInterval:     l.getenvDuration("EUREKA_DELTA_INTERVAL", 0),
+		EurekaFullFetchInterval: l.getenvDuration("EUREKA_FULL_FETCH_INTERVAL", 5*time.Minute),
+
+		ExpectContinueTimeout: l.getenvDuration("EXPECT_CONTINUE_TIMEOUT", time.Second),
+
+		CBInterval:            l.getenvDuration("CB_INTERVAL", 10*time.Second),
+		CBTimeout:             l.getenvDuration("CB_TIMEOUT", 30*time.Second),
+		CBStrategy:            strings.ToLower(l.getenv("CB_STRATEGY", "consecutive")),
+		CBConsecutiveFailures: l.getenvUint32("CB_CONSECUTIVE_FAILURES", 3),
+		CBFailureRatio:        l.getenvFloat("CB_FAILURE_RATIO", 0.5),
+		CBMinRequests:         l.getenvUint32("CB_MIN_REQUESTS", 10),
+
+		RetryMaxAttempts:   l.getenvInt("RETRY_MAX_ATTEMPTS", 1),
+		RetryBackoff:       l.getenvDuration("RETRY_BACKOFF", 100*time.Millisecond),
+		RetryJitter:        l.getenvFloat("RETRY_JITTER", 0.2),
+		RetryNonIdempotent: strings.ToLower(l.getenv("RETRY_NON_IDEMPOTENT", "false")) == "true",
+
+		UpstreamFailoverAttempts: l.getenvInt("UPSTREAM_FAILOVER_ATTEMPTS", 2),
+
+		LBStrategy:    strings.ToLower(l.getenv("LB_STRATEGY", "round_robin")),
+		LBAffinityKey: l.getenv("LB_AFFINITY_KEY", "header:X-Session-Id"),
+
+		HealthCheckInterval:           l.getenvDuration("HEALTH_CHECK_INTERVAL", 0),
+		HealthCheckUnhealthyThreshold: l.getenvInt("HEALTH_CHECK_UNHEALTHY_THRESHOLD", 3),
+
+		AvailabilityZone: strings.TrimSpace(l.getenv("AVAILABILITY_ZONE", "")),
+
+		ProxyCopyBufferBytes: l.getenvInt("PROXY_COPY_BUFFER_BYTES", 32*1024),
+		StreamMaxBufferBytes: l.getenvInt64("STREAM_MAX_BUFFER_BYTES", 4<<20),
+
+		DeregisterTimeout:   l.getenvDuration("EUREKA_DEREGISTER_TIMEOUT", 3*time.Second),
+		EurekaDrainPeriod:   l.getenvDuration("EUREKA_DRAIN_PERIOD", 0),
+		ShutdownQuietPeriod: l.getenvDuration("PRE_STOP_DELAY", l.getenvDuration("SHUTDOWN_QUIET_PERIOD", 5*time.Second)),
+		ShutdownTimeout:     l.getenvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+
+		UpstreamAllowedHosts: splitList(l.getenv("UPSTREAM_ALLOWED_HOSTS", "")),
+
+		UpstreamCAFile:             l.getenv("UPSTREAM_CA_FILE", ""),
+		UpstreamInsecureSkipVerify: strings.ToLower(l.getenv("UPSTREAM_INSECURE_SKIP_VERIFY", "false")) == "true",
+
+		UpstreamMaxIdleConns:        l.getenvInt("UPSTREAM_MAX_IDLE_CONNS", 100),
+		UpstreamMaxIdleConnsPerHost: l.getenvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32),
+		UpstreamMaxConnsPerHost:     l.getenvInt("UPSTREAM_MAX_CONNS_PER_HOST", 0),
+		UpstreamIdleConnTimeout:     l.getenvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+
+		TrustedProxies: splitList(l.getenv("TRUSTED_PROXIES", "")),
+
+		AdminToken: l.getenv("ADMIN_TOKEN", ""),
+
+		APIKeys:           splitList(l.getenv("API_KEYS", "")),
+		APIKeyPublicPaths: splitList(l.getenv("API_KEY_PUBLIC_PATHS", publicPaths)),
+
+		JWTJWKSURL:       l.getenv("JWT_JWKS_URL", ""),
+		JWTIssuer:        l.getenv("JWT_ISSUER", ""),
+		JWTAudience:      l.getenv("JWT_AUDIENCE", ""),
+		JWTPublicPaths:   splitList(l.getenv("JWT_PUBLIC_PATHS", publicPaths)),
+		JWKSRefresh:      l.getenvDuration("JWT_JWKS_REFRESH", time.Hour),
+		JWTForwardUserID: strings.ToLower(l.getenv("JWT_FORWARD_USER_ID", "false")) == "true",
+
+		AccessLogSampleRate: l.getenvInt("ACCESS_LOG_SAMPLE_RATE", 1),
+
+		LogTimeFormat: strings.ToLower(l.getenv("LOG_TIME_FORMAT", "rfc3339")),
+		LogUTC:        strings.ToLower(l.getenv("LOG_UTC", "true")) == "true",
+		LogLevel:      strings.ToLower(l.getenv("LOG_LEVEL", "info")),
+		LogFormat:     strings.ToLower(l.getenv("LOG_FORMAT", "text")),
+
+		SLOSuccessCodes: splitPatternMap(l.getenv("SLO_SUCCESS_CODES", "")),
+
+		ProxyRoutes: splitPatternMap(l.getenv("PROXY_ROUTES", "")),
+
+		InstanceHeader: strings.ToLower(l.getenv("INSTANCE_HEADER", "false")) == "true",
+		UpstreamHeader: strings.ToLower(l.getenv("UPSTREAM_HEADER", "false")) == "true",
+
+		GzipLevel: l.getenvInt("GZIP_LEVEL", 5),
+
+		ErrorTemplateDir: l.getenv("ERROR_TEMPLATE_DIR", ""),
+
+		DocsCacheTTL:    l.getenvDuration("DOCS_CACHE_TTL", 30*time.Second),
+		DocsAllowOrigin: l.getenv("DOCS_ALLOW_ORIGIN", "*"),
+		DocsMaxAge:      l.getenvDuration("DOCS_MAX_AGE", time.Minute),
+
+		DependencyCacheTTL: l.getenvDuration("DEPENDENCY_CACHE_TTL", 5*time.Second),
+
+		MaxConnections: l.getenvInt("MAX_CONNECTIONS", 0),
+
+		MaxURLLength: l.getenvInt("MAX_URL_LENGTH", 8192),
+
+		MaxRequestBody: l.getenvInt64("MAX_REQUEST_BODY", 10<<20),
+
+		RateLimitRPS:     l.getenvFloat("RATE_LIMIT_RPS", 100),
+		RateLimitBurst:   l.getenvInt("RATE_LIMIT_BURST", 200),
+		RateLimitIdleTTL: l.getenvDuration("RATE_LIMIT_IDLE_TTL", 10*time.Minute),
+
+		MaxConcurrentPerClient: l.getenvInt("MAX_CONCURRENT_PER_CLIENT", 0),
+		LoadHintHighRatio:      loadHintHighRatio,
+
+		SwaggerUIVersion: swaggerUIVersion,
+		SwaggerUITheme:   swaggerUITheme,
+	}
+	problems = append(problems, l.problems...)
+	if problems = append(problems, cfg.validate()...); len(problems) > 0 {
+		return cfg, &ValidationError{Problems: problems}
 	}
+	return cfg, nil
 }
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"my_app/api-gateway/internal/logging"
+)
+
+// fileConfig is the structured file named by --config or GATEWAY_CONFIG, in
+// JSON or YAML, the gateway's only config file. Every value is a default:
+// the matching environment variable wins. Settings holds any other
+// variable by its environment name (e.g. "API_KEYS"); the typed fields
+// take precedence over it. The file is read again on every reload, so values it provides
+// can be changed without a restart.
+type fileConfig struct {
+	Port           int    `json:"port"`
+	AppName        string `json:"app_name"`
+	RequestTimeout string `json:"request_timeout"`
+	Eureka         struct {
+		URL      string `json:"url"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		CacheTTL string `json:"cache_ttl"`
+		Register *bool  `json:"register"`
+	} `json:"eureka"`
+	RateLimit struct {
+		RPS     *float64 `json:"rps"`
+		Burst   *int     `json:"burst"`
+		IdleTTL string   `json:"idle_ttl"`
+	} `json:"rate_limit"`
+	Services []ServiceConfig   `json:"services"`
+	Settings map[string]string `json:"settings"`
+}
+
+// ValidationError lists every invalid setting found by Load.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// configFile is the structured file set by SetFile.
+var configFile string
+
+// SetFile makes Load read defaults from the file at path ("" for none):
+// YAML if it is named *.yaml or *.yml, JSON otherwise.
+func SetFile(path string) {
+	configFile = strings.TrimSpace(path)
+}
+
+// readStructuredFile decodes path into environment-style defaults plus its
+// services, returning the problems with individual values.
+func readStructuredFile(path string) (map[string]string, []ServiceConfig, []string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	var fc fileConfig
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var problems []string
+	values := map[string]string{}
+	for k, v := range fc.Settings {
+		if v != "" {
+			values[strings.TrimSpace(k)] = v
+		}
+	}
+	set := func(key, v string) {
+		if v != "" {
+			values[key] = v
+		}
+	}
+	setDuration := func(key, field, v string) {
+		if v == "" {
+			return
+		}
+		if _, err := time.ParseDuration(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid duration %q", field, v))
+			return
+		}
+		values[key] = v
+	}
+	if fc.Port != 0 {
+		set("PORT", strconv.Itoa(fc.Port))
+	}
+	set("APP_NAME", fc.AppName)
+	setDuration("REQUEST_TIMEOUT", "request_timeout", fc.RequestTimeout)
+	set("EUREKA_SERVER_URL", fc.Eureka.URL)
+	set("EUREKA_USERNAME", fc.Eureka.Username)
+	set("EUREKA_PASSWORD", fc.Eureka.Password)
+	setDuration("EUREKA_CACHE_TTL", "eureka.cache_ttl", fc.Eureka.CacheTTL)
+	if fc.Eureka.Register != nil {
+		set("EUREKA_REGISTER", strconv.FormatBool(*fc.Eureka.Register))
+	}
+	if fc.RateLimit.RPS != nil {
+		set("RATE_LIMIT_RPS", strconv.FormatFloat(*fc.RateLimit.RPS, 'g', -1, 64))
+	}
+	if fc.RateLimit.Burst != nil {
+		set("RATE_LIMIT_BURST", strconv.Itoa(*fc.RateLimit.Burst))
+	}
+	setDuration("RATE_LIMIT_IDLE_TTL", "rate_limit.idle_ttl", fc.RateLimit.IdleTTL)
+
+	services := make([]ServiceConfig, 0, len(fc.Services))
+	for _, svc := range fc.Services {
+		svc.BaseURL = strings.TrimRight(svc.BaseURL, "/")
+		if svc.SpecPath == "" {
+			svc.SpecPath = "/openapi.json"
+		}
+		svc.SpecPath = specPath(svc.SpecPath)
+		services = append(services, svc)
+	}
+	return values, services, problems, nil
+}
+
+// yamlToJSON re-encodes a YAML document as JSON, so YAML files are decoded
+// with the same field names and unknown-field checks as JSON ones.
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]interface{}{} // empty file
+	}
+	return json.Marshal(v)
+}
+
+// mergeServices appends extra to base, replacing entries of the same name.
+func mergeServices(base, extra []ServiceConfig) []ServiceConfig {
+	out := append([]ServiceConfig(nil), base...)
+	for _, svc := range extra {
+		replaced := false
+		for i := range out {
+			if out[i].Name == svc.Name {
+				out[i], replaced = svc, true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
+
+// validate returns a description of every invalid value in c.
+func (c Config) validate() []string {
+	var problems []string
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT: %q is not a TCP port", c.Port))
+	}
+	if len(c.EurekaServerURLs) == 0 {
+		problems = append(problems, "EUREKA_SERVER_URL: no peers")
+	}
+	for _, u := range c.EurekaServerURLs {
+		if !absoluteURL(u) {
+			problems = append(problems, fmt.Sprintf("EUREKA_SERVER_URL: %q is not an http(s) URL", u))
+		}
+	}
+	if c.RequestTimeout <= 0 {
+		problems = append(problems, "REQUEST_TIMEOUT: must be positive")
+	}
+	if c.RateLimitRPS <= 0 {
+		problems = append(problems, "RATE_LIMIT_RPS: must be positive")
+	}
+	if c.RateLimitBurst < 1 {
+		problems = append(problems, "RATE_LIMIT_BURST: must be at least 1")
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL: %q is not debug, info, warn or error", c.LogLevel))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT: %q is not text or json", c.LogFormat))
+	}
+	switch c.LogTimeFormat {
+	case "rfc3339", "rfc3339nano", "unixms":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_TIME_FORMAT: %q is not rfc3339, rfc3339nano or unixms", c.LogTimeFormat))
+	}
+	switch c.CBStrategy {
+	case "consecutive":
+		if c.CBConsecutiveFailures < 1 {
+			problems = append(problems, "CB_CONSECUTIVE_FAILURES: must be at least 1")
+		}
+	case "ratio":
+		if !(c.CBFailureRatio > 0 && c.CBFailureRatio <= 1) {
+			problems = append(problems, fmt.Sprintf("CB_FAILURE_RATIO: %v is not in (0, 1]", c.CBFailureRatio))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("CB_STRATEGY: %q is not consecutive or ratio", c.CBStrategy))
+	}
+	if c.RetryMaxAttempts < 1 {
+		problems = append(problems, "RETRY_MAX_ATTEMPTS: must be at least 1")
+	}
+	if c.GzipLevel < 0 || c.GzipLevel > 9 {
+		problems = append(problems, fmt.Sprintf("GZIP_LEVEL: %d is not 0 (off) to 9", c.GzipLevel))
+	}
+	if c.EurekaRegisterFormat != "json" && c.EurekaRegisterFormat != "xml" {
+		problems = append(problems, fmt.Sprintf("EUREKA_REGISTER_FORMAT: %q is not json or xml", c.EurekaRegisterFormat))
+	}
+	switch c.EurekaFormatHint {
+	case "none", "suffix", "query":
+	default:
+		problems = append(problems, fmt.Sprintf("EUREKA_FORMAT_HINT: %q is not none, suffix or query", c.EurekaFormatHint))
+	}
+	for name, n := range map[string]int{
+		"UPSTREAM_MAX_IDLE_CONNS":          c.UpstreamMaxIdleConns,
+		"UPSTREAM_MAX_IDLE_CONNS_PER_HOST": c.UpstreamMaxIdleConnsPerHost,
+		"UPSTREAM_MAX_CONNS_PER_HOST":      c.UpstreamMaxConnsPerHost,
+	} {
+		if n < 0 {
+			problems = append(problems, name+": must not be negative")
+		}
+	}
+	if c.UpstreamCAFile != "" {
+		if _, err := os.Stat(c.UpstreamCAFile); err != nil {
+			problems = append(problems, fmt.Sprintf("UPSTREAM_CA_FILE: %v", err))
+		}
+	}
+	switch c.LBStrategy {
+	case "round_robin", "random", "least_conn", "affinity":
+	default:
+		problems = append(problems, fmt.Sprintf("LB_STRATEGY: %q is not round_robin, random, least_conn or affinity", c.LBStrategy))
+	}
+	if name, ok := strings.CutPrefix(c.LBAffinityKey, "header:"); !(ok && name != "") && c.LBAffinityKey != "ip" {
+		problems = append(problems, fmt.Sprintf("LB_AFFINITY_KEY: %q is not header:<Name> or ip", c.LBAffinityKey))
+	}
+	for k := range c.EurekaMetadata {
+		if !validMetadataKey(k) {
+			problems = append(problems, fmt.Sprintf("EUREKA_METADATA: %q is not a valid key", k))
+		}
+	}
+	if c.DocsMaxAge < 0 {
+		problems = append(problems, "DOCS_MAX_AGE: must not be negative")
+	}
+	if c.EurekaDeltaInterval < 0 {
+		problems = append(problems, "EUREKA_DELTA_INTERVAL: must not be negative")
+	}
+	if c.EurekaDeltaInterval > 0 && c.EurekaFullFetchInterval < c.EurekaDeltaInterval {
+		problems = append(problems, "EUREKA_FULL_FETCH_INTERVAL: must not be shorter than EUREKA_DELTA_INTERVAL")
+	}
+	if c.HealthCheckInterval < 0 {
+		problems = append(problems, "HEALTH_CHECK_INTERVAL: must not be negative")
+	}
+	if c.HealthCheckUnhealthyThreshold < 1 {
+		problems = append(problems, "HEALTH_CHECK_UNHEALTHY_THRESHOLD: must be at least 1")
+	}
+	seen := map[string]bool{}
+	for _, svc := range c.Services {
+		switch {
+		case !validServiceName(svc.Name):
+			problems = append(problems, fmt.Sprintf("services: invalid name %q", svc.Name))
+		case seen[svc.Name]:
+			problems = append(problems, fmt.Sprintf("services: duplicate name %q", svc.Name))
+		}
+		seen[svc.Name] = true
+		if svc.AppName == "" && svc.BaseURL == "" {
+			problems = append(problems, fmt.Sprintf("services %s: needs app or url", svc.Name))
+		}
+		if svc.BaseURL != "" && !absoluteURL(svc.BaseURL) {
+			problems = append(problems, fmt.Sprintf("services %s: %q is not an http(s) URL", svc.Name, svc.BaseURL))
+		}
+	}
+	return problems
+}
+
+func absoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// safeFields lists the Config fields Redacted shows as they are. Any other
+// field, including ones added later, is redacted until it is listed here,
+// so a new secret can't leak by default.
+var safeFields = map[string]bool{
+	"Port": true, "EurekaServerURLs": true, "AppName": true, "InstanceID": true,
+	"PreferIP": true, "StatusPagePath": true, "EurekaUsername": true,
+	"EurekaHeartbeatStatus": true, "EurekaHeartbeatLastDirty": true, "EurekaCacheTTL": true,
+	"EurekaDeltaInterval": true, "EurekaFullFetchInterval": true,
+	"EurekaFormatHint": true, "EurekaRegister": true, "EurekaRegisterFormat": true,
+	"AgentAppName": true, "AgentSpecPath": true, "AgentStaticWeight": true,
+	"AgentStreamAppName": true, "ShadowPercent": true, "AgentRedactFields": true,
+	"UpstreamDialTimeout": true, "UpstreamTLSHandshakeTimeout": true,
+	"UpstreamResponseHeaderTimeout": true, "RequestTimeout": true, "AdminTimeout": true,
+	"GatewayTimeout": true, "GatewayTimeoutExempt": true, "ExpectContinueTimeout": true,
+	"CBInterval": true, "CBTimeout": true, "CBStrategy": true, "CBConsecutiveFailures": true,
+	"CBFailureRatio": true, "CBMinRequests": true, "RetryMaxAttempts": true,
+	"RetryBackoff": true, "RetryJitter": true, "RetryNonIdempotent": true,
+	"UpstreamFailoverAttempts": true, "LBStrategy": true, "LBAffinityKey": true, "ProxyCopyBufferBytes": true,
+	"HealthCheckInterval": true, "HealthCheckUnhealthyThreshold": true, "AvailabilityZone": true,
+	"StreamMaxBufferBytes": true, "DeregisterTimeout": true, "EurekaDrainPeriod": true, "ShutdownQuietPeriod": true,
+	"ShutdownTimeout": true, "UpstreamAllowedHosts": true, "UpstreamCAFile": true,
+	"UpstreamInsecureSkipVerify": true, "UpstreamMaxIdleConns": true,
+	"UpstreamMaxIdleConnsPerHost": true, "UpstreamMaxConnsPerHost": true,
+	"UpstreamIdleConnTimeout": true, "TrustedProxies": true, "APIKeyPublicPaths": true,
+	"JWTIssuer": true, "JWTAudience": true, "JWTPublicPaths": true,
+	"JWKSRefresh": true, "JWTForwardUserID": true, "AccessLogSampleRate": true,
+	"LogTimeFormat": true, "LogUTC": true, "LogLevel": true, "LogFormat": true,
+	"SLOSuccessCodes": true, "InstanceHeader": true,
+	"UpstreamHeader": true, "GzipLevel": true, "ErrorTemplateDir": true,
+	"DocsCacheTTL": true, "DocsAllowOrigin": true, "DocsMaxAge": true, "DependencyCacheTTL": true, "MaxConnections": true,
+	"MaxURLLength": true, "MaxRequestBody": true, "RateLimitRPS": true,
+	"RateLimitBurst": true, "RateLimitIdleTTL": true, "MaxConcurrentPerClient": true,
+	"LoadHintHighRatio": true, "SwaggerUIVersion": true, "SwaggerUITheme": true,
+}
+
+// urlFields hold upstream URLs, shown with any password replaced by
+// url.Redacted; ProxyRoutes targets and Services base URLs are treated the
+// same way.
+var urlFields = map[string]bool{
+	"AgentBaseURL": true, "AgentStreamBaseURL": true, "ShadowBaseURL": true, "JWTJWKSURL": true,
+}
+
+// Redacted returns c by field name for display. Fields not in safeFields
+// read "[redacted]" when set; durations are rendered like "30s".
+func (c Config) Redacted() map[string]interface{} {
+	out := map[string]interface{}{}
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		name, f := v.Type().Field(i).Name, v.Field(i)
+		switch {
+		case urlFields[name]:
+			out[name] = redactURL(f.String())
+		case name == "ProxyRoutes":
+			routes := make(map[string]string, len(c.ProxyRoutes))
+			for prefix, spec := range c.ProxyRoutes {
+				target, opts, found := strings.Cut(spec, ",")
+				if routes[prefix] = redactURL(target); found {
+					routes[prefix] += "," + opts
+				}
+			}
+			out[name] = routes
+		case name == "Services":
+			var services []ServiceConfig
+			for _, svc := range c.Services {
+				if svc.BaseURL != "" {
+					svc.BaseURL = redactURL(svc.BaseURL)
+				}
+				services = append(services, svc)
+			}
+			out[name] = services
+		case !safeFields[name] && !f.IsZero():
+			out[name] = "[redacted]"
+		case f.Type() == reflect.TypeOf(time.Duration(0)):
+			out[name] = time.Duration(f.Int()).String()
+		default:
+			out[name] = f.Interface()
+		}
+	}
+	return out
+}
+
+// redactURL masks the password in s, if s parses as a URL with one. A
+// value that doesn't parse is redacted whole, as it might still hold one.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "[redacted]"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,186 @@
+package errpage
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// Data is available to error templates.
+type Data struct {
+	Status     int
+	StatusText string
+	// Code is a stable machine-readable identifier, e.g. "circuit_open"
+	Code       string
+	Message    string
+	Path       string
+	RequestID  string
+	RetryAfter string
+}
+
+// renderer executes an error template into a buffer.
+type renderer interface {
+	Execute(wr *bytes.Buffer, data Data) error
+}
+
+type textRenderer struct{ t *texttemplate.Template }
+
+func (r textRenderer) Execute(wr *bytes.Buffer, data Data) error { return r.t.Execute(wr, data) }
+
+type htmlRenderer struct{ t *htmltemplate.Template }
+
+func (r htmlRenderer) Execute(wr *bytes.Buffer, data Data) error { return r.t.Execute(wr, data) }
+
+// funcs are available in JSON templates; "json" quotes a value safely.
+var funcs = texttemplate.FuncMap{
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+}
+
+const defaultJSON = `{"error":{{json .Message}},"code":{{json .Code}},"status":{{.Status}},"path":{{json .Path}}{{if .RequestID}},"request_id":{{json .RequestID}}{{end}}{{if .RetryAfter}},"retry_after":{{json .RetryAfter}}{{end}}}`
+
+const defaultHTML = `<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.StatusText}}</title></head>
+<body>
+    <h1>{{.Status}} {{.StatusText}}</h1>
+    <p>{{.Message}}</p>
+    {{if .RetryAfter}}<p>Please retry in {{.RetryAfter}} seconds.</p>{{end}}
+    {{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>`
+
+var (
+	mu         sync.RWMutex
+	jsonByCode = map[int]renderer{}
+	htmlByCode = map[int]renderer{}
+
+	defaultJSONTmpl renderer = textRenderer{texttemplate.Must(texttemplate.New("json").Funcs(funcs).Parse(defaultJSON))}
+	defaultHTMLTmpl renderer = htmlRenderer{htmltemplate.Must(htmltemplate.New("html").Parse(defaultHTML))}
+)
+
+// LoadDir loads per-status templates named "<status>.json" and
+// "<status>.html" from dir, replacing any previously loaded ones. Statuses
+// without a file keep the built-in defaults.
+func LoadDir(dir string) error {
+	jsonT := map[int]renderer{}
+	htmlT := map[int]renderer{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(e.Name())
+		code, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ext))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		switch ext {
+		case ".json":
+			t, err := texttemplate.New(e.Name()).Funcs(funcs).Parse(string(b))
+			if err != nil {
+				return err
+			}
+			jsonT[code] = textRenderer{t}
+		case ".html":
+			t, err := htmltemplate.New(e.Name()).Parse(string(b))
+			if err != nil {
+				return err
+			}
+			htmlT[code] = htmlRenderer{t}
+		}
+	}
+	mu.Lock()
+	jsonByCode, htmlByCode = jsonT, htmlT
+	mu.Unlock()
+	return nil
+}
+
+// WantsHTML reports whether the client prefers an HTML error page.
+func WantsHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/html") && !strings.Contains(accept, "application/json")
+}
+
+// Write renders a gateway-generated error for status, choosing HTML or
+// JSON based on the request's Accept header. A Retry-After header already
+// set on w is substituted into the template. The error code is derived
+// from status, e.g. "bad_gateway".
+func Write(w http.ResponseWriter, r *http.Request, status int, message string) {
+	WriteCode(w, r, status, "", message)
+}
+
+// WriteCode is Write with an explicit error code ("" derives it from status).
+func WriteCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if code == "" {
+		code = statusCode(status)
+	}
+	data := Data{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Code:       code,
+		Message:    message,
+		Path:       r.URL.Path,
+		RequestID:  r.Header.Get("X-Request-ID"),
+		RetryAfter: w.Header().Get("Retry-After"),
+	}
+
+	html := WantsHTML(r)
+	mu.RLock()
+	t, ok := jsonByCode[status]
+	if html {
+		t, ok = htmlByCode[status]
+	}
+	mu.RUnlock()
+	if !ok {
+		t = defaultJSONTmpl
+		if html {
+			t = defaultHTMLTmpl
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		slog.Error("[errpage] render failed", "status", status, "err", err)
+		buf.Reset()
+		_ = defaultJSONTmpl.Execute(&buf, data)
+		html = false
+	}
+
+	if html {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// statusCode turns a status into a code such as "service_unavailable".
+func statusCode(status int) string {
+	text := strings.ToLower(http.StatusText(status))
+	if text == "" {
+		return "error"
+	}
+	return strings.Join(strings.FieldsFunc(text, func(c rune) bool {
+		return (c < 'a' || c > 'z') && (c < '0' || c > '9')
+	}), "_")
+}
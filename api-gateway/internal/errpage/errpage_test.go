@@ -0,0 +1,101 @@
+package errpage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTemplates writes files into a fresh template directory and loads it,
+// restoring the built-in defaults when the test ends.
+func loadTemplates(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadDir(t.TempDir()) })
+}
+
+func TestWriteDefaultJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Retry-After", "3")
+	req := httptest.NewRequest(http.MethodGet, "/agent", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	Write(rec, req, http.StatusTooManyRequests, `rate "limit" exceeded`)
+
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	if body["error"] != `rate "limit" exceeded` || body["status"] != float64(429) || body["path"] != "/agent" ||
+		body["request_id"] != "abc" || body["retry_after"] != "3" {
+		t.Fatalf("body = %v", body)
+	}
+}
+
+func TestWriteDefaultHTMLForBrowsers(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	Write(rec, req, http.StatusBadGateway, "<script>x</script>")
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "502 Bad Gateway") || strings.Contains(body, "<script>") {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestLoadDirOverridesPerStatus(t *testing.T) {
+	loadTemplates(t, map[string]string{
+		"503.json":  `{"oops":{{json .Message}}}`,
+		"503.html":  `<p>down: {{.Message}}</p>`,
+		"notes.txt": `ignored`,
+	})
+
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusServiceUnavailable, "breaker open")
+	if got := rec.Body.String(); got != `{"oops":"breaker open"}` {
+		t.Errorf("503 JSON = %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	Write(rec, req, http.StatusServiceUnavailable, "breaker open")
+	if got := rec.Body.String(); got != `<p>down: breaker open</p>` {
+		t.Errorf("503 HTML = %q", got)
+	}
+
+	// Statuses without a file keep the default
+	rec = httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusBadGateway, "bad")
+	if !strings.Contains(rec.Body.String(), `"status":502`) {
+		t.Errorf("502 = %q, want the default template", rec.Body.String())
+	}
+}
+
+func TestBrokenTemplateFallsBackToDefault(t *testing.T) {
+	loadTemplates(t, map[string]string{"500.json": `{{.Missing}}`})
+
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusInternalServerError, "boom")
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"error":"boom"`) {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package eureka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// instanceCache remembers resolved instance lists per app so proxied
+// requests don't each cost a registry round trip.
+type instanceCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration // 0 disables caching
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is one app's instance list. While the first fetch is running
+// done is open and other callers wait on it instead of hitting Eureka too.
+type cacheEntry struct {
+	done      chan struct{}
+	up        []string
+	err       error
+	fetchedAt time.Time
+}
+
+// SetCacheTTL enables caching of resolved instance lists for ttl
+// (0 disables the cache).
+func (e *Client) SetCacheTTL(ttl time.Duration) {
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	e.cache.ttl = ttl
+	e.cache.entries = make(map[string]*cacheEntry)
+}
+
+// Invalidate drops the cached instance list for appName, so the next
+// resolve asks Eureka again.
+func (e *Client) Invalidate(appName string) {
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	delete(e.cache.entries, strings.ToUpper(appName))
+}
+
+// instances returns appName's UP instance base URLs, from the local
+// registry once SyncRegistry has loaded it, else from the cache when
+// fresh. Concurrent misses for the same app share one fetch.
+func (e *Client) instances(ctx context.Context, appName string) ([]string, error) {
+	if insts, ok := e.registry.lookup(appName); ok {
+		return e.upBaseURLs(appName, insts)
+	}
+	c := &e.cache
+	c.mu.Lock()
+	if c.ttl <= 0 {
+		c.mu.Unlock()
+		return e.fetchInstances(ctx, appName)
+	}
+	key := strings.ToUpper(appName)
+	ent, ok := c.entries[key]
+	if ok {
+		select {
+		case <-ent.done:
+			if time.Since(ent.fetchedAt) >= c.ttl {
+				ok = false // expired: refresh below
+			}
+		default:
+			// fetch in flight: wait for it below
+		}
+	}
+	if !ok {
+		ent = &cacheEntry{done: make(chan struct{})}
+		c.entries[key] = ent
+		c.mu.Unlock()
+
+		// Detached from ctx so one caller giving up doesn't fail the others
+		ent.up, ent.err = e.fetchInstances(context.WithoutCancel(ctx), appName)
+		ent.fetchedAt = time.Now()
+		close(ent.done)
+		if ent.err != nil {
+			c.mu.Lock()
+			if c.entries[key] == ent {
+				delete(c.entries, key)
+			}
+			c.mu.Unlock()
+		}
+		return ent.up, ent.err
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ent.done:
+		return ent.up, ent.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
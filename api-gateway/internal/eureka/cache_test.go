@@ -0,0 +1,123 @@
+package eureka
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRegistry serves one UP AGENT instance and counts the requests.
+// Each request is announced on arrived, if set, and then held until
+// release is closed.
+func countingRegistry(t *testing.T, hits *atomic.Int32, arrived chan<- struct{}, release <-chan struct{}) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if arrived != nil {
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"application": {"name": "AGENT", "instance": {"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}}}`)
+	}))
+	t.Cleanup(srv.Close)
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	e.SetCacheTTL(time.Minute)
+	return e
+}
+
+func TestCacheServesWithinTTLAndRefetchesAfter(t *testing.T) {
+	var hits atomic.Int32
+	e := countingRegistry(t, &hits, nil, nil)
+	for i := 0; i < 3; i++ {
+		if up, err := e.BaseURLs(context.Background(), "agent"); err != nil || len(up) != 1 {
+			t.Fatalf("BaseURLs = %v, %v", up, err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("registry asked %d times within the TTL, want 1", n)
+	}
+
+	e.cache.mu.Lock()
+	e.cache.entries["AGENT"].fetchedAt = time.Now().Add(-2 * time.Minute)
+	e.cache.mu.Unlock()
+	e.BaseURLs(context.Background(), "AGENT")
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("registry asked %d times after the entry expired, want 2", n)
+	}
+}
+
+func TestCacheSharesOneFetchBetweenConcurrentMisses(t *testing.T) {
+	var hits atomic.Int32
+	arrived, release := make(chan struct{}, 1), make(chan struct{})
+	e := countingRegistry(t, &hits, arrived, release)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan []string, callers)
+	lookup := func() {
+		defer wg.Done()
+		up, _ := e.BaseURLs(context.Background(), "agent")
+		results <- up
+	}
+	wg.Add(callers)
+	go lookup()
+	<-arrived // the first caller's fetch is in flight
+	for i := 1; i < callers; i++ {
+		go lookup()
+	}
+
+	// A waiter that gives up does not cancel the shared fetch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.BaseURLs(ctx, "agent"); err != context.Canceled {
+		t.Fatalf("cancelled waiter got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for up := range results {
+		if len(up) != 1 || up[0] != "http://10.0.0.1:8000" {
+			t.Fatalf("a caller got %v", up)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("registry asked %d times, want one shared fetch", n)
+	}
+}
+
+func TestInvalidateForcesRefetch(t *testing.T) {
+	var hits atomic.Int32
+	e := countingRegistry(t, &hits, nil, nil)
+	e.BaseURLs(context.Background(), "agent")
+	e.Invalidate("Agent") // app names are case-insensitive
+	e.BaseURLs(context.Background(), "agent")
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("registry asked %d times, want a refetch after Invalidate", n)
+	}
+}
+
+func TestCacheDoesNotKeepFailures(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	e.SetCacheTTL(time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := e.BaseURLs(context.Background(), "agent"); err == nil {
+			t.Fatal("BaseURLs succeeded against a failing registry")
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("registry asked %d times, want the failure retried rather than cached", n)
+	}
+}
@@ -1,83 +1,355 @@
 package eureka
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"my_app/api-gateway/internal/config"
 )
 
 // EurekaClient handles communication with Eureka service registry
 type Client struct {
-	baseURL string
-	client  *http.Client
+	peers  []*peer
+	client *http.Client
+	tracer trace.Tracer
+
+	// readPeer is the index of the peer registry reads start at: the last
+	// one that answered.
+	readPeer atomic.Int32
+
+	// ip is the address last registered, for re-registering with a peer
+	// that lost the lease.
+	ip atomic.Value
+
+	// lastDirty is the lastDirtyTimestamp (ms) sent with the last registration.
+	lastDirty atomic.Int64
+
+	formatHint     string // see SetFormatHint
+	registerFormat string // see SetRegisterFormat
+	username       string // see SetBasicAuth
+	password       string
+
+	// zones maps app names (upper case) to the "zone" in the metadata of
+	// each UP instance, by base URL. An app's entry is replaced every time
+	// its instances are listed, so instances that left are forgotten.
+	zonesMu sync.Mutex
+	zones   map[string]map[string]string
+
+	cache    instanceCache // see SetCacheTTL
+	registry registry      // see SyncRegistry
+}
+
+// SetFormatHint asks the registry for JSON in a way some servers honor
+// when they ignore the Accept header: "suffix" appends ".json" to registry
+// paths, "query" adds "?type=json". Any other value sends plain paths.
+// Responses are decoded by Content-Type either way.
+func (e *Client) SetFormatHint(hint string) {
+	e.formatHint = hint
+}
+
+// SetBasicAuth sends HTTP basic auth credentials with every registry
+// request. An empty username disables it.
+func (e *Client) SetBasicAuth(username, password string) {
+	e.username, e.password = username, password
+}
+
+// SetTransport sets the transport used to reach the peers, e.g. for
+// connection pool limits or a custom CA.
+func (e *Client) SetTransport(t http.RoundTripper) {
+	e.client.Transport = t
 }
 
-// NewEurekaClient creates a new Eureka client
-func NewEurekaClient(baseURL string, timeout time.Duration) *Client {
+// SetRegisterFormat selects the registration payload encoding: "json" or
+// "xml" (the default), for registries that only accept one of them.
+func (e *Client) SetRegisterFormat(format string) {
+	e.registerFormat = format
+}
+
+// registryURL returns the URL for a registry path on base with the format
+// hint applied.
+func (e *Client) registryURL(base, path string) string {
+	switch e.formatHint {
+	case "suffix":
+		return base + path + ".json"
+	case "query":
+		return base + path + "?type=json"
+	}
+	return base + path
+}
+
+// peer is one Eureka server of a (possibly single-node) cluster.
+type peer struct {
+	url  string
+	down atomic.Bool // last request failed
+}
+
+// report logs when the peer starts failing or recovers, not on every call.
+func (p *peer) report(op string, err error) {
+	if err != nil {
+		if !p.down.Swap(true) {
+			slog.Warn("[eureka] peer failed", "peer", p.url, "op", op, "err", err)
+		}
+		return
+	}
+	if p.down.Swap(false) {
+		slog.Info("[eureka] peer ok again", "peer", p.url, "op", op)
+	}
+}
+
+// NewEurekaClient creates a new Eureka client for the given peers.
+// Registration and heartbeats go to every peer; reads use one at a time
+// and fail over to the next.
+func NewEurekaClient(baseURLs []string, timeout time.Duration) *Client {
+	peers := make([]*peer, 0, len(baseURLs))
+	for _, u := range baseURLs {
+		peers = append(peers, &peer{url: strings.TrimRight(u, "/")})
+	}
 	return &Client{
-		baseURL: strings.TrimRight(baseURL, "/"),
-		client:  &http.Client{Timeout: timeout},
+		peers:  peers,
+		client: &http.Client{Timeout: timeout},
 	}
 }
 
-// Register registers this service instance with Eureka
+// Register registers this service instance with every Eureka peer and
+// succeeds if any of them accepted it.
 func (e *Client) Register(ctx context.Context, cfg config.Config, ip string) error {
-	// Eureka Server accepts XML reliably.
-	// POST /eureka/apps/{APP}
-	registerURL := fmt.Sprintf("%s/apps/%s", e.baseURL, strings.ToUpper(cfg.AppName))
-	homePageURL := fmt.Sprintf("http://%s:%s/", ip, cfg.Port)
-	statusPageURL := fmt.Sprintf("http://%s:%s/health", ip, cfg.Port)
-	healthCheckURL := fmt.Sprintf("http://%s:%s/health", ip, cfg.Port)
-
-	payload := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<instance>
-  <instanceId>%s</instanceId>
-  <hostName>%s</hostName>
-  <app>%s</app>
-  <ipAddr>%s</ipAddr>
-  <status>UP</status>
-  <port enabled="true">%s</port>
-  <securePort enabled="false">443</securePort>
-  <homePageUrl>%s</homePageUrl>
-  <statusPageUrl>%s</statusPageUrl>
-  <healthCheckUrl>%s</healthCheckUrl>
-  <dataCenterInfo class="com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo">
-    <name>MyOwn</name>
-  </dataCenterInfo>
-</instance>`, cfg.InstanceID, ip, strings.ToUpper(cfg.AppName), ip, cfg.Port, homePageURL, statusPageURL, healthCheckURL)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, registerURL, strings.NewReader(payload))
+	e.ip.Store(ip)
+	var errs []error
+	for _, p := range e.peers {
+		err := e.registerPeer(ctx, p.url, cfg, ip)
+		p.report("register", err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.url, err))
+		}
+	}
+	if len(errs) == len(e.peers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// registerPeer registers with the peer at base. It is idempotent: if the
+// peer still holds an UP lease for the instance at the same ip:port (e.g.
+// after a fast restart) the lease is renewed instead of registering again,
+// and an "already exists" answer to the registration is treated as
+// success. A lease left behind at another address is replaced by
+// registering anew. A lease left in another status, typically
+// OUT_OF_SERVICE from a shutdown whose deregistration failed, has its
+// status override cleared first: Eureka keeps overrides across
+// registrations, so the new process would otherwise never get traffic.
+func (e *Client) registerPeer(ctx context.Context, base string, cfg config.Config, ip string) error {
+	if held, ok := e.lease(ctx, base, cfg); ok {
+		switch {
+		case !strings.EqualFold(held.Status, "UP"):
+			slog.Info("[eureka] clearing status left by a previous instance", "instance", cfg.InstanceID, "status", held.Status, "peer", base)
+			if err := e.clearStatusPeer(ctx, base, cfg); err != nil {
+				return err
+			}
+		case held.IPAddr == ip && strconv.Itoa(held.Port.Value) == cfg.Port:
+			if status, err := e.renew(ctx, base, cfg, "renew"); err == nil && status >= 200 && status <= 299 {
+				slog.Debug("[eureka] instance already registered, renewed existing lease", "instance", cfg.InstanceID, "peer", base)
+				return nil
+			}
+		}
+	}
+
+	// POST /eureka/apps/{APP}, XML unless SetRegisterFormat asked for JSON
+	registerURL := fmt.Sprintf("%s/apps/%s", base, strings.ToUpper(cfg.AppName))
+	port, err := strconv.Atoi(cfg.Port)
+	if err != nil {
+		return fmt.Errorf("eureka register: invalid port %q: %w", cfg.Port, err)
+	}
+	lastDirty := time.Now().UnixMilli()
+	hostPort := net.JoinHostPort(ip, cfg.Port) // brackets IPv6 addresses
+
+	payload, contentType, err := Instance{
+		InstanceID:         cfg.InstanceID,
+		HostName:           ip,
+		App:                strings.ToUpper(cfg.AppName),
+		IPAddr:             ip,
+		Status:             "UP",
+		Port:               PortInfo{Number: port, Enabled: true},
+		SecurePort:         PortInfo{Number: 443, Enabled: false},
+		HomePageURL:        "http://" + hostPort + "/",
+		StatusPageURL:      "http://" + hostPort + cfg.StatusPagePath,
+		HealthCheckURL:     "http://" + hostPort + "/health",
+		DataCenterInfo:     DefaultDataCenter,
+		Metadata:           registerMetadata(cfg),
+		LastDirtyTimestamp: lastDirty,
+	}.marshal(e.registerFormat)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, registerURL, bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/xml")
-	resp, err := e.client.Do(req)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := e.do(req, "register", cfg.AppName)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 || resp.StatusCode == http.StatusConflict {
+		// 409: a concurrent registration of the same instance won the race
+		e.lastDirty.Store(lastDirty)
 		return nil
 	}
 	b, _ := io.ReadAll(resp.Body)
 	return fmt.Errorf("eureka register failed: %s: %s", resp.Status, string(b))
 }
 
-// Heartbeat sends a heartbeat to Eureka to renew the lease
+// registerMetadata is EurekaMetadata plus the gateway's zone, unless the
+// metadata names one itself.
+func registerMetadata(cfg config.Config) Metadata {
+	if cfg.AvailabilityZone == "" || cfg.EurekaMetadata["zone"] != "" {
+		return Metadata(cfg.EurekaMetadata)
+	}
+	m := Metadata{"zone": cfg.AvailabilityZone}
+	for k, v := range cfg.EurekaMetadata {
+		m[k] = v
+	}
+	return m
+}
+
+// Heartbeat renews the lease with every Eureka peer and fails only if
+// none of them renewed it.
 func (e *Client) Heartbeat(ctx context.Context, cfg config.Config) error {
-	// PUT /eureka/apps/{APP}/{instanceId}
-	u := fmt.Sprintf("%s/apps/%s/%s", e.baseURL, strings.ToUpper(cfg.AppName), cfg.InstanceID)
+	var errs []error
+	for _, p := range e.peers {
+		err := e.heartbeatPeer(ctx, p.url, cfg)
+		p.report("heartbeat", err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.url, err))
+		}
+	}
+	if len(errs) == len(e.peers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// heartbeatPeer renews the lease with the peer at base, registering again
+// if the peer doesn't know the instance, e.g. because it was down when
+// Register ran or restarted since.
+func (e *Client) heartbeatPeer(ctx context.Context, base string, cfg config.Config) error {
+	status, err := e.renew(ctx, base, cfg, "heartbeat")
+	if err != nil {
+		return err
+	}
+	if status >= 200 && status <= 299 {
+		return nil
+	}
+	if ip, _ := e.ip.Load().(string); status == http.StatusNotFound && ip != "" {
+		slog.Info("[eureka] lease unknown, registering again", "peer", base)
+		return e.registerPeer(ctx, base, cfg, ip)
+	}
+	return fmt.Errorf("eureka heartbeat failed: %d %s", status, http.StatusText(status))
+}
+
+// renew sends a lease renewal to the peer at base and returns the response
+// status; Eureka answers 404 when it does not know the instance.
+func (e *Client) renew(ctx context.Context, base string, cfg config.Config, op string) (int, error) {
+	// PUT /eureka/apps/{APP}/{instanceId}?status=UP&lastDirtyTimestamp=...
+	u := fmt.Sprintf("%s/apps/%s/%s", base, strings.ToUpper(cfg.AppName), cfg.InstanceID)
+	q := url.Values{}
+	if cfg.EurekaHeartbeatStatus != "" {
+		q.Set("status", cfg.EurekaHeartbeatStatus)
+	}
+	if ts := e.lastDirty.Load(); cfg.EurekaHeartbeatLastDirty && ts != 0 {
+		q.Set("lastDirtyTimestamp", strconv.FormatInt(ts, 10))
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := e.do(req, op, cfg.AppName)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// lease returns the instance with cfg's ID that the peer at base holds, if
+// any.
+func (e *Client) lease(ctx context.Context, base string, cfg config.Config) (EurekaInstance, bool) {
+	var data eurekaInstanceResponse
+	path := fmt.Sprintf("/apps/%s/%s", strings.ToUpper(cfg.AppName), cfg.InstanceID)
+	if err := e.getRegistryFrom(ctx, base, "register_check", cfg.AppName, path, &data, &data.Instance); err != nil {
+		return EurekaInstance{}, false
+	}
+	return data.Instance, true
+}
+
+// SetStatus overrides this instance's status (e.g. OUT_OF_SERVICE) on
+// every Eureka peer and succeeds if any of them accepted it.
+func (e *Client) SetStatus(ctx context.Context, cfg config.Config, status string) error {
+	var errs []error
+	for _, p := range e.peers {
+		err := e.setStatusPeer(ctx, p.url, cfg, status)
+		p.report("set_status", err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.url, err))
+		}
+	}
+	if len(errs) == len(e.peers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (e *Client) setStatusPeer(ctx context.Context, base string, cfg config.Config, status string) error {
+	// PUT /eureka/apps/{APP}/{instanceId}/status?value=OUT_OF_SERVICE
+	u := fmt.Sprintf("%s/apps/%s/%s/status?value=%s", base, strings.ToUpper(cfg.AppName), cfg.InstanceID, url.QueryEscape(status))
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, nil)
 	if err != nil {
 		return err
 	}
-	resp, err := e.client.Do(req)
+	resp, err := e.do(req, "set_status", cfg.AppName)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	b, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("eureka status update failed: %s: %s", resp.Status, string(b))
+}
+
+// clearStatusPeer removes a status override set with SetStatus from the
+// peer at base, putting the instance back UP.
+func (e *Client) clearStatusPeer(ctx context.Context, base string, cfg config.Config) error {
+	// DELETE /eureka/apps/{APP}/{instanceId}/status?value=UP
+	u := fmt.Sprintf("%s/apps/%s/%s/status?value=UP", base, strings.ToUpper(cfg.AppName), cfg.InstanceID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(req, "clear_status", cfg.AppName)
 	if err != nil {
 		return err
 	}
@@ -86,68 +358,332 @@ func (e *Client) Heartbeat(ctx context.Context, cfg config.Config) error {
 		return nil
 	}
 	b, _ := io.ReadAll(resp.Body)
-	return fmt.Errorf("eureka heartbeat failed: %s: %s", resp.Status, string(b))
+	return fmt.Errorf("eureka status override removal failed: %s: %s", resp.Status, string(b))
+}
+
+// Deregister removes this service instance from every Eureka peer.
+func (e *Client) Deregister(ctx context.Context, cfg config.Config) error {
+	var errs []error
+	for _, p := range e.peers {
+		if err := e.deregisterPeer(ctx, p.url, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Client) deregisterPeer(ctx context.Context, base string, cfg config.Config) error {
+	// DELETE /eureka/apps/{APP}/{instanceId}
+	u := fmt.Sprintf("%s/apps/%s/%s", base, strings.ToUpper(cfg.AppName), cfg.InstanceID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(req, "deregister", cfg.AppName)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	b, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("eureka deregister failed: %s: %s", resp.Status, string(b))
 }
 
 // EurekaInstance represents a service instance in Eureka
 type EurekaInstance struct {
-	Status      string `json:"status"`
-	HomePageURL string `json:"homePageUrl"`
-	IPAddr      string `json:"ipAddr"`
-	Port        struct {
-		Value int `json:"$"`
-	} `json:"port"`
+	InstanceID     string     `json:"instanceId" xml:"instanceId"`
+	Status         string     `json:"status" xml:"status"`
+	HomePageURL    string     `json:"homePageUrl" xml:"homePageUrl"`
+	HealthCheckURL string     `json:"healthCheckUrl" xml:"healthCheckUrl"`
+	IPAddr         string     `json:"ipAddr" xml:"ipAddr"`
+	Port           eurekaPort `json:"port" xml:"port"`
+	SecurePort     eurekaPort `json:"securePort" xml:"securePort"`
+	Metadata       Metadata   `json:"metadata" xml:"metadata"`
+	// ActionType is ADDED, MODIFIED or DELETED in delta fetches.
+	ActionType string `json:"actionType,omitempty" xml:"actionType"`
+}
+
+// BaseURL returns how the gateway reaches inst, or "" if it can't be
+// addressed.
+func (inst EurekaInstance) BaseURL() string {
+	return instanceBaseURL(inst)
+}
+
+// eurekaPort is a port as Eureka reports it: {"$": 8443, "@enabled": "true"}
+// in JSON, <securePort enabled="true">8443</securePort> in XML.
+type eurekaPort struct {
+	Value   int      `json:"$" xml:",chardata"`
+	Enabled flexBool `json:"@enabled" xml:"enabled,attr"`
+}
+
+// flexBool decodes a JSON boolean that Eureka sends either as true or as
+// the string "true".
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	*b = flexBool(v)
+	return nil
+}
+
+// App is an application entry in the Eureka registry.
+type App struct {
+	Name      string           `json:"name"`
+	Instances []EurekaInstance `json:"instances"`
+}
+
+// oneOrMany decodes a JSON value that Eureka may send either as a single
+// object (when there is exactly one element) or as an array.
+type oneOrMany[T any] []T
+
+func (l *oneOrMany[T]) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		*l = nil
+		return nil
+	}
+	if b[0] == '[' {
+		var many []T
+		if err := json.Unmarshal(b, &many); err != nil {
+			return err
+		}
+		*l = many
+		return nil
+	}
+	var one T
+	if err := json.Unmarshal(b, &one); err != nil {
+		return err
+	}
+	*l = oneOrMany[T]{one}
+	return nil
+}
+
+// wireApp and wireApps mirror Eureka's <application> and <applications>
+// documents. The same types serve both JSON (inside an envelope) and XML
+// (as the document root).
+type wireApp struct {
+	Name     string                    `json:"name" xml:"name"`
+	Instance oneOrMany[EurekaInstance] `json:"instance" xml:"instance"`
+}
+
+type wireApps struct {
+	AppsHashcode string             `json:"apps__hashcode" xml:"apps__hashcode"`
+	Application  oneOrMany[wireApp] `json:"application" xml:"application"`
 }
 
 type eurekaAppResponse struct {
-	Application struct {
-		Instance []EurekaInstance `json:"instance"`
-	} `json:"application"`
+	Application wireApp `json:"application"`
 }
 
-// ResolveBaseURL resolves the base URL of a service from Eureka
-func (e *Client) ResolveBaseURL(ctx context.Context, appName string) (string, error) {
-	u := fmt.Sprintf("%s/apps/%s", e.baseURL, strings.ToUpper(appName))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+type eurekaInstanceResponse struct {
+	Instance EurekaInstance `json:"instance"`
+}
+
+type eurekaAppsResponse struct {
+	Applications wireApps `json:"applications"`
+}
+
+// getRegistry fetches a registry document from the last peer that
+// answered, failing over to the others in turn.
+func (e *Client) getRegistry(ctx context.Context, op, appName, path string, jsonDst, xmlDst interface{}) error {
+	var err error
+	start := int(e.readPeer.Load())
+	for i := range e.peers {
+		n := (start + i) % len(e.peers)
+		p := e.peers[n]
+		err = e.getRegistryFrom(ctx, p.url, op, appName, path, jsonDst, xmlDst)
+		p.report(op, err)
+		if err == nil {
+			e.readPeer.Store(int32(n))
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// getRegistryFrom fetches a registry document from the peer at base and
+// decodes it as JSON or XML depending on the response Content-Type. Some
+// Eureka servers ignore the Accept header and always answer with XML.
+func (e *Client) getRegistryFrom(ctx context.Context, base, op, appName, path string, jsonDst, xmlDst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.registryURL(base, path), nil)
 	if err != nil {
-		return "", err
+		return err
 	}
 	req.Header.Set("Accept", "application/json")
-	resp, err := e.client.Do(req)
+	resp, err := e.do(req, op, appName)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("resolve app failed: %s: %s", resp.Status, string(b))
+		return fmt.Errorf("eureka GET %s failed: %s: %s", path, resp.Status, string(b))
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+		return xml.NewDecoder(resp.Body).Decode(xmlDst)
 	}
+	return json.NewDecoder(resp.Body).Decode(jsonDst)
+}
 
-	var data eurekaAppResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", err
+// ListApps returns every application currently in the registry.
+func (e *Client) ListApps(ctx context.Context) ([]App, error) {
+	var data eurekaAppsResponse
+	if err := e.getRegistry(ctx, "list_apps", "", "/apps", &data, &data.Applications); err != nil {
+		return nil, err
+	}
+	apps := make([]App, 0, len(data.Applications.Application))
+	for _, a := range data.Applications.Application {
+		apps = append(apps, App{Name: a.Name, Instances: a.Instance})
 	}
+	return apps, nil
+}
 
-	// Pick first UP instance, otherwise first instance.
-	var chosen *EurekaInstance
-	for i := range data.Application.Instance {
-		inst := &data.Application.Instance[i]
-		if strings.EqualFold(inst.Status, "UP") {
-			chosen = inst
-			break
+// ResolveAllBaseURLs returns the base URLs of the UP instances of a
+// service in registry order, as a copy the caller may keep. Instances in
+// any other state (DOWN, STARTING, OUT_OF_SERVICE...) are never returned;
+// if none is UP an error says so. Spreading load across them is up to the
+// caller (see proxy.Balancer).
+func (e *Client) ResolveAllBaseURLs(ctx context.Context, appName string) ([]string, error) {
+	up, err := e.BaseURLs(ctx, appName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), up...), nil
+}
+
+// BaseURLs returns the base URLs of the UP instances of a service in
+// registry order, served from the cache (see SetCacheTTL). Callers must
+// not modify the slice.
+func (e *Client) BaseURLs(ctx context.Context, appName string) ([]string, error) {
+	return e.instances(ctx, appName)
+}
+
+// fetchInstances asks Eureka for the base URLs of appName's UP instances.
+func (e *Client) fetchInstances(ctx context.Context, appName string) ([]string, error) {
+	instances, err := e.listInstances(ctx, "resolve", appName)
+	if err != nil {
+		return nil, err
+	}
+	return e.upBaseURLs(appName, instances)
+}
+
+// upBaseURLs returns the base URLs of the UP instances among instances.
+// Duplicate entries for one instance id, which a lagging registry can
+// return, are counted once.
+func (e *Client) upBaseURLs(appName string, instances []EurekaInstance) ([]string, error) {
+	var up []string
+	seen := make(map[string]bool)
+	statuses := make(map[string]int)
+	zones := make(map[string]string)
+	for _, inst := range instances {
+		base := instanceBaseURL(inst)
+		if base == "" {
+			continue
+		}
+		key := inst.InstanceID
+		if key == "" {
+			key = base
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if !strings.EqualFold(inst.Status, "UP") {
+			statuses[strings.ToUpper(inst.Status)]++
+			continue
 		}
+		zones[base] = inst.Metadata["zone"]
+		up = append(up, base)
 	}
-	if chosen == nil && len(data.Application.Instance) > 0 {
-		chosen = &data.Application.Instance[0]
+	e.zonesMu.Lock()
+	if e.zones == nil {
+		e.zones = make(map[string]map[string]string)
 	}
-	if chosen == nil {
-		return "", fmt.Errorf("no instances for %s", appName)
+	e.zones[strings.ToUpper(appName)] = zones
+	e.zonesMu.Unlock()
+	if len(up) == 0 {
+		if len(statuses) == 0 {
+			return nil, fmt.Errorf("no addressable instances for %s", appName)
+		}
+		return nil, fmt.Errorf("no UP instances for %s (registered: %s)", appName, formatCounts(statuses))
+	}
+	return up, nil
+}
+
+// ListInstances returns every instance the registry holds for appName, in
+// any state, bypassing the resolution cache.
+func (e *Client) ListInstances(ctx context.Context, appName string) ([]EurekaInstance, error) {
+	return e.listInstances(ctx, "list_instances", appName)
+}
+
+func (e *Client) listInstances(ctx context.Context, op, appName string) ([]EurekaInstance, error) {
+	var data eurekaAppResponse
+	if err := e.getRegistry(ctx, op, appName, "/apps/"+strings.ToUpper(appName), &data, &data.Application); err != nil {
+		return nil, err
+	}
+	return data.Application.Instance, nil
+}
+
+// Zone returns the zone the instance of appName at base advertised in its
+// metadata when last resolved, or "".
+func (e *Client) Zone(appName, base string) string {
+	e.zonesMu.Lock()
+	defer e.zonesMu.Unlock()
+	return e.zones[strings.ToUpper(appName)][base]
+}
+
+// formatCounts renders {"DOWN": 2} as "2 DOWN", sorted by status.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
 	}
-	if chosen.HomePageURL != "" {
-		return strings.TrimRight(chosen.HomePageURL, "/"), nil
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%d %s", counts[k], k)
 	}
-	if chosen.IPAddr != "" && chosen.Port.Value != 0 {
-		return fmt.Sprintf("http://%s:%d", chosen.IPAddr, chosen.Port.Value), nil
+	return strings.Join(parts, ", ")
+}
+
+// instanceBaseURL returns how to reach inst: over TLS when its secure port
+// is enabled, else its home page URL or plain ip:port. "" means it
+// can't be addressed.
+func instanceBaseURL(inst EurekaInstance) string {
+	home := strings.TrimRight(inst.HomePageURL, "/")
+	if inst.SecurePort.Enabled && inst.SecurePort.Value != 0 {
+		// TLS-only services: an https home page already names the secure
+		// port; otherwise build the URL from it.
+		if strings.HasPrefix(home, "https://") {
+			return home
+		}
+		if inst.IPAddr != "" {
+			return "https://" + net.JoinHostPort(inst.IPAddr, strconv.Itoa(inst.SecurePort.Value))
+		}
+	}
+	if home != "" {
+		return home
+	}
+	if inst.IPAddr != "" && inst.Port.Value != 0 {
+		return "http://" + net.JoinHostPort(inst.IPAddr, strconv.Itoa(inst.Port.Value))
+	}
+	return ""
+}
+
+// ResolveBaseURL resolves the base URL of a service's first UP instance
+// from Eureka. It has no side effects, so diagnostics can call it freely.
+func (e *Client) ResolveBaseURL(ctx context.Context, appName string) (string, error) {
+	bases, err := e.ResolveAllBaseURLs(ctx, appName)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("instance missing url fields for %s", appName)
+	return bases[0], nil
 }
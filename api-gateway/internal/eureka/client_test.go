@@ -0,0 +1,449 @@
+package eureka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+)
+
+// testInstanceConfig describes the gateway instance the tests register.
+func testInstanceConfig() config.Config {
+	return config.Config{AppName: "api-gateway", InstanceID: "gw-1", Port: "8080"}
+}
+
+// registryServer answers every request with body as contentType.
+func registryServer(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestListAppsJSONSingleAndArray(t *testing.T) {
+	// AGENT has one instance, sent as an object rather than an array
+	srv := registryServer(t, "application/json", `{"applications": {"application": [
+		{"name": "AGENT", "instance": {"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}},
+		{"name": "USERS", "instance": [
+			{"status": "UP", "ipAddr": "10.0.0.2", "port": {"$": 8001}},
+			{"status": "DOWN", "ipAddr": "10.0.0.3", "port": {"$": 8001}}
+		]}
+	]}}`)
+
+	apps, err := NewEurekaClient([]string{srv.URL}, time.Second).ListApps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || apps[0].Name != "AGENT" || apps[1].Name != "USERS" {
+		t.Fatalf("apps = %+v", apps)
+	}
+	if len(apps[0].Instances) != 1 || apps[0].Instances[0].Port.Value != 8000 {
+		t.Errorf("AGENT instances = %+v", apps[0].Instances)
+	}
+	if len(apps[1].Instances) != 2 || apps[1].Instances[1].Status != "DOWN" {
+		t.Errorf("USERS instances = %+v", apps[1].Instances)
+	}
+}
+
+func TestListAppsSingleApplicationObject(t *testing.T) {
+	srv := registryServer(t, "application/json", `{"applications": {"application":
+		{"name": "AGENT", "instance": [{"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}]}
+	}}`)
+
+	apps, err := NewEurekaClient([]string{srv.URL}, time.Second).ListApps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].Name != "AGENT" || len(apps[0].Instances) != 1 {
+		t.Fatalf("apps = %+v", apps)
+	}
+}
+
+func TestListAppsXML(t *testing.T) {
+	srv := registryServer(t, "application/xml", `<?xml version="1.0" encoding="UTF-8"?>
+<applications>
+  <application>
+    <name>AGENT</name>
+    <instance><status>UP</status><ipAddr>10.0.0.1</ipAddr><port enabled="true">8000</port></instance>
+  </application>
+  <application>
+    <name>USERS</name>
+    <instance><status>UP</status><ipAddr>10.0.0.2</ipAddr><port enabled="true">8001</port></instance>
+    <instance><status>UP</status><ipAddr>10.0.0.3</ipAddr><port enabled="true">8001</port></instance>
+  </application>
+</applications>`)
+
+	apps, err := NewEurekaClient([]string{srv.URL}, time.Second).ListApps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || len(apps[0].Instances) != 1 || len(apps[1].Instances) != 2 {
+		t.Fatalf("apps = %+v", apps)
+	}
+	if inst := apps[1].Instances[1]; inst.IPAddr != "10.0.0.3" || inst.Port.Value != 8001 {
+		t.Errorf("USERS[1] = %+v", inst)
+	}
+}
+
+func TestResolveBaseURLXML(t *testing.T) {
+	srv := registryServer(t, "application/xml; charset=UTF-8", `<application>
+  <name>AGENT</name>
+  <instance><status>DOWN</status><ipAddr>10.0.0.9</ipAddr><port>9000</port></instance>
+  <instance><status>UP</status><ipAddr>10.0.0.1</ipAddr><port>8000</port></instance>
+</application>`)
+
+	got, err := NewEurekaClient([]string{srv.URL}, time.Second).ResolveBaseURL(context.Background(), "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "http://10.0.0.1:8000" {
+		t.Fatalf("ResolveBaseURL = %q, want the UP instance", got)
+	}
+}
+
+// eurekaCall is one request received by a recordingEureka server.
+type eurekaCall struct {
+	method, path, query, body string
+}
+
+// recordingEureka accepts every request, answering status, and records it.
+func recordingEureka(t *testing.T, status int) (*httptest.Server, func() []eurekaCall) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []eurekaCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, eurekaCall{r.Method, r.URL.Path, r.URL.RawQuery, string(b)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []eurekaCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]eurekaCall(nil), calls...)
+	}
+}
+
+func TestHeartbeatSendsStatusAndLastDirty(t *testing.T) {
+	srv, calls := recordingEureka(t, http.StatusNoContent)
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	cfg := testInstanceConfig()
+	cfg.EurekaHeartbeatStatus = "UP"
+	cfg.EurekaHeartbeatLastDirty = true
+
+	if err := e.Register(context.Background(), cfg, "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Heartbeat(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := calls()
+	if len(got) < 2 {
+		t.Fatalf("calls = %+v", got)
+	}
+	reg, hb := got[len(got)-2], got[len(got)-1]
+	dirty := regexp.MustCompile(`<lastDirtyTimestamp>(\d+)</lastDirtyTimestamp>`).FindStringSubmatch(reg.body)
+	if reg.method != http.MethodPost || dirty == nil {
+		t.Fatalf("registration %+v has no lastDirtyTimestamp", reg)
+	}
+	q, _ := url.ParseQuery(hb.query)
+	if hb.method != http.MethodPut || hb.path != "/apps/API-GATEWAY/gw-1" ||
+		q.Get("status") != "UP" || q.Get("lastDirtyTimestamp") != dirty[1] {
+		t.Fatalf("heartbeat = %+v, want status=UP and lastDirtyTimestamp=%s", hb, dirty[1])
+	}
+}
+
+func TestHeartbeatParametersCanBeDisabled(t *testing.T) {
+	srv, calls := recordingEureka(t, http.StatusNoContent)
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	cfg := testInstanceConfig()
+
+	if err := e.Register(context.Background(), cfg, "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Heartbeat(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := calls()
+	if hb := got[len(got)-1]; hb.query != "" {
+		t.Fatalf("heartbeat query = %q, want none", hb.query)
+	}
+}
+
+// leaseHolder is a Eureka server that already holds gw-1 at ipAddr:port
+// with the given status.
+func leaseHolder(t *testing.T, status, ipAddr string, port int) (*httptest.Server, func() []eurekaCall) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []eurekaCall
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, eurekaCall{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery})
+		mu.Unlock()
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"instance": {"instanceId": "gw-1", "status": %q, "ipAddr": %q, "port": {"$": %d}}}`, status, ipAddr, port)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []eurekaCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]eurekaCall(nil), calls...)
+	}
+}
+
+func TestRegisterRenewsLeaseAtSameAddress(t *testing.T) {
+	srv, calls := leaseHolder(t, "UP", "10.0.0.5", 8080)
+	if err := NewEurekaClient([]string{srv.URL}, time.Second).Register(context.Background(), testInstanceConfig(), "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range calls() {
+		if c.method == http.MethodPost {
+			t.Fatalf("calls = %+v, want the existing lease renewed", calls())
+		}
+	}
+}
+
+func TestRegisterReplacesLeaseAfterFastRestart(t *testing.T) {
+	// The previous process registered gw-1 from another pod IP and port
+	for _, stale := range []struct {
+		ip   string
+		port int
+	}{{"10.0.0.9", 8080}, {"10.0.0.5", 9090}} {
+		srv, calls := leaseHolder(t, "UP", stale.ip, stale.port)
+		if err := NewEurekaClient([]string{srv.URL}, time.Second).Register(context.Background(), testInstanceConfig(), "10.0.0.5"); err != nil {
+			t.Fatal(err)
+		}
+		got := calls()
+		if last := got[len(got)-1]; last.method != http.MethodPost || last.path != "/apps/API-GATEWAY" {
+			t.Errorf("stale lease at %s:%d: calls = %+v, want a new registration", stale.ip, stale.port, got)
+		}
+	}
+}
+
+func TestRegisterClearsOutOfServiceLeftByPreviousInstance(t *testing.T) {
+	// The previous process was marked OUT_OF_SERVICE on shutdown but its
+	// deregistration never arrived
+	srv, calls := leaseHolder(t, "OUT_OF_SERVICE", "10.0.0.5", 8080)
+	if err := NewEurekaClient([]string{srv.URL}, time.Second).Register(context.Background(), testInstanceConfig(), "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	var cleared, registered bool
+	for _, c := range calls() {
+		switch {
+		case c.method == http.MethodDelete && c.path == "/apps/API-GATEWAY/gw-1/status" && c.query == "value=UP":
+			cleared = true
+		case c.method == http.MethodPost && c.path == "/apps/API-GATEWAY":
+			registered = cleared
+		case c.method == http.MethodPut:
+			t.Fatalf("calls = %+v, want the OUT_OF_SERVICE lease not renewed", calls())
+		}
+	}
+	if !cleared || !registered {
+		t.Fatalf("calls = %+v, want the status override cleared, then a new registration", calls())
+	}
+}
+
+func TestRegisterAdvertisesStatusPage(t *testing.T) {
+	srv, calls := recordingEureka(t, http.StatusNoContent)
+	cfg := testInstanceConfig()
+	cfg.StatusPagePath = "/actuator/info"
+	if err := NewEurekaClient([]string{srv.URL}, time.Second).Register(context.Background(), cfg, "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	got := calls()
+	reg := got[len(got)-1]
+	for _, want := range []string{
+		"<statusPageUrl>http://10.0.0.5:8080/actuator/info</statusPageUrl>",
+		"<healthCheckUrl>http://10.0.0.5:8080/health</healthCheckUrl>",
+	} {
+		if !strings.Contains(reg.body, want) {
+			t.Errorf("registration lacks %s:\n%s", want, reg.body)
+		}
+	}
+}
+
+func TestRegisterSendsMetadata(t *testing.T) {
+	for format, want := range map[string][]string{
+		"xml":  {"<metadata>", "<zone>eu-1</zone>", "<version>2.1</version>"},
+		"json": {`"metadata":{`, `"zone":"eu-1"`, `"version":"2.1"`},
+	} {
+		srv, calls := recordingEureka(t, http.StatusNoContent)
+		e := NewEurekaClient([]string{srv.URL}, time.Second)
+		e.SetRegisterFormat(format)
+		cfg := testInstanceConfig()
+		cfg.EurekaMetadata = map[string]string{"zone": "eu-1", "version": "2.1"}
+		if err := e.Register(context.Background(), cfg, "10.0.0.5"); err != nil {
+			t.Fatal(err)
+		}
+		got := calls()
+		reg := got[len(got)-1]
+		for _, w := range want {
+			if !strings.Contains(reg.body, w) {
+				t.Errorf("%s registration lacks %s:\n%s", format, w, reg.body)
+			}
+		}
+	}
+}
+
+func TestFormatHintShapesRegistryURL(t *testing.T) {
+	for hint, want := range map[string]string{
+		"suffix": "/apps/AGENT.json",
+		"query":  "/apps/AGENT?type=json",
+		"none":   "/apps/AGENT",
+		"":       "/apps/AGENT",
+	} {
+		var got string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.RequestURI()
+			// Servers that honor the hint still answer XML sometimes
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<application><name>AGENT</name><instance><status>UP</status><ipAddr>10.0.0.1</ipAddr><port>8000</port></instance></application>`)
+		}))
+		e := NewEurekaClient([]string{srv.URL}, time.Second)
+		e.SetFormatHint(hint)
+		base, err := e.ResolveBaseURL(context.Background(), "agent")
+		srv.Close()
+		if err != nil || base != "http://10.0.0.1:8000" {
+			t.Errorf("hint %q: ResolveBaseURL = %q, %v", hint, base, err)
+		}
+		if got != want {
+			t.Errorf("hint %q: requested %s, want %s", hint, got, want)
+		}
+	}
+}
+
+func TestBasicAuthIsSentWithRegistryRequests(t *testing.T) {
+	var mu sync.Mutex
+	var creds []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		mu.Lock()
+		creds = append(creds, user+":"+pass)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	e.SetBasicAuth("gw", "s3cret")
+	if err := e.Register(context.Background(), testInstanceConfig(), "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Heartbeat(context.Background(), testInstanceConfig()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, c := range creds {
+		if c != "gw:s3cret" {
+			t.Errorf("request %d sent credentials %q", i, c)
+		}
+	}
+	if len(creds) < 2 {
+		t.Fatalf("saw %d requests, want registration and heartbeat", len(creds))
+	}
+}
+
+func TestResolveAllSkipsDownAndDuplicateInstances(t *testing.T) {
+	srv := registryServer(t, "application/json", `{"application": {"name": "AGENT", "instance": [
+		{"instanceId": "a", "status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}},
+		{"instanceId": "a", "status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}},
+		{"instanceId": "b", "status": "DOWN", "ipAddr": "10.0.0.2", "port": {"$": 8000}},
+		{"instanceId": "c", "status": "STARTING", "ipAddr": "10.0.0.3", "port": {"$": 8000}}
+	]}}`)
+	got, err := NewEurekaClient([]string{srv.URL}, time.Second).ResolveAllBaseURLs(context.Background(), "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "http://10.0.0.1:8000" {
+		t.Fatalf("ResolveAllBaseURLs = %v, want the UP instance once", got)
+	}
+}
+
+func TestResolveDoesNotRotate(t *testing.T) {
+	srv := registryServer(t, "application/json", `{"application": {"name": "AGENT", "instance": [
+		{"instanceId": "a", "status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}},
+		{"instanceId": "b", "status": "UP", "ipAddr": "10.0.0.2", "port": {"$": 8000}}
+	]}}`)
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	for i := 0; i < 3; i++ {
+		// Load balancing is the proxy's job; lookups must not move it along
+		if got, err := e.ResolveBaseURL(context.Background(), "agent"); err != nil || got != "http://10.0.0.1:8000" {
+			t.Fatalf("call %d: ResolveBaseURL = %q, %v", i, got, err)
+		}
+		all, _ := e.ResolveAllBaseURLs(context.Background(), "agent")
+		if len(all) != 2 || all[0] != "http://10.0.0.1:8000" {
+			t.Fatalf("call %d: ResolveAllBaseURLs = %v, want registry order", i, all)
+		}
+		all[0] = "mutated"
+	}
+}
+
+func TestZonesFollowTheLatestListing(t *testing.T) {
+	instances := `{"instanceId": "a", "status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}, "metadata": {"zone": "zone-a"}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"application": {"name": "AGENT", "instance": [%s]}}`, instances)
+	}))
+	t.Cleanup(srv.Close)
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+
+	e.ResolveAllBaseURLs(context.Background(), "agent")
+	if got := e.Zone("AGENT", "http://10.0.0.1:8000"); got != "zone-a" {
+		t.Fatalf("Zone = %q, want zone-a", got)
+	}
+	// The instance is replaced by one at a new address
+	instances = `{"instanceId": "b", "status": "UP", "ipAddr": "10.0.0.2", "port": {"$": 8000}, "metadata": {"zone": "zone-b"}}`
+	e.ResolveAllBaseURLs(context.Background(), "agent")
+	if got := e.Zone("agent", "http://10.0.0.2:8000"); got != "zone-b" {
+		t.Fatalf("Zone = %q, want zone-b", got)
+	}
+	if got := e.Zone("agent", "http://10.0.0.1:8000"); got != "" {
+		t.Fatalf("departed instance still has zone %q", got)
+	}
+	if n := len(e.zones["AGENT"]); n != 1 {
+		t.Fatalf("%d zones kept for AGENT, want 1", n)
+	}
+}
+
+func TestResolveAllReportsWhyNothingIsUp(t *testing.T) {
+	srv := registryServer(t, "application/json", `{"application": {"name": "AGENT", "instance": [
+		{"instanceId": "a", "status": "DOWN", "ipAddr": "10.0.0.1", "port": {"$": 8000}},
+		{"instanceId": "b", "status": "DOWN", "ipAddr": "10.0.0.2", "port": {"$": 8000}},
+		{"instanceId": "c", "status": "OUT_OF_SERVICE", "ipAddr": "10.0.0.3", "port": {"$": 8000}}
+	]}}`)
+	_, err := NewEurekaClient([]string{srv.URL}, time.Second).ResolveAllBaseURLs(context.Background(), "agent")
+	if err == nil || !strings.Contains(err.Error(), "registered: 2 DOWN, 1 OUT_OF_SERVICE") {
+		t.Fatalf("err = %v, want the registered statuses counted", err)
+	}
+}
+
+func TestResolveBaseURLBracketsIPv6(t *testing.T) {
+	srv := registryServer(t, "application/json", `{"application": {"name": "AGENT", "instance":
+		{"status": "UP", "ipAddr": "2001:db8::5", "port": {"$": 8000}}}}`)
+	got, err := NewEurekaClient([]string{srv.URL}, time.Second).ResolveBaseURL(context.Background(), "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "http://[2001:db8::5]:8000" {
+		t.Fatalf("ResolveBaseURL = %q", got)
+	}
+}
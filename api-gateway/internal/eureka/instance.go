@@ -0,0 +1,125 @@
+package eureka
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+	"strconv"
+)
+
+// Instance is the registration document sent to Eureka, as XML or JSON.
+// Optional fields are omitted when empty, so new ones can be added without
+// touching the serialization.
+type Instance struct {
+	XMLName            xml.Name       `xml:"instance" json:"-"`
+	InstanceID         string         `xml:"instanceId" json:"instanceId"`
+	HostName           string         `xml:"hostName" json:"hostName"`
+	App                string         `xml:"app" json:"app"`
+	IPAddr             string         `xml:"ipAddr" json:"ipAddr"`
+	VIPAddress         string         `xml:"vipAddress,omitempty" json:"vipAddress,omitempty"`
+	SecureVIPAddress   string         `xml:"secureVipAddress,omitempty" json:"secureVipAddress,omitempty"`
+	Status             string         `xml:"status" json:"status"`
+	Port               PortInfo       `xml:"port" json:"port"`
+	SecurePort         PortInfo       `xml:"securePort" json:"securePort"`
+	HomePageURL        string         `xml:"homePageUrl,omitempty" json:"homePageUrl,omitempty"`
+	StatusPageURL      string         `xml:"statusPageUrl,omitempty" json:"statusPageUrl,omitempty"`
+	HealthCheckURL     string         `xml:"healthCheckUrl,omitempty" json:"healthCheckUrl,omitempty"`
+	DataCenterInfo     DataCenterInfo `xml:"dataCenterInfo" json:"dataCenterInfo"`
+	LeaseInfo          *LeaseInfo     `xml:"leaseInfo,omitempty" json:"leaseInfo,omitempty"`
+	Metadata           Metadata       `xml:"metadata,omitempty" json:"metadata,omitempty"`
+	LastDirtyTimestamp int64          `xml:"lastDirtyTimestamp,omitempty" json:"lastDirtyTimestamp,omitempty"`
+}
+
+// PortInfo is a port number with its enabled flag.
+type PortInfo struct {
+	Number  int  `xml:",chardata"`
+	Enabled bool `xml:"enabled,attr"`
+}
+
+// MarshalJSON renders the port the way Eureka's JSON codec expects:
+// {"$": 8080, "@enabled": "true"}.
+func (p PortInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Number  int    `json:"$"`
+		Enabled string `json:"@enabled"`
+	}{p.Number, strconv.FormatBool(p.Enabled)})
+}
+
+// DataCenterInfo identifies where the instance runs.
+type DataCenterInfo struct {
+	Class string `xml:"class,attr" json:"@class"`
+	Name  string `xml:"name" json:"name"`
+}
+
+// DefaultDataCenter is the data center info for self-hosted instances.
+var DefaultDataCenter = DataCenterInfo{
+	Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo",
+	Name:  "MyOwn",
+}
+
+// LeaseInfo overrides the server's lease timings, in seconds.
+type LeaseInfo struct {
+	RenewalIntervalInSecs int `xml:"renewalIntervalInSecs,omitempty" json:"renewalIntervalInSecs,omitempty"`
+	DurationInSecs        int `xml:"durationInSecs,omitempty" json:"durationInSecs,omitempty"`
+}
+
+// Metadata is free-form instance metadata, serialized as one element per
+// key in sorted order.
+type Metadata map[string]string
+
+// MarshalXML implements xml.Marshaler.
+func (m Metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := e.EncodeElement(m[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML implements xml.Unmarshaler, reading one entry per child
+// element.
+func (m *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*m = Metadata{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var v string
+			if err := d.DecodeElement(&v, &t); err != nil {
+				return err
+			}
+			(*m)[t.Name.Local] = v
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// marshal renders the registration payload in format ("json" or "xml")
+// and returns it with its Content-Type.
+func (inst Instance) marshal(format string) ([]byte, string, error) {
+	if format == "json" {
+		b, err := json.Marshal(map[string]Instance{"instance": inst})
+		return b, "application/json", err
+	}
+	b, err := xml.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	return append([]byte(xml.Header), b...), "application/xml", nil
+}
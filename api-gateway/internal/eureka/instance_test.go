@@ -0,0 +1,79 @@
+package eureka
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// golden compares got with testdata/name, rewriting it under -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run go test -update to accept)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestInstanceMarshalGolden(t *testing.T) {
+	minimal := Instance{
+		InstanceID:         "gw-1",
+		HostName:           "10.0.0.5",
+		App:                "API-GATEWAY",
+		IPAddr:             "10.0.0.5",
+		Status:             "UP",
+		Port:               PortInfo{Number: 8080, Enabled: true},
+		SecurePort:         PortInfo{Number: 443},
+		DataCenterInfo:     DefaultDataCenter,
+		LastDirtyTimestamp: 1700000000000,
+	}
+	full := Instance{
+		InstanceID:         "gw-1",
+		HostName:           "gateway.internal",
+		App:                "API-GATEWAY",
+		IPAddr:             "10.0.0.5",
+		VIPAddress:         "api-gateway",
+		SecureVIPAddress:   "api-gateway",
+		Status:             "UP",
+		Port:               PortInfo{Number: 8080, Enabled: true},
+		SecurePort:         PortInfo{Number: 443},
+		HomePageURL:        "http://10.0.0.5:8080/",
+		StatusPageURL:      "http://10.0.0.5:8080/info",
+		HealthCheckURL:     "http://10.0.0.5:8080/health",
+		DataCenterInfo:     DefaultDataCenter,
+		LeaseInfo:          &LeaseInfo{RenewalIntervalInSecs: 30, DurationInSecs: 90},
+		Metadata:           Metadata{"zone": "eu-1", "management.port": "8080", "build": "<dev & test>"},
+		LastDirtyTimestamp: 1700000000000,
+	}
+	for _, tc := range []struct {
+		name, format, contentType string
+		inst                      Instance
+	}{
+		{"instance_minimal.xml", "xml", "application/xml", minimal},
+		{"instance_full.xml", "", "application/xml", full},
+		{"instance_minimal.json", "json", "application/json", minimal},
+		{"instance_full.json", "json", "application/json", full},
+	} {
+		got, contentType, err := tc.inst.marshal(tc.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if contentType != tc.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tc.name, contentType, tc.contentType)
+		}
+		golden(t, tc.name, got)
+	}
+}
@@ -0,0 +1,162 @@
+package eureka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// registry is a local copy of the whole Eureka registry, kept current with
+// delta fetches. Once loaded, instance lookups are answered from it
+// instead of asking Eureka per app.
+type registry struct {
+	mu     sync.RWMutex
+	loaded bool
+	apps   map[string]map[string]EurekaInstance // APP -> instance key -> instance
+}
+
+// lookup returns appName's instances and whether the registry is loaded.
+func (r *registry) lookup(appName string) ([]EurekaInstance, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.loaded {
+		return nil, false
+	}
+	insts := make([]EurekaInstance, 0, len(r.apps[strings.ToUpper(appName)]))
+	for _, inst := range r.apps[strings.ToUpper(appName)] {
+		insts = append(insts, inst)
+	}
+	// Map order is random; keep the balancer's input stable
+	sort.Slice(insts, func(i, j int) bool { return instanceKey(insts[i]) < instanceKey(insts[j]) })
+	return insts, true
+}
+
+// replace swaps in a full registry.
+func (r *registry) replace(apps oneOrMany[wireApp]) {
+	next := make(map[string]map[string]EurekaInstance, len(apps))
+	for _, app := range apps {
+		insts := make(map[string]EurekaInstance, len(app.Instance))
+		for _, inst := range app.Instance {
+			insts[instanceKey(inst)] = inst
+		}
+		next[strings.ToUpper(app.Name)] = insts
+	}
+	r.mu.Lock()
+	r.apps, r.loaded = next, true
+	r.mu.Unlock()
+}
+
+// apply merges a delta into the registry and reports whether the result
+// matches hashcode, the server's hash of its full registry. An empty
+// hashcode can't be checked and is taken as a match.
+func (r *registry) apply(apps oneOrMany[wireApp], hashcode string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, app := range apps {
+		name := strings.ToUpper(app.Name)
+		for _, inst := range app.Instance {
+			switch strings.ToUpper(inst.ActionType) {
+			case "DELETED":
+				delete(r.apps[name], instanceKey(inst))
+			default: // ADDED, MODIFIED
+				if r.apps[name] == nil {
+					r.apps[name] = make(map[string]EurekaInstance)
+				}
+				r.apps[name][instanceKey(inst)] = inst
+			}
+		}
+		if len(r.apps[name]) == 0 {
+			delete(r.apps, name)
+		}
+	}
+	return hashcode == "" || r.hashcodeLocked() == hashcode
+}
+
+// hashcodeLocked computes Eureka's reconcile hash code: the instance count
+// per status, in status order, as "DOWN_1_UP_3_".
+func (r *registry) hashcodeLocked() string {
+	counts := make(map[string]int)
+	for _, insts := range r.apps {
+		for _, inst := range insts {
+			counts[strings.ToUpper(inst.Status)]++
+		}
+	}
+	statuses := make([]string, 0, len(counts))
+	for s := range counts {
+		statuses = append(statuses, s)
+	}
+	sort.Strings(statuses)
+	var b strings.Builder
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "%s_%d_", s, counts[s])
+	}
+	return b.String()
+}
+
+// instanceKey identifies inst within its app: its id, or its base URL for
+// registries that leave the id out.
+func instanceKey(inst EurekaInstance) string {
+	if inst.InstanceID != "" {
+		return inst.InstanceID
+	}
+	return instanceBaseURL(inst)
+}
+
+// SyncRegistry keeps a local copy of the registry until ctx ends: a full
+// fetch first and every fullEvery, and delta fetches every deltaEvery in
+// between. A delta that leaves the copy out of step with the server's
+// hash code, or that fails, is followed by a full fetch. Until the first
+// full fetch succeeds, lookups go to Eureka as before.
+func (e *Client) SyncRegistry(ctx context.Context, deltaEvery, fullEvery time.Duration) {
+	var lastFull time.Time
+	t := time.NewTicker(deltaEvery)
+	defer t.Stop()
+	for {
+		full := lastFull.IsZero() || time.Since(lastFull) >= fullEvery
+		if !full {
+			if err := e.fetchDelta(ctx); err != nil {
+				slog.Warn("[eureka] delta fetch failed, fetching full registry", "err", err)
+				full = true
+			}
+		}
+		if full {
+			if err := e.fetchRegistry(ctx); err != nil {
+				slog.Warn("[eureka] registry fetch failed", "err", err)
+			} else {
+				lastFull = time.Now()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// fetchRegistry replaces the local registry with GET /apps.
+func (e *Client) fetchRegistry(ctx context.Context) error {
+	var data eurekaAppsResponse
+	if err := e.getRegistry(ctx, "fetch_registry", "", "/apps", &data, &data.Applications); err != nil {
+		return err
+	}
+	e.registry.replace(data.Applications.Application)
+	slog.Debug("[eureka] full registry fetched", "apps", len(data.Applications.Application), "hashcode", data.Applications.AppsHashcode)
+	return nil
+}
+
+// fetchDelta applies GET /apps/delta to the local registry.
+func (e *Client) fetchDelta(ctx context.Context) error {
+	var data eurekaAppsResponse
+	if err := e.getRegistry(ctx, "fetch_delta", "", "/apps/delta", &data, &data.Applications); err != nil {
+		return err
+	}
+	if !e.registry.apply(data.Applications.Application, data.Applications.AppsHashcode) {
+		return fmt.Errorf("registry hash code mismatch after delta (server %q)", data.Applications.AppsHashcode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package eureka
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistryAppliesDeltas(t *testing.T) {
+	var r registry
+	r.replace(oneOrMany[wireApp]{{Name: "agent", Instance: oneOrMany[EurekaInstance]{
+		{InstanceID: "a-1", Status: "UP"},
+		{InstanceID: "a-2", Status: "UP"},
+	}}})
+
+	ok := r.apply(oneOrMany[wireApp]{
+		{Name: "AGENT", Instance: oneOrMany[EurekaInstance]{
+			{InstanceID: "a-1", ActionType: "DELETED"},
+			{InstanceID: "a-2", Status: "DOWN", ActionType: "MODIFIED"},
+		}},
+		{Name: "USERS", Instance: oneOrMany[EurekaInstance]{{InstanceID: "u-1", Status: "UP", ActionType: "ADDED"}}},
+	}, "DOWN_1_UP_1_")
+	if !ok {
+		t.Fatalf("hash code after delta = %q, want DOWN_1_UP_1_", r.hashcodeLocked())
+	}
+	agent, _ := r.lookup("agent")
+	if len(agent) != 1 || agent[0].InstanceID != "a-2" || agent[0].Status != "DOWN" {
+		t.Errorf("AGENT = %+v", agent)
+	}
+	if users, _ := r.lookup("users"); len(users) != 1 || users[0].InstanceID != "u-1" {
+		t.Errorf("USERS = %+v", users)
+	}
+
+	// Deleting an app's last instance drops the app; a wrong hash is reported
+	if r.apply(oneOrMany[wireApp]{{Name: "USERS", Instance: oneOrMany[EurekaInstance]{{InstanceID: "u-1", ActionType: "DELETED"}}}}, "UP_1_") {
+		t.Fatal("apply matched a hash code the registry doesn't have")
+	}
+	if _, found := r.apps["USERS"]; found {
+		t.Fatal("app without instances kept")
+	}
+}
+
+func TestSyncRegistryServesLookupsAndRefetchesOnMismatch(t *testing.T) {
+	var full, perApp atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apps":
+			full.Add(1)
+			io.WriteString(w, `{"applications": {"apps__hashcode": "UP_2_", "application": {"name": "AGENT", "instance": [
+				{"instanceId": "a-2", "status": "UP", "ipAddr": "10.0.0.2", "port": {"$": 8000}},
+				{"instanceId": "a-1", "status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}
+			]}}}`)
+		case "/apps/delta":
+			// Claims a registry the delta doesn't produce
+			io.WriteString(w, `{"applications": {"apps__hashcode": "UP_5_", "application": []}}`)
+		default:
+			perApp.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.SyncRegistry(ctx, 5*time.Millisecond, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for full.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d full fetches, want another after the mismatched delta", full.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	got, err := e.BaseURLs(context.Background(), "agent")
+	want := []string{"http://10.0.0.1:8000", "http://10.0.0.2:8000"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("BaseURLs = %v, %v; want %v", got, err, want)
+	}
+	if n := perApp.Load(); n != 0 {
+		t.Fatalf("asked Eureka per app %d times with the registry loaded", n)
+	}
+}
@@ -0,0 +1,52 @@
+package eureka
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "my_app/api-gateway/internal/eureka"
+
+// SetTracerProvider overrides the provider used for Eureka client spans.
+// By default the global OpenTelemetry provider is used, which is a no-op
+// until one is installed.
+func (e *Client) SetTracerProvider(tp trace.TracerProvider) {
+	e.tracer = tp.Tracer(tracerName)
+}
+
+// do executes req inside a client span describing the Eureka operation.
+func (e *Client) do(req *http.Request, op, appName string) (*http.Response, error) {
+	tracer := e.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+	ctx, span := tracer.Start(req.Context(), "eureka."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("eureka.operation", op),
+			attribute.String("eureka.app", appName),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		),
+	)
+	defer span.End()
+
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
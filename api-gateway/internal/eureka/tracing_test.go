@@ -0,0 +1,123 @@
+package eureka
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// spanRecorder is a TracerProvider that keeps every span it starts.
+type spanRecorder struct {
+	embedded.TracerProvider
+
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	noop.Span
+
+	name   string
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+	ended  bool
+}
+
+// recordingTracer starts spans into its spanRecorder.
+type recordingTracer struct {
+	embedded.Tracer
+	r *spanRecorder
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{r: r}
+}
+
+func (rt recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &recordedSpan{name: name, attrs: make(map[attribute.Key]attribute.Value)}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	rt.r.mu.Lock()
+	rt.r.spans = append(rt.r.spans, s)
+	rt.r.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *recordedSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+// only returns the single span recorded so far.
+func (r *spanRecorder) only(t *testing.T) *recordedSpan {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(r.spans))
+	}
+	return r.spans[0]
+}
+
+func TestResolveIsTraced(t *testing.T) {
+	srv := registryServer(t, "application/json",
+		`{"application": {"name": "AGENT", "instance": {"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}}}`)
+	rec := &spanRecorder{}
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	e.SetTracerProvider(rec)
+
+	if _, err := e.ResolveBaseURL(context.Background(), "agent"); err != nil {
+		t.Fatal(err)
+	}
+	span := rec.only(t)
+	if span.name != "eureka.resolve" || !span.ended || span.status == codes.Error {
+		t.Fatalf("span = %+v", span)
+	}
+	if span.attrs["eureka.app"].AsString() != "agent" || span.attrs["http.response.status_code"].AsInt64() != 200 {
+		t.Fatalf("attributes = %v", span.attrs)
+	}
+}
+
+func TestFailedHeartbeatSpanIsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound) // lease unknown
+	}))
+	defer srv.Close()
+	rec := &spanRecorder{}
+	e := NewEurekaClient([]string{srv.URL}, time.Second)
+	e.SetTracerProvider(rec)
+
+	if err := e.Heartbeat(context.Background(), testInstanceConfig()); err == nil {
+		t.Fatal("heartbeat succeeded against a 404")
+	}
+	span := rec.only(t)
+	if span.name != "eureka.heartbeat" || span.status != codes.Error || span.attrs["http.response.status_code"].AsInt64() != 404 {
+		t.Fatalf("span = %+v", span)
+	}
+}
+
+func TestUnreachableServerSpanIsError(t *testing.T) {
+	rec := &spanRecorder{}
+	e := NewEurekaClient([]string{"http://127.0.0.1:1/eureka"}, time.Second)
+	e.SetTracerProvider(rec)
+
+	if _, err := e.ListApps(context.Background()); err == nil {
+		t.Fatal("ListApps succeeded against a closed port")
+	}
+	if span := rec.only(t); span.name != "eureka.list_apps" || span.status != codes.Error || !span.ended {
+		t.Fatalf("span = %+v", span)
+	}
+}
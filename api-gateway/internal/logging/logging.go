@@ -0,0 +1,105 @@
+// Package logging sets up the gateway's leveled logger on top of log/slog.
+//
+// Operational messages go through slog (slog.Info, slog.Warn, ...); output
+// from the standard log package is routed through the same handler at info
+// level. The JSON access log keeps its own shape and is written with JSON,
+// gated by the same level.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	level = new(slog.LevelVar)
+
+	mu  sync.Mutex
+	raw = log.New(os.Stderr, "", log.LstdFlags|log.LUTC)
+	// rawJSON drops the date prefix from JSON lines in json format, so
+	// every line of output is a JSON object.
+	rawJSON bool
+)
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// Setup installs the default slog logger writing to w at the given level,
+// as "text" or "json". With utc set, timestamps are rendered in UTC.
+func Setup(w io.Writer, lvl, format string, utc bool) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: level}
+	if utc {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				a.Value = slog.TimeValue(a.Value.Time().UTC())
+			}
+			return a
+		}
+	}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+
+	flags := log.LstdFlags
+	if utc {
+		flags |= log.LUTC
+	}
+	mu.Lock()
+	raw, rawJSON = log.New(w, "", flags), format == "json"
+	mu.Unlock()
+	return nil
+}
+
+// SetLevel changes the level at runtime.
+func SetLevel(lvl string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Enabled reports whether messages at l are logged.
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
+
+// JSON writes an already encoded JSON entry on a line of its own if l is
+// enabled. In text format the line carries the standard log date prefix,
+// as the access log always has.
+func JSON(l slog.Level, entry []byte) {
+	if !Enabled(l) {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rawJSON {
+		_, _ = raw.Writer().Write(append(entry, '\n'))
+		return
+	}
+	raw.Println(string(entry))
+}
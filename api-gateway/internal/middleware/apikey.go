@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"my_app/api-gateway/internal/errpage"
+)
+
+// --- API Key Middleware ---
+
+// APIKeyHeader carries the client's API key.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKeys requires a valid X-Api-Key on every request except public paths.
+//
+// Several keys may be valid at once, which is how keys are rotated without
+// downtime: add the new key next to the old one, reload (SIGHUP or POST
+// /admin/reload with API_KEYS in the config file's settings) or roll the
+// deployment, move clients to the new key, then remove the old one the same
+// way.
+type APIKeys struct {
+	keys   atomic.Pointer[[][sha256.Size]byte]
+	public []string
+}
+
+// NewAPIKeys checks keys, letting public paths through. A public entry
+// ending in "*" matches every path with that prefix; others match exactly.
+// With no keys the check is disabled.
+func NewAPIKeys(keys, public []string) *APIKeys {
+	a := &APIKeys{public: public}
+	a.SetKeys(keys)
+	return a
+}
+
+// SetKeys replaces the set of valid keys.
+func (a *APIKeys) SetKeys(keys []string) {
+	// Comparing fixed-size digests keeps the comparison constant-time
+	// whatever the key lengths.
+	sums := make([][sha256.Size]byte, 0, len(keys))
+	for _, k := range keys {
+		if k != "" {
+			sums = append(sums, sha256.Sum256([]byte(k)))
+		}
+	}
+	a.keys.Store(&sums)
+}
+
+// Middleware answers 401 to requests without a valid key.
+func (a *APIKeys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := *a.keys.Load()
+		if len(keys) == 0 || isPublicPath(a.public, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := r.Header.Get(APIKeyHeader)
+		if got == "" {
+			errpage.Write(w, r, http.StatusUnauthorized, "missing API key")
+			return
+		}
+		sum := sha256.Sum256([]byte(got))
+		match := 0
+		// No early exit, so timing doesn't tell which key matched
+		for i := range keys {
+			match |= subtle.ConstantTimeCompare(sum[:], keys[i][:])
+		}
+		if match != 1 {
+			errpage.Write(w, r, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isPublicPath reports whether path is in public, where an entry ending in
+// "*" matches every path with that prefix.
+func isPublicPath(public []string, path string) bool {
+	for _, p := range public {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
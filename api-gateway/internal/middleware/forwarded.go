@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- Trusted proxies ---
+
+// TrustedProxies is the set of peers whose X-Forwarded-* headers are
+// believed. Entries are IPs or CIDRs; an empty set trusts nobody.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// NewTrustedProxies parses IP and CIDR entries, skipping invalid ones.
+func NewTrustedProxies(entries []string) *TrustedProxies {
+	t := &TrustedProxies{}
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				continue
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			e = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		if _, n, err := net.ParseCIDR(e); err == nil {
+			t.nets = append(t.nets, n)
+		}
+	}
+	return t
+}
+
+// Trusted reports whether r came directly from a trusted proxy.
+func (t *TrustedProxies) Trusted(r *http.Request) bool {
+	return t.contains(net.ParseIP(peerIP(r)))
+}
+
+func (t *TrustedProxies) contains(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the address of the socket peer, without the port.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwarded is what ForwardedMiddleware learned about the original client.
+type forwarded struct {
+	scheme   string
+	host     string
+	clientIP string
+}
+
+type forwardedKey struct{}
+
+// ForwardedMiddleware records the scheme, host and IP of the original
+// client. X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-For are
+// honored only from trusted proxies; otherwise all three reflect the
+// connection the gateway accepted.
+func ForwardedMiddleware(next http.Handler, trusted *TrustedProxies) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fwd := forwarded{scheme: "http", host: r.Host, clientIP: peerIP(r)}
+		if r.TLS != nil {
+			fwd.scheme = "https"
+		}
+		if trusted.Trusted(r) {
+			// X-Forwarded-Proto: https, http (first hop is the client's)
+			proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+			switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+			case "http", "https":
+				fwd.scheme = proto
+			}
+			host, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ",")
+			if host = strings.TrimSpace(host); host != "" {
+				fwd.host = host
+			}
+			if ip := forwardedFor(r, trusted); ip != "" {
+				fwd.clientIP = ip
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey{}, fwd)))
+	})
+}
+
+// forwardedFor walks X-Forwarded-For right to left, skipping trusted
+// proxies, and returns the first untrusted address: the nearest hop that
+// could not have been forged by a proxy we trust. Anything left of it is
+// client-supplied. If every hop is trusted the leftmost one is returned.
+func forwardedFor(r *http.Request, trusted *TrustedProxies) string {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage from here on can't be trusted
+		}
+		client = ip.String()
+		if !trusted.contains(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// Scheme returns the original client scheme ("http" or "https") recorded
+// by ForwardedMiddleware.
+func Scheme(r *http.Request) string {
+	if fwd, ok := r.Context().Value(forwardedKey{}).(forwarded); ok {
+		return fwd.scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host the original client asked for, as recorded by
+// ForwardedMiddleware, or r.Host if the middleware did not run.
+func Host(r *http.Request) string {
+	if fwd, ok := r.Context().Value(forwardedKey{}).(forwarded); ok {
+		return fwd.host
+	}
+	return r.Host
+}
+
+// ClientIP returns the original client IP recorded by ForwardedMiddleware,
+// or the socket peer if the middleware did not run.
+func ClientIP(r *http.Request) string {
+	if fwd, ok := r.Context().Value(forwardedKey{}).(forwarded); ok {
+		return fwd.clientIP
+	}
+	return peerIP(r)
+}
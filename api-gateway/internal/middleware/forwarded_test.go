@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// seen runs a request from remote with headers through ForwardedMiddleware
+// and returns what it recorded.
+func seen(trusted *TrustedProxies, remote string, headers map[string]string) (scheme, host, ip string) {
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.internal/agent", nil)
+	req.RemoteAddr = remote
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	ForwardedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, host, ip = Scheme(r), Host(r), ClientIP(r)
+	}), trusted).ServeHTTP(httptest.NewRecorder(), req)
+	return scheme, host, ip
+}
+
+var spoofed = map[string]string{
+	"X-Forwarded-For":   "1.2.3.4",
+	"X-Forwarded-Proto": "https",
+	"X-Forwarded-Host":  "evil.example",
+}
+
+func TestForwardedIgnoresUntrustedPeer(t *testing.T) {
+	for name, trusted := range map[string]*TrustedProxies{
+		"no trusted proxies":    NewTrustedProxies(nil),
+		"peer outside the list": NewTrustedProxies([]string{"10.0.0.0/8"}),
+	} {
+		scheme, host, ip := seen(trusted, "203.0.113.9:5555", spoofed)
+		if scheme != "http" || host != "gateway.internal" || ip != "203.0.113.9" {
+			t.Errorf("%s: got scheme %q, host %q, ip %q; spoofed headers were believed", name, scheme, host, ip)
+		}
+	}
+}
+
+func TestForwardedTrustsListedProxy(t *testing.T) {
+	for _, tc := range []struct {
+		entry, remote, proto, want string
+	}{
+		{"10.0.0.0/8", "10.0.0.2:5555", "https", "https"},
+		{"10.0.0.2", "10.0.0.2:5555", "HTTPS, http", "https"},
+		{"::1", "[::1]:5555", "https", "https"},
+		{"10.0.0.0/8", "10.0.0.2:5555", "gopher", "http"},
+	} {
+		scheme, _, _ := seen(NewTrustedProxies([]string{tc.entry}), tc.remote, map[string]string{"X-Forwarded-Proto": tc.proto})
+		if scheme != tc.want {
+			t.Errorf("trusting %s, X-Forwarded-Proto %q from %s: scheme %q, want %q", tc.entry, tc.proto, tc.remote, scheme, tc.want)
+		}
+	}
+}
+
+func TestNewTrustedProxiesSkipsInvalidEntries(t *testing.T) {
+	trusted := NewTrustedProxies([]string{"not-an-ip", "10.0.0.0/33", "192.0.2.1"})
+	if len(trusted.nets) != 1 {
+		t.Fatalf("parsed %d networks, want only 192.0.2.1", len(trusted.nets))
+	}
+}
+
+func TestForwardedTrustsListedProxyForClientIP(t *testing.T) {
+	scheme, host, ip := seen(NewTrustedProxies([]string{"10.0.0.0/8"}), "10.0.0.2:5555", spoofed)
+	if scheme != "https" || host != "evil.example" || ip != "1.2.3.4" {
+		t.Fatalf("got scheme %q, host %q, ip %q from a trusted proxy", scheme, host, ip)
+	}
+}
+
+func TestForwardedForSkipsOnlyTrustedHops(t *testing.T) {
+	trusted := NewTrustedProxies([]string{"10.0.0.0/8"})
+	for _, tc := range []struct {
+		xff, want string
+	}{
+		// The client prepended a fake address; the proxy appended the real one
+		{"6.6.6.6, 198.51.100.7", "198.51.100.7"},
+		{"198.51.100.7, 10.0.0.5", "198.51.100.7"},
+		{"10.0.0.7, 10.0.0.5", "10.0.0.7"},
+	} {
+		_, _, ip := seen(trusted, "10.0.0.2:5555", map[string]string{"X-Forwarded-For": tc.xff})
+		if ip != tc.want {
+			t.Errorf("X-Forwarded-For %q: client %q, want %q", tc.xff, ip, tc.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Gzip Middleware ---
+
+// gzipMinLength is the smallest declared Content-Length worth compressing;
+// below it the gzip framing costs more than it saves.
+const gzipMinLength = 256
+
+// Gzip compresses responses for clients that accept gzip. Writers are
+// pooled per level so compression does not allocate a new (large) gzip
+// writer for every request.
+type Gzip struct {
+	level int
+	pool  sync.Pool
+}
+
+// NewGzip returns gzip compression at level (1 = fastest, 9 = smallest).
+// Out-of-range levels, which config.Load rejects, fall back to 5; level 0
+// disables compression.
+func NewGzip(level int) *Gzip {
+	if level < 0 || level > gzip.BestCompression {
+		level = 5
+	}
+	g := &Gzip{level: level}
+	g.pool.New = func() interface{} {
+		zw, _ := gzip.NewWriterLevel(io.Discard, g.level)
+		return zw
+	}
+	return g
+}
+
+// Middleware compresses eligible responses. Event streams, already
+// compressed media, responses that already carry a Content-Encoding or
+// lack a Content-Type when the header is written, responses declaring a
+// Content-Length under gzipMinLength, and bodiless responses pass through.
+// Every response carries Vary: Accept-Encoding, so caches keep the
+// variants apart.
+func (g *Gzip) Middleware(next http.Handler) http.Handler {
+	if g.level == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, g: g}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip. Codings
+// are matched case-insensitively, a zero q-value refuses a coding, and an
+// explicit gzip entry takes precedence over "*".
+func acceptsGzip(accept string) bool {
+	wildcard := false
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			return qAllows(params)
+		case "*":
+			wildcard = qAllows(params)
+		}
+	}
+	return wildcard
+}
+
+// qAllows reports whether a coding's parameters leave it acceptable, that is
+// carry no q-value of zero.
+func qAllows(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err != nil || q > 0
+	}
+	return true
+}
+
+// compressible reports whether compressing a contentType body is worth it:
+// not for event streams, which must reach the client unbuffered, nor for
+// formats that are compressed already.
+func compressible(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	switch {
+	case ct == "text/event-stream":
+		return false
+	case ct == "image/svg+xml":
+		return true
+	case strings.HasPrefix(ct, "image/"), strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"),
+		strings.HasPrefix(ct, "font/woff"):
+		return false
+	}
+	switch ct {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/zstd",
+		"application/x-bzip2", "application/x-7z-compressed", "application/x-xz",
+		"application/pdf", "application/octet-stream":
+		return false
+	}
+	return true
+}
+
+// gzipResponseWriter decides on the first write whether to compress.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	g       *Gzip
+	zw      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) decide(status int) {
+	// Informational responses (103 Early Hints) precede the real one
+	if w.decided || status < 200 {
+		return
+	}
+	w.decided = true
+	h := w.Header()
+	// Without a Content-Type net/http would sniff the compressed bytes
+	if h.Get("Content-Type") == "" || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < gzipMinLength {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.zw = w.g.pool.Get().(*gzip.Writer)
+	w.zw.Reset(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	w.decide(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.zw == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.zw.Write(b)
+}
+
+// Flush flushes compressed data written so far to the client. Flushing
+// before any write sends the headers, so the decision is made first.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.zw == nil {
+		return
+	}
+	_ = w.zw.Close()
+	w.zw.Reset(io.Discard)
+	w.g.pool.Put(w.zw)
+	w.zw = nil
+}
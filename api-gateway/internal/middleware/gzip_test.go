@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// gzipGet sends a gzip-accepting GET through Gzip(level 5) around h.
+func gzipGet(t *testing.T, h http.HandlerFunc) *http.Response {
+	t.Helper()
+	srv := httptest.NewServer(NewGzip(5).Middleware(h))
+	t.Cleanup(srv.Close)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip") // set by hand, so not decoded transparently
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// gunzip decompresses resp's body, failing unless it is gzip-encoded.
+func gunzip(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	if ce := resp.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", ce)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	want := strings.Repeat(`{"item":"shoes"},`, 200)
+	resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, want)
+	})
+	if got := gunzip(t, resp); got != want {
+		t.Fatalf("decompressed %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestGzipFlushBeforeWrite(t *testing.T) {
+	want := "first chunk, then more"
+	resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.(http.Flusher).Flush() // push headers early
+		io.WriteString(w, want)
+	})
+	if got := gunzip(t, resp); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestGzipAfterEarlyHints(t *testing.T) {
+	want := strings.Repeat("<p>hello</p>", 100)
+	resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, want)
+	})
+	if got := gunzip(t, resp); got != want {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                       false,
+		"gzip":                   true,
+		"GZip, deflate":          true,
+		"br;q=1.0, gzip;q=0.5":   true,
+		"gzip;q=0":               false,
+		"gzip; Q=0.000":          false,
+		"*":                      true,
+		"*;q=0":                  false,
+		"gzip;q=0, *":            false,
+		"*, gzip;q=0":            false,
+		"*;q=0, gzip":            true,
+		"identity, deflate;q=.5": false,
+	} {
+		if got := acceptsGzip(accept); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestGzipSetsVaryAndDropsContentLength(t *testing.T) {
+	body := strings.Repeat(`{"item":"shoes"},`, 200)
+	resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	})
+	if v := resp.Header.Get("Vary"); v != "Accept-Encoding" {
+		t.Errorf("Vary = %q", v)
+	}
+	// The handler's length is for the uncompressed body
+	if resp.ContentLength == int64(len(body)) {
+		t.Errorf("Content-Length = %d, the uncompressed size", resp.ContentLength)
+	}
+	if got := gunzip(t, resp); got != body {
+		t.Fatalf("decompressed %d bytes, want %d", len(got), len(body))
+	}
+}
+
+func TestGzipSkipsSmallAndEncodedBodies(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"small": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "11")
+			io.WriteString(w, `{"ok":true}`)
+		},
+		"encoded": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, strings.Repeat("x", 1000))
+		},
+		"event stream": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, strings.Repeat("data: x\n\n", 100))
+		},
+	} {
+		resp := gzipGet(t, h)
+		if ce := resp.Header.Get("Content-Encoding"); ce == "gzip" {
+			t.Errorf("%s: compressed", name)
+		}
+		if v := resp.Header.Get("Vary"); v != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", name, v)
+		}
+	}
+}
+
+func TestGzipRespectsRefusal(t *testing.T) {
+	body := strings.Repeat("<p>hello</p>", 100)
+	srv := httptest.NewServer(NewGzip(5).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, body)
+	})))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Encoding") != "" || string(b) != body {
+		t.Fatalf("gzip;q=0 got Content-Encoding %q and %d bytes", resp.Header.Get("Content-Encoding"), len(b))
+	}
+	// Caches must not serve this identity response to gzip clients either
+	if v := resp.Header.Get("Vary"); v != "Accept-Encoding" {
+		t.Errorf("Vary = %q", v)
+	}
+}
+
+// BenchmarkGzipLevel compresses a docs-sized JSON response at each level;
+// compare ns/op and B/op to pick GZIP_LEVEL.
+func BenchmarkGzipLevel(b *testing.B) {
+	var doc strings.Builder
+	for i := 0; doc.Len() < 256<<10; i++ {
+		fmt.Fprintf(&doc, `{"path":"/svc/items/%d","summary":"List items","responses":{"200":{"description":"OK"}}},`, i)
+	}
+	body := []byte(doc.String())
+	for _, level := range []int{1, 5, 9} {
+		b.Run("level"+strconv.Itoa(level), func(b *testing.B) {
+			h := NewGzip(level).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api-docs/aggregate", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			var compressed int
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				compressed = rec.Body.Len()
+			}
+			b.ReportMetric(float64(compressed)/float64(len(body)), "ratio")
+		})
+	}
+}
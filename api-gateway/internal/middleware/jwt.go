@@ -0,0 +1,380 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256/PS256/ES256
+	_ "crypto/sha512" // SHA-384/512 for the larger variants
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/errpage"
+)
+
+// --- JWT Middleware ---
+
+const (
+	// jwtClockSkew is tolerated between the issuer's clock and ours.
+	jwtClockSkew = 30 * time.Second
+	// jwksMinRefresh throttles refreshes triggered by unknown keys, so
+	// tokens with made-up key ids can't hammer the IdP.
+	jwksMinRefresh = 10 * time.Second
+	// jwksRetryDelay is how long a failed JWKS fetch holds off the next
+	// one; the previous keys, if any, are served meanwhile.
+	jwksRetryDelay = 10 * time.Second
+	// jwksFetchTimeout bounds a JWKS fetch, which runs on its own context
+	// so that one client giving up doesn't fail it for everyone waiting.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// UserIDHeader carries the validated token's subject upstream.
+const UserIDHeader = "X-User-Id"
+
+// JWTConfig configures JWT validation.
+type JWTConfig struct {
+	JWKSURL  string // "" disables validation
+	Issuer   string // required iss, if set
+	Audience string // required aud entry, if set
+	// Public paths skip validation, matched like APIKeys public paths.
+	Public []string
+	// Refresh is how long fetched keys are used before fetching again.
+	Refresh time.Duration
+	// ForwardUserID sets X-User-Id to the token's sub for upstreams; a
+	// client-supplied X-User-Id is always dropped.
+	ForwardUserID bool
+	Client        *http.Client
+}
+
+// JWTAuth validates Bearer tokens against the keys published at a JWKS URL.
+type JWTAuth struct {
+	cfg  JWTConfig
+	jwks *jwksCache
+}
+
+// NewJWTAuth returns a validator for cfg.
+func NewJWTAuth(cfg JWTConfig) *JWTAuth {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWTAuth{cfg: cfg, jwks: &jwksCache{url: cfg.JWKSURL, ttl: cfg.Refresh, client: cfg.Client}}
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaims returns the claims of the token validated for r, or nil.
+func JWTClaims(r *http.Request) map[string]interface{} {
+	claims, _ := r.Context().Value(jwtClaimsKey{}).(map[string]interface{})
+	return claims
+}
+
+// Middleware answers 401 to requests without a valid Bearer token and
+// stores the claims of valid ones in the request context. A client-supplied
+// X-User-Id is dropped even when validation is disabled.
+func (a *JWTAuth) Middleware(next http.Handler) http.Handler {
+	if a.cfg.JWKSURL == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del(UserIDHeader)
+			next.ServeHTTP(w, r)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(UserIDHeader)
+		if isPublicPath(a.cfg.Public, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			errpage.Write(w, r, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		claims, err := a.validate(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			errpage.Write(w, r, http.StatusUnauthorized, "invalid token: "+err.Error())
+			return
+		}
+		if sub, _ := claims["sub"].(string); a.cfg.ForwardUserID && sub != "" {
+			r.Header.Set(UserIDHeader, sub)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims)))
+	})
+}
+
+// validate checks token's signature and its exp, nbf, iss and aud claims.
+func (a *JWTAuth) validate(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	key, err := a.jwks.key(ctx, header.Kid, false)
+	if err == nil {
+		err = verifyJWS(header.Alg, key, signed, sig)
+	}
+	if err != nil && !errors.Is(err, errUnsupportedAlg) {
+		// The IdP may have rotated its keys since the last fetch
+		if key, kerr := a.jwks.key(ctx, header.Kid, true); kerr == nil {
+			err = verifyJWS(header.Alg, key, signed, sig)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("missing exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtClockSkew)) {
+		return nil, errors.New("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("not yet valid")
+	}
+	if iss, _ := claims["iss"].(string); a.cfg.Issuer != "" && iss != a.cfg.Issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+	if a.cfg.Audience != "" && !hasAudience(claims["aud"], a.cfg.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether aud, a string or an array of strings,
+// contains want.
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var errUnsupportedAlg = errors.New("unsupported alg")
+
+// verifyJWS checks sig over signed for the RS, PS and ES algorithms; HMAC
+// and "none" are refused since a JWKS only publishes public keys.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return errUnsupportedAlg
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("malformed signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return errUnsupportedAlg
+}
+
+// jwksCache holds the keys published at url, fetched lazily and again
+// once older than ttl, or on demand when a token names an unknown key.
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	retryAt   time.Time     // no fetches before this, after a failure
+	fetching  chan struct{} // closed when the fetch in flight ends
+}
+
+// key returns the key with id kid ("" = the only key). force refetches the
+// set first, at most once per jwksMinRefresh. Concurrent callers share one
+// fetch, which runs without holding the lock; a caller whose ctx ends stops
+// waiting but leaves the fetch running for the others. After a failed
+// fetch, stale keys are served and no fetch is tried for jwksRetryDelay.
+func (c *jwksCache) key(ctx context.Context, kid string, force bool) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	age := time.Since(c.fetchedAt)
+	due := c.keys == nil || (c.ttl > 0 && age > c.ttl) || (force && age > jwksMinRefresh)
+	if due && !time.Now().Before(c.retryAt) {
+		if c.fetching == nil {
+			c.fetching = make(chan struct{})
+			go c.refresh(c.fetching)
+		}
+		done := c.fetching
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		return nil, errors.New("signing keys unavailable")
+	}
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, nil
+		}
+	}
+	if k, ok := c.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// refresh fetches the key set on a context of its own, bounded by
+// jwksFetchTimeout, stores the outcome and closes done.
+func (c *jwksCache) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		slog.Warn("[jwt] fetching JWKS failed", "url", c.url, "err", err, "retry_in", jwksRetryDelay)
+		c.retryAt = time.Now().Add(jwksRetryDelay)
+	} else {
+		c.keys, c.fetchedAt, c.retryAt = keys, time.Now(), time.Time{}
+	}
+	c.fetching = nil
+	close(done)
+}
+
+func (c *jwksCache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer publishes one RSA key as "k1", or answers 503 while failing
+// is set. hits counts requests.
+func jwksServer(t *testing.T, failing *atomic.Bool, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJWKSServesStaleKeysAndBacksOff(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	srv := jwksServer(t, &failing, &hits)
+	c := &jwksCache{url: srv.URL, ttl: time.Millisecond, client: srv.Client()}
+
+	if _, err := c.key(context.Background(), "k1", false); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if _, err := c.key(context.Background(), "k1", false); err != nil {
+			t.Fatalf("stale key not served: %v", err)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2 (one failure, then backoff)", n)
+	}
+}
+
+func TestJWKSBacksOffWithoutKeys(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	failing.Store(true)
+	srv := jwksServer(t, &failing, &hits)
+	c := &jwksCache{url: srv.URL, client: srv.Client()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.key(context.Background(), "k1", false); err == nil {
+			t.Fatal("key found without a JWKS")
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Once the backoff has passed, the next request fetches again
+	failing.Store(false)
+	c.mu.Lock()
+	c.retryAt = time.Now()
+	c.mu.Unlock()
+	if _, err := c.key(context.Background(), "k1", false); err != nil {
+		t.Fatalf("key after recovery: %v", err)
+	}
+}
+
+func TestJWKSFetchIsSharedAndOutlivesCancelledCallers(t *testing.T) {
+	var failing atomic.Bool
+	var hits, gated atomic.Int32
+	srv := jwksServer(t, &failing, &hits)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gated.Add(1)
+		<-release
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer slow.Close()
+	c := &jwksCache{url: slow.URL, client: srv.Client()}
+
+	// A caller that gives up gets its own error without failing the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.key(ctx, "k1", false); err != context.DeadlineExceeded {
+		t.Fatalf("abandoned key = %v, want the caller's deadline", err)
+	}
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := c.key(context.Background(), "k1", false)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("waiting caller: %v", err)
+		}
+	}
+	if n := gated.Load(); n != 1 {
+		t.Fatalf("JWKS fetched %d times, want one shared fetch", n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.retryAt.IsZero() {
+		t.Fatal("abandoned caller put the cache into backoff")
+	}
+}
+
+func TestJWTDisabledStillDropsUserID(t *testing.T) {
+	var got string
+	h := NewJWTAuth(JWTConfig{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(UserIDHeader)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/agent/chat", nil)
+	req.Header.Set(UserIDHeader, "admin")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "" {
+		t.Fatalf("upstream saw %s = %q, want it dropped", UserIDHeader, got)
+	}
+}
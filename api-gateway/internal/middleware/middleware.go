@@ -1,53 +1,317 @@
 package middleware
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/logging"
 )
 
 // --- Logging Middleware ---
 
+// statusRecorder captures the status code and counts the body bytes written.
 type statusRecorder struct {
 	http.ResponseWriter
-	status int
+	status  int
+	bytes   int64
+	started bool // the response headers have been sent
 }
 
 func (rec *statusRecorder) WriteHeader(code int) {
 	rec.status = code
+	rec.started = true
 	rec.ResponseWriter.WriteHeader(code)
 }
 
-// StructuredLoggingMiddleware logs requests in JSON format
-func StructuredLoggingMiddleware(next http.Handler) http.Handler {
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.started = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// BytesWritten returns the number of response body bytes written so far.
+func (rec *statusRecorder) BytesWritten() int64 {
+	return rec.bytes
+}
+
+type logFieldsKey struct{}
+
+// logFields lets code running after routing (the matched route, the proxy)
+// add to the access-log entry of the logging middleware, which runs first.
+type logFields struct {
+	mu       sync.Mutex
+	success  StatusSet
+	route    string
+	upstream string
+}
+
+func withLogFields(r *http.Request) (*http.Request, *logFields) {
+	h := &logFields{success: DefaultSLOSuccess}
+	return r.WithContext(context.WithValue(r.Context(), logFieldsKey{}, h)), h
+}
+
+func logFieldsFrom(r *http.Request) *logFields {
+	h, _ := r.Context().Value(logFieldsKey{}).(*logFields)
+	return h
+}
+
+// SetRoute records the route pattern r matched, logged as "route".
+func SetRoute(r *http.Request, pattern string) {
+	if h := logFieldsFrom(r); h != nil {
+		h.mu.Lock()
+		h.route = pattern
+		h.mu.Unlock()
+	}
+}
+
+// SetUpstream records the base URL r was proxied to, logged as "upstream".
+func SetUpstream(r *http.Request, base string) {
+	if h := logFieldsFrom(r); h != nil {
+		h.mu.Lock()
+		h.upstream = base
+		h.mu.Unlock()
+	}
+}
+
+// Flush lets streamed responses reach the client through the logger.
+func (rec *statusRecorder) Flush() {
+	rec.started = true
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over, e.g. for a WebSocket upgrade; the
+// request is logged as 101 Switching Protocols.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// Through the controller, so wrappers that only Unwrap are passed
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+		rec.started = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// LogSampler decides which successful requests get an access-log entry.
+// Errors (status >= 400) are always logged.
+type LogSampler struct {
+	rate int
+	mu   sync.Mutex
+	rng  *rand.Rand
+}
+
+// NewLogSampler logs roughly one in rate successful requests; rate <= 1
+// logs everything. The seed makes sampling reproducible.
+func NewLogSampler(rate int, seed uint64) *LogSampler {
+	if rate < 1 {
+		rate = 1
+	}
+	return &LogSampler{rate: rate, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// keep reports whether a request with the given status should be logged,
+// the sample rate it represents, and whether it was subject to sampling.
+func (s *LogSampler) keep(status int) (keep bool, rate int, sampled bool) {
+	if s == nil || status >= 400 || s.rate <= 1 {
+		return true, 1, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.IntN(s.rate) == 0, s.rate, true
+}
+
+// logTime controls the "ts" field of structured log entries.
+var logTime = struct {
+	format string // "rfc3339", "rfc3339nano" or "unixms"
+	utc    bool
+}{format: "rfc3339", utc: true}
+
+// SetLogTimeFormat sets how structured logs render timestamps: "rfc3339",
+// "rfc3339nano" or "unixms" (milliseconds since the epoch, as a number).
+// With utc set, RFC 3339 timestamps are rendered in UTC. It should be
+// called once at startup.
+func SetLogTimeFormat(format string, utc bool) error {
+	switch format {
+	case "rfc3339", "rfc3339nano", "unixms":
+	default:
+		return fmt.Errorf("unknown log time format %q", format)
+	}
+	logTime.format, logTime.utc = format, utc
+	return nil
+}
+
+// logTimestamp renders t for the "ts" field of a structured log entry.
+func logTimestamp(t time.Time) interface{} {
+	if logTime.utc {
+		t = t.UTC()
+	}
+	switch logTime.format {
+	case "unixms":
+		return t.UnixMilli()
+	case "rfc3339nano":
+		return t.Format(time.RFC3339Nano)
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// StructuredLoggingMiddleware logs requests in JSON format. Successful
+// requests are sampled by sampler (nil logs everything). A request whose
+// handler panics is logged too, as the 500 RecoveryMiddleware answers it
+// with unless the response had already started.
+func StructuredLoggingMiddleware(next http.Handler, sampler *LogSampler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r, fields := withLogFields(r)
+		returned := false
+		defer func() {
+			status := rec.status
+			if !returned && !rec.started {
+				status = http.StatusInternalServerError
+			}
+			logAccess(r, rec, fields, sampler, status, start)
+		}()
 		next.ServeHTTP(rec, r)
+		returned = true
+	})
+}
+
+// logAccess writes the access-log entry of a request answered with status.
+func logAccess(r *http.Request, rec *statusRecorder, fields *logFields, sampler *LogSampler, status int, start time.Time) {
+	duration := time.Since(start)
+	keep, sampleRate, sampled := sampler.keep(status)
+	if !keep {
+		return
+	}
 
-		duration := time.Since(start)
-
-		logEntry := map[string]interface{}{
-			"level":       "info",
-			"ts":          start.Format(time.RFC3339),
-			"method":      r.Method,
-			"path":        r.URL.Path,
-			"remote_addr": r.RemoteAddr,
-			"status":      rec.status,
-			"duration_ms": duration.Milliseconds(),
-			"user_agent":  r.UserAgent(),
+	// Server errors are logged at error level, the rest at info
+	level, levelName := slog.LevelInfo, "info"
+	if status >= 500 {
+		level, levelName = slog.LevelError, "error"
+	}
+	if !logging.Enabled(level) {
+		return
+	}
+	logEntry := map[string]interface{}{
+		"level":         levelName,
+		"ts":            logTimestamp(start),
+		"method":        r.Method,
+		"path":          r.URL.Path,
+		"remote_addr":   r.RemoteAddr,
+		"request_id":    RequestID(r),
+		"status":        status,
+		"bytes_written": rec.BytesWritten(),
+		"duration_ms":   duration.Milliseconds(),
+		"user_agent":    r.UserAgent(),
+		"sampled":       sampled,
+		"sample_rate":   sampleRate,
+	}
+	fields.mu.Lock()
+	logEntry["slo_success"] = fields.success.Contains(status)
+	if fields.route != "" {
+		logEntry["route"] = fields.route
+	}
+	if fields.upstream != "" {
+		logEntry["upstream"] = fields.upstream
+	}
+	fields.mu.Unlock()
+
+	jsonBytes, _ := json.Marshal(logEntry)
+	logging.JSON(level, jsonBytes)
+}
+
+// --- Method Policy Middleware ---
+
+// allowedMethods lists the methods the gateway accepts at all; individual
+// routes narrow this further.
+const allowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// MethodPolicyMiddleware enforces the gateway-wide method policy:
+//   - TRACE is rejected with 405 to prevent cross-site tracing (XST), which
+//     would echo credentials such as cookies back to the caller.
+//   - CONNECT is rejected with 405: the gateway proxies requests, it does
+//     not open tunnels (WebSocket upgrades use GET and are unaffected).
+//
+// Rejected requests never reach the mux or any upstream.
+func MethodPolicyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodTrace || r.Method == http.MethodConnect {
+			w.Header().Set("Allow", allowedMethods)
+			errpage.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- URL Length Middleware ---
 
-		// Use standard log, but format as JSON
-		jsonBytes, _ := json.Marshal(logEntry)
-		log.Println(string(jsonBytes))
+// MaxURLLengthMiddleware rejects requests whose request URI (path plus
+// query) is longer than max bytes with 414 URI Too Long, before they reach
+// routing or any upstream. max <= 0 disables the check.
+func MaxURLLengthMiddleware(next http.Handler, max int) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.RequestURI) > max {
+			errpage.Write(w, r, http.StatusRequestURITooLong, "URI Too Long")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- Request Body Limit Middleware ---
+
+// MaxBodyMiddleware caps request bodies at max bytes. A declared
+// Content-Length over the cap is rejected with 413 up front; otherwise the
+// body is wrapped in http.MaxBytesReader, whose *http.MaxBytesError
+// handlers turn into 413. max <= 0 disables the cap.
+func MaxBodyMiddleware(next http.Handler, max int64) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			errpage.Write(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- Instance Header Middleware ---
+
+// InstanceHeaderMiddleware tags every response with X-Gateway-Instance so
+// clients can tell which gateway replica handled a request.
+func InstanceHeaderMiddleware(next http.Handler, instanceID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Gateway-Instance", instanceID)
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -55,21 +319,82 @@ func StructuredLoggingMiddleware(next http.Handler) http.Handler {
 
 // RateLimiter manages rate limits per IP
 type RateLimiter struct {
-	ips map[string]*rate.Limiter
+	ips map[string]*clientLimiter
 	mu  sync.Mutex
 	r   rate.Limit
 	b   int
+
+	idleTTL  time.Duration
+	now      func() time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
 }
 
 // NewRateLimiter creates a custom rate limiter
 // r: limit (events/second)
 // b: burst
-func NewRateLimiter(r rate.Limit, b int) *RateLimiter {
-	// In a real app run a background goroutine to clean up old IPs
-	return &RateLimiter{
-		ips: make(map[string]*rate.Limiter),
-		r:   r,
-		b:   b,
+// idleTTL: clients unseen for this long are forgotten (0 keeps them forever)
+//
+// With idleTTL > 0 a cleanup goroutine runs until Stop is called.
+func NewRateLimiter(r rate.Limit, b int, idleTTL time.Duration) *RateLimiter {
+	l := &RateLimiter{
+		ips:     make(map[string]*clientLimiter),
+		r:       r,
+		b:       b,
+		idleTTL: idleTTL,
+		now:     time.Now,
+		stop:    make(chan struct{}),
+	}
+	if idleTTL > 0 {
+		go l.cleanupLoop(max(idleTTL/2, time.Second))
+	}
+	return l
+}
+
+// Stop halts the idle-client cleanup goroutine.
+func (l *RateLimiter) Stop() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+func (l *RateLimiter) cleanupLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			l.evictIdle()
+		}
+	}
+}
+
+// evictIdle drops clients not seen for idleTTL. A dropped client that
+// comes back starts again with a full burst.
+func (l *RateLimiter) evictIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := l.now().Add(-l.idleTTL)
+	for ip, c := range l.ips {
+		if c.lastSeen.Before(cutoff) {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// SetLimit changes the rate and burst for new and existing clients.
+func (l *RateLimiter) SetLimit(r rate.Limit, b int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.r, l.b = r, b
+	for _, c := range l.ips {
+		c.limiter.SetLimit(r)
+		c.limiter.SetBurst(b)
 	}
 }
 
@@ -77,42 +402,127 @@ func (l *RateLimiter) getLimiter(ip string) *rate.Limiter {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	limiter, exists := l.ips[ip]
+	c, exists := l.ips[ip]
 	if !exists {
-		limiter = rate.NewLimiter(l.r, l.b)
-		l.ips[ip] = limiter
+		c = &clientLimiter{limiter: rate.NewLimiter(l.r, l.b)}
+		l.ips[ip] = c
 	}
-	return limiter
+	c.lastSeen = l.now()
+	return c.limiter
 }
 
 // Middleware applies rate limiting based on IP
 func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getIP(r)
+		ip := ClientIP(r)
 		limiter := l.getLimiter(ip)
 		if !limiter.Allow() {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Too Many Requests",
-			})
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limiter)))
+			msg := "Too Many Requests"
+			if errpage.WantsHTML(r) {
+				// Browsers get a friendlier page; API clients keep the terse body
+				msg = "You are sending requests too quickly. Please wait a moment and try again."
+			}
+			errpage.Write(w, r, http.StatusTooManyRequests, msg)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// getIP extracts the client IP, preferring X-Forwarded-For if available
-func getIP(r *http.Request) string {
-	xfwd := r.Header.Get("X-Forwarded-For")
-	if xfwd != "" {
-		// X-Forwarded-For: client, proxy1, proxy2
-		ips := strings.Split(xfwd, ",")
-		return strings.TrimSpace(ips[0])
+// maxRetryAfter caps Retry-After, including for limiters that will never
+// allow a request (a zero rate reserves rate.InfDuration).
+const maxRetryAfter = time.Hour
+
+// retryAfterSeconds estimates when limiter will next allow a request,
+// rounded up to whole seconds (between 1 and maxRetryAfter).
+func retryAfterSeconds(limiter *rate.Limiter) int {
+	res := limiter.Reserve()
+	delay := res.Delay()
+	res.Cancel()
+	if !res.OK() || delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return max(1, int(math.Ceil(delay.Seconds())))
+}
+
+// --- Concurrency Limiting Middleware ---
+
+// ConcurrencyLimiter caps simultaneous in-flight requests per client,
+// keyed the same way as RateLimiter.
+//
+// Admitted requests get an X-Gateway-Load header ("low", "medium" or
+// "high") describing how close the client is to its cap, so well-behaved
+// clients can slow down before they are rejected.
+type ConcurrencyLimiter struct {
+	inflight  map[string]int
+	mu        sync.Mutex
+	max       int
+	highRatio float64 // share of max at which load is reported "high"
+}
+
+// NewConcurrencyLimiter creates a limiter allowing max concurrent requests
+// per client. max <= 0 disables the limit. Load is reported "high" from
+// highRatio of max in flight and "medium" from half of that.
+func NewConcurrencyLimiter(max int, highRatio float64) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		inflight:  make(map[string]int),
+		max:       max,
+		highRatio: highRatio,
+	}
+}
+
+// acquire admits a request for key and returns the client's in-flight
+// count including it.
+func (l *ConcurrencyLimiter) acquire(key string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[key] >= l.max {
+		return l.inflight[key], false
+	}
+	l.inflight[key]++
+	return l.inflight[key], true
+}
+
+// loadLevel maps an in-flight count to the X-Gateway-Load value.
+func (l *ConcurrencyLimiter) loadLevel(inflight int) string {
+	ratio := float64(inflight) / float64(l.max)
+	switch {
+	case ratio >= l.highRatio:
+		return "high"
+	case ratio >= l.highRatio/2:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+func (l *ConcurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[key] <= 1 {
+		// Drop idle keys so the map only holds clients with requests in flight
+		delete(l.inflight, key)
+		return
 	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	l.inflight[key]--
+}
+
+// Middleware rejects requests beyond the per-client concurrency cap
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	if l.max <= 0 {
+		return next
 	}
-	return ip
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ClientIP(r)
+		inflight, ok := l.acquire(key)
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			errpage.Write(w, r, http.StatusTooManyRequests, "Too Many Concurrent Requests")
+			return
+		}
+		defer l.release(key)
+		w.Header().Set("X-Gateway-Load", l.loadLevel(inflight))
+		next.ServeHTTP(w, r)
+	})
 }
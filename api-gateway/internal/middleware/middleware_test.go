@@ -0,0 +1,347 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"my_app/api-gateway/internal/logging"
+)
+
+func TestConcurrencyLimitIsPerClient(t *testing.T) {
+	const max = 2
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := NewConcurrencyLimiter(max, 0.8).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hold") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+	}))
+	serve := func(remote string, hold bool) int {
+		req := httptest.NewRequest(http.MethodGet, "/agent", nil)
+		req.RemoteAddr = remote
+		if hold {
+			req.Header.Set("X-Hold", "1")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("192.0.2.1:1000", true)
+		}()
+		<-entered
+	}
+
+	if code := serve("192.0.2.1:1001", false); code != http.StatusTooManyRequests {
+		t.Fatalf("request %d from a busy client = %d, want 429", max+1, code)
+	}
+	if code := serve("192.0.2.2:1000", false); code != http.StatusOK {
+		t.Fatalf("request from another client = %d, want 200", code)
+	}
+
+	close(release)
+	wg.Wait()
+	if code := serve("192.0.2.1:1002", false); code != http.StatusOK {
+		t.Fatalf("request after the others finished = %d, want 200", code)
+	}
+}
+
+func TestLogSamplerKeepsErrorsAndSamplesSuccesses(t *testing.T) {
+	s := NewLogSampler(10, 42)
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		keep, rate, sampled := s.keep(http.StatusOK)
+		if rate != 10 || !sampled {
+			t.Fatalf("keep(200) = _, %d, %v; want rate 10, sampled", rate, sampled)
+		}
+		if keep {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("kept %d of 10000 successes, want about 1000", kept)
+	}
+	for i := 0; i < 100; i++ {
+		if keep, rate, sampled := s.keep(http.StatusBadGateway); !keep || rate != 1 || sampled {
+			t.Fatalf("keep(502) = %v, %d, %v; errors must always be logged", keep, rate, sampled)
+		}
+	}
+}
+
+func TestLogSamplerRateOneLogsEverything(t *testing.T) {
+	for _, s := range []*LogSampler{nil, NewLogSampler(1, 1), NewLogSampler(0, 1)} {
+		if keep, _, sampled := s.keep(http.StatusOK); !keep || sampled {
+			t.Errorf("sampler %+v dropped a request", s)
+		}
+	}
+}
+
+func TestMethodPolicyRejectsTraceAndConnect(t *testing.T) {
+	reached := false
+	h := MethodPolicyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	for _, method := range []string{http.MethodTrace, http.MethodConnect} {
+		reached = false
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/agent", nil))
+		if rec.Code != http.StatusMethodNotAllowed || reached {
+			t.Errorf("%s = %d, reached handler %v; want 405 before the handler", method, rec.Code, reached)
+		}
+		if allow := rec.Header().Get("Allow"); strings.Contains(allow, method) || !strings.Contains(allow, http.MethodGet) {
+			t.Errorf("%s: Allow = %q", method, allow)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/agent", nil))
+	if !reached {
+		t.Error("OPTIONS was rejected")
+	}
+}
+
+func TestRetryAfterSecondsFromLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		limiter *rate.Limiter
+		want    int
+	}{
+		{"half a request per second", rate.NewLimiter(0.5, 1), 2},
+		{"a tenth of a request per second", rate.NewLimiter(0.1, 1), 10},
+		{"zero rate", rate.NewLimiter(0, 1), int(maxRetryAfter.Seconds())},
+		{"unlimited", rate.NewLimiter(rate.Inf, 1), 1},
+		{"slower than the cap", rate.NewLimiter(rate.Every(2*time.Hour), 1), int(maxRetryAfter.Seconds())},
+	} {
+		tc.limiter.Allow() // spend the burst
+		if got := retryAfterSeconds(tc.limiter); got != tc.want {
+			t.Errorf("%s: retryAfterSeconds = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimitPageDependsOnClient(t *testing.T) {
+	h := NewRateLimiter(0.5, 1, 0).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/agent", nil))
+
+	for _, tc := range []struct {
+		accept, contentType, body string
+	}{
+		{"application/json", "application/json", "Too Many Requests"},
+		{"text/html,application/xhtml+xml", "text/html", "Please wait a moment"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/agent", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("Accept %s: %d with Retry-After %q, want 429 with 2", tc.accept, rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("Accept %s: Content-Type %q, want %s", tc.accept, ct, tc.contentType)
+		}
+		if !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("Accept %s: body %q, want %q", tc.accept, rec.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestMaxURLLengthRejectsLongURIs(t *testing.T) {
+	reached := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	for _, tc := range []struct {
+		max    int
+		uri    string
+		status int
+	}{
+		{32, "/agent?q=" + strings.Repeat("a", 23), http.StatusOK}, // exactly 32
+		{32, "/agent?q=" + strings.Repeat("a", 24), http.StatusRequestURITooLong},
+		{32, "/" + strings.Repeat("p", 40), http.StatusRequestURITooLong},
+		{0, "/" + strings.Repeat("p", 10000), http.StatusOK},
+	} {
+		reached = false
+		rec := httptest.NewRecorder()
+		MaxURLLengthMiddleware(next, tc.max).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.uri, nil))
+		if rec.Code != tc.status || reached != (tc.status == http.StatusOK) {
+			t.Errorf("max %d, %d-byte URI: %d, reached %v; want %d", tc.max, len(tc.uri), rec.Code, reached, tc.status)
+		}
+	}
+}
+
+func TestConcurrencyLimiterReportsLoad(t *testing.T) {
+	l := NewConcurrencyLimiter(10, 0.8)
+	for inflight, want := range map[int]string{1: "low", 3: "low", 4: "medium", 7: "medium", 8: "high", 10: "high"} {
+		if got := l.loadLevel(inflight); got != want {
+			t.Errorf("%d of 10 in flight: load %q, want %q", inflight, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewConcurrencyLimiter(1, 0.8).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent", nil))
+	if got := rec.Header().Get("X-Gateway-Load"); got != "high" {
+		t.Fatalf("X-Gateway-Load = %q at the cap, want high", got)
+	}
+}
+
+func TestLogTimestampFormats(t *testing.T) {
+	defer SetLogTimeFormat("rfc3339", true)
+	ts := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("CET", 3600))
+	for _, tc := range []struct {
+		format string
+		utc    bool
+		want   interface{}
+	}{
+		{"rfc3339", true, "2024-03-01T11:30:45Z"},
+		{"rfc3339", false, "2024-03-01T12:30:45+01:00"},
+		{"rfc3339nano", true, "2024-03-01T11:30:45.123456789Z"},
+		{"unixms", true, int64(1709292645123)},
+	} {
+		if err := SetLogTimeFormat(tc.format, tc.utc); err != nil {
+			t.Fatal(err)
+		}
+		if got := logTimestamp(ts); got != tc.want {
+			t.Errorf("%s (utc %v) = %v, want %v", tc.format, tc.utc, got, tc.want)
+		}
+	}
+
+	if err := SetLogTimeFormat("iso", true); err == nil || logTime.format != "unixms" {
+		t.Fatalf("unknown format: err %v, format now %q; want an error and no change", err, logTime.format)
+	}
+}
+
+func TestInstanceHeaderTagsEveryResponse(t *testing.T) {
+	h := InstanceHeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusNotFound)
+	}), "gw-2")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if got := rec.Header().Get("X-Gateway-Instance"); got != "gw-2" {
+		t.Fatalf("X-Gateway-Instance = %q, want gw-2 on errors too", got)
+	}
+}
+
+func TestRateLimiterEvictsIdleClients(t *testing.T) {
+	l := NewRateLimiter(1, 1, time.Minute)
+	defer l.Stop()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	if !l.getLimiter("10.0.0.1").Allow() {
+		t.Fatal("first request refused")
+	}
+	now = now.Add(50 * time.Second)
+	l.getLimiter("10.0.0.2")
+	now = now.Add(20 * time.Second)
+	l.evictIdle()
+
+	l.mu.Lock()
+	_, idle := l.ips["10.0.0.1"]
+	_, recent := l.ips["10.0.0.2"]
+	l.mu.Unlock()
+	if idle || !recent {
+		t.Fatalf("after eviction: idle client kept %v, recent client kept %v", idle, recent)
+	}
+	// A returning client starts again with a full burst
+	if !l.getLimiter("10.0.0.1").Allow() {
+		t.Fatal("evicted client came back without its burst")
+	}
+}
+
+func TestAccessLogCountsBytesWritten(t *testing.T) {
+	var out bytes.Buffer
+	if err := logging.Setup(&out, "info", "json", false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Setup(os.Stderr, "info", "text", false) })
+
+	const size = 1234
+	h := StructuredLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 1000))
+		w.Write(bytes.Repeat([]byte("y"), size-1000))
+	}), nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/agent", nil))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("access log %q: %v", out.String(), err)
+	}
+	if got := entry["bytes_written"]; got != float64(size) {
+		t.Fatalf("bytes_written = %v, want %d", got, size)
+	}
+	if _, dup := entry["bytes"]; dup {
+		t.Fatal(`access log has both "bytes" and "bytes_written"`)
+	}
+}
+
+func TestResponseControllerReachesUnderlyingWriter(t *testing.T) {
+	var flushErr error
+	h := StructuredLoggingMiddleware(RecoveryMiddleware(TimeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: 1\n\n")
+		flushErr = http.NewResponseController(w).Flush()
+	}), time.Minute, nil)), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent/stream", nil))
+	if flushErr != nil || !rec.Flushed {
+		t.Fatalf("Flush = %v, flushed %v; want it to reach the recorder", flushErr, rec.Flushed)
+	}
+}
+
+func TestAccessLogRecordsHijackAsSwitchingProtocols(t *testing.T) {
+	var out bytes.Buffer
+	if err := logging.Setup(&out, "info", "json", false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Setup(os.Stderr, "info", "text", false) })
+
+	logged := make(chan struct{})
+	h := StructuredLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+	}), nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		close(logged)
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+
+	<-logged
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("access log %q: %v", out.String(), err)
+	}
+	if entry["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Fatalf("logged status %v, want 101", entry["status"])
+	}
+}
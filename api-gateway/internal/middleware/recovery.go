@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/logging"
+)
+
+// --- Recovery Middleware ---
+
+// RecoveryMiddleware turns a handler panic into a 500 JSON error and logs
+// the panic with its stack in the structured log format. If the response
+// was already started it can't be replaced, so the connection is dropped.
+// http.ErrAbortHandler is passed through: it is how handlers abort a
+// response on purpose.
+func RecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &startedWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logEntry := map[string]interface{}{
+				"level":  "error",
+				"ts":     logTimestamp(time.Now()),
+				"msg":    "panic serving request",
+				"panic":  fmt.Sprint(v),
+				"stack":  string(debug.Stack()),
+				"method": r.Method,
+				"path":   r.URL.Path,
+				// RequestIDMiddleware runs inside us but sets the shared header
+				"request_id": r.Header.Get("X-Request-ID"),
+			}
+			jsonBytes, _ := json.Marshal(logEntry)
+			logging.JSON(slog.LevelError, jsonBytes)
+
+			if tw.started {
+				panic(http.ErrAbortHandler)
+			}
+			errpage.Write(w, r, http.StatusInternalServerError, "Internal Server Error")
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// startedWriter notes whether the response headers have been sent.
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startedWriter) Flush() {
+	w.started = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *startedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"my_app/api-gateway/internal/logging"
+)
+
+// captureLog sends log output to the returned buffer, as JSON lines, for
+// the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var out bytes.Buffer
+	if err := logging.Setup(&out, "info", "json", false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Setup(os.Stderr, "info", "text", false) })
+	return &out
+}
+
+// logEntries decodes every JSON line in out.
+func logEntries(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// servePanic serves a request through h and returns what it panicked with.
+func servePanic(h http.Handler, rec *httptest.ResponseRecorder, req *http.Request) (v interface{}) {
+	defer func() { v = recover() }()
+	h.ServeHTTP(rec, req)
+	return nil
+}
+
+func TestRecoveryAnswersPanicWith500(t *testing.T) {
+	out := captureLog(t)
+	h := RecoveryMiddleware(RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // nil map
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/api-docs/aggregate", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	rec := httptest.NewRecorder()
+	if v := servePanic(h, rec, req); v != nil {
+		t.Fatalf("panic %v escaped", v)
+	}
+
+	var body struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusInternalServerError || body.Status != http.StatusInternalServerError ||
+		!strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("got %d %s %q, want a 500 JSON error", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	entries := logEntries(t, out)
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want 1: %s", len(entries), out.String())
+	}
+	e := entries[0]
+	if e["level"] != "error" || e["request_id"] != "req-42" || e["path"] != "/api-docs/aggregate" ||
+		!strings.Contains(e["panic"].(string), "nil map") || e["stack"] == "" {
+		t.Fatalf("log entry = %v", e)
+	}
+}
+
+func TestRecoveryPassesAbortHandlerThrough(t *testing.T) {
+	out := captureLog(t)
+	h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	rec := httptest.NewRecorder()
+	if v := servePanic(h, rec, httptest.NewRequest(http.MethodGet, "/agent/stream", nil)); v != http.ErrAbortHandler {
+		t.Fatalf("panic = %v, want http.ErrAbortHandler", v)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("an intentional abort was logged: %s", out.String())
+	}
+}
+
+func TestRecoveryAbortsStartedResponse(t *testing.T) {
+	captureLog(t)
+	for name, start := range map[string]func(http.ResponseWriter){
+		"header": func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) },
+		"body":   func(w http.ResponseWriter) { io.WriteString(w, "partial") },
+	} {
+		h := RecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start(w)
+			panic("late")
+		}))
+		rec := httptest.NewRecorder()
+		if v := servePanic(h, rec, httptest.NewRequest(http.MethodGet, "/agent", nil)); v != http.ErrAbortHandler {
+			t.Errorf("%s written: panic = %v, want http.ErrAbortHandler", name, v)
+		}
+		if rec.Code == http.StatusInternalServerError || strings.Contains(rec.Body.String(), "Internal Server Error") {
+			t.Errorf("%s written: got a second status: %d %q", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAccessLogRecordsPanickingRequest(t *testing.T) {
+	out := captureLog(t)
+	h := RecoveryMiddleware(StructuredLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent", nil))
+
+	var access []map[string]interface{}
+	for _, e := range logEntries(t, out) {
+		if _, ok := e["status"]; ok {
+			access = append(access, e)
+		}
+	}
+	if len(access) != 1 || access[0]["status"] != float64(http.StatusInternalServerError) || access[0]["path"] != "/agent" {
+		t.Fatalf("access log entries = %v, want one for the 500", access)
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// serveRequestID sends a request carrying incoming as X-Request-ID (none
+// if empty) and returns the ID the handler saw and the response.
+func serveRequestID(t *testing.T, incoming string) (seen string, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r)
+		if fwd := r.Header.Get("X-Request-ID"); fwd != seen {
+			t.Errorf("request header %q, context %q; want the same ID forwarded", fwd, seen)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/agent", nil)
+	if incoming != "" {
+		req.Header.Set("X-Request-ID", incoming)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return seen, rec
+}
+
+func TestRequestIDGeneratedWhenMissing(t *testing.T) {
+	seen, rec := serveRequestID(t, "")
+	if !uuidPattern.MatchString(seen) {
+		t.Fatalf("generated ID %q is not a version 4 UUID", seen)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != seen {
+		t.Fatalf("response X-Request-ID = %q, want %q", got, seen)
+	}
+	if other, _ := serveRequestID(t, ""); other == seen {
+		t.Fatalf("two requests got the same ID %q", seen)
+	}
+}
+
+func TestRequestIDKeepsValidIncomingID(t *testing.T) {
+	for _, id := range []string{"abc-123", "trace:7f/span=9", strings.Repeat("x", maxRequestIDLen)} {
+		seen, rec := serveRequestID(t, id)
+		if seen != id || rec.Header().Get("X-Request-ID") != id {
+			t.Errorf("incoming %q: handler saw %q, response %q", id, seen, rec.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+func TestRequestIDReplacesInvalidIncomingID(t *testing.T) {
+	for name, id := range map[string]string{
+		"control character": "abc\x01def",
+		"tab":               "abc\tdef",
+		"space":             "abc def",
+		"non-ASCII":         "abc-é",
+		"too long":          strings.Repeat("x", maxRequestIDLen+1),
+	} {
+		seen, rec := serveRequestID(t, id)
+		if seen == id || !uuidPattern.MatchString(seen) {
+			t.Errorf("%s: handler saw %q, want a fresh UUID", name, seen)
+		}
+		if got := rec.Header().Get("X-Request-ID"); got != seen {
+			t.Errorf("%s: response X-Request-ID = %q, want %q", name, got, seen)
+		}
+	}
+}
+
+func TestAccessLogCarriesRequestID(t *testing.T) {
+	out := captureLog(t)
+	h := RequestIDMiddleware(StructuredLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil))
+	req := httptest.NewRequest(http.MethodGet, "/agent", nil)
+	req.Header.Set("X-Request-ID", "req-7")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logEntries(t, out)
+	if len(entries) != 1 || entries[0]["request_id"] != "req-7" {
+		t.Fatalf("access log = %v, want request_id req-7", entries)
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- SLO classification ---
+
+// StatusSet is a set of HTTP status codes, parsed from a comma-separated
+// list of codes ("404"), ranges ("200-299") and classes ("2xx").
+type StatusSet []statusRange
+
+type statusRange struct{ lo, hi int }
+
+// DefaultSLOSuccess counts 2xx and 3xx responses as successful.
+var DefaultSLOSuccess = StatusSet{{200, 399}}
+
+// ParseStatusSet parses a status list such as "2xx,3xx,404".
+func ParseStatusSet(s string) (StatusSet, error) {
+	var set StatusSet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		var lo, hi int
+		var err error
+		switch {
+		case len(item) == 3 && strings.HasSuffix(item, "xx"):
+			lo, err = strconv.Atoi(item[:1])
+			lo *= 100
+			hi = lo + 99
+		case strings.Contains(item, "-"):
+			a, b, _ := strings.Cut(item, "-")
+			if lo, err = strconv.Atoi(a); err == nil {
+				hi, err = strconv.Atoi(b)
+			}
+		default:
+			lo, err = strconv.Atoi(item)
+			hi = lo
+		}
+		if err != nil || lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("invalid status %q", item)
+		}
+		set = append(set, statusRange{lo, hi})
+	}
+	return set, nil
+}
+
+// Contains reports whether status is in the set.
+func (s StatusSet) Contains(status int) bool {
+	for _, r := range s {
+		if status >= r.lo && status <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSLOSuccess records the statuses that count as success for r's route,
+// reported as "slo_success" in the access log.
+func SetSLOSuccess(r *http.Request, success StatusSet) {
+	if h := logFieldsFrom(r); h != nil && success != nil {
+		h.mu.Lock()
+		h.success = success
+		h.mu.Unlock()
+	}
+}
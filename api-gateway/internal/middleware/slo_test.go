@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"my_app/api-gateway/internal/logging"
+)
+
+func TestParseStatusSet(t *testing.T) {
+	set, err := ParseStatusSet(" 2XX, 404 ,500-502,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for status, want := range map[int]bool{200: true, 299: true, 302: false, 404: true, 403: false, 501: true, 503: false} {
+		if got := set.Contains(status); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", status, got, want)
+		}
+	}
+	for _, bad := range []string{"2x", "abc", "600", "99", "500-400", "0xx"} {
+		if _, err := ParseStatusSet(bad); err == nil {
+			t.Errorf("ParseStatusSet(%q) succeeded", bad)
+		}
+	}
+}
+
+// loggedEntry runs h behind StructuredLoggingMiddleware and decodes the
+// access log line it wrote.
+func loggedEntry(t *testing.T, h http.HandlerFunc) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := logging.Setup(&buf, "info", "json", true); err != nil {
+		t.Fatal(err)
+	}
+	defer logging.Setup(os.Stderr, "info", "text", true)
+	StructuredLoggingMiddleware(h, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/agent", nil))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &entry); err != nil {
+		t.Fatalf("access log %q: %v", buf.String(), err)
+	}
+	return entry
+}
+
+func TestAccessLogReportsSLOSuccess(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }
+	if got := loggedEntry(t, notFound)["slo_success"]; got != false {
+		t.Errorf("404 with the default set: slo_success = %v", got)
+	}
+
+	// The matched route widens its success set, as RouteRegistry does
+	routed := func(w http.ResponseWriter, r *http.Request) {
+		set, _ := ParseStatusSet("2xx,404")
+		SetSLOSuccess(r, set)
+		notFound(w, r)
+	}
+	if got := loggedEntry(t, routed)["slo_success"]; got != true {
+		t.Errorf("404 on a route accepting 404: slo_success = %v", got)
+	}
+}
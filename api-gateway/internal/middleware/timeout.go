@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/errpage"
+)
+
+// --- Timeout Middleware ---
+
+// TimeoutMiddleware gives every request a deadline of d. Responses are
+// passed straight through (and can be flushed), so nothing is buffered;
+// a handler that hasn't written anything by the deadline is answered with
+// 504 Gateway Timeout and whatever it writes afterwards is discarded. Once
+// a response has started, the deadline only cancels the request context.
+// Paths listed in exempt (exact matches, e.g. streaming endpoints) and
+// protocol upgrades such as WebSockets have no deadline. d <= 0 disables
+// it.
+func TimeoutMiddleware(next http.Handler, d time.Duration, exempt []string) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	skip := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		skip[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{w: w, ctx: ctx, header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- v
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case v := <-panicked:
+			// Re-raised here so RecoveryMiddleware sees it
+			panic(v)
+		case <-done:
+			// The handler may have woken on the deadline before we did and
+			// returned having written nothing that was let through
+			tw.mu.Lock()
+			late := !tw.wroteHeader && ctx.Err() == context.DeadlineExceeded
+			tw.timedOut = tw.timedOut || late
+			tw.mu.Unlock()
+			if late {
+				errpage.Write(w, r, http.StatusGatewayTimeout, "Gateway Timeout")
+			}
+		case <-ctx.Done():
+			tw.mu.Lock()
+			if tw.wroteHeader {
+				// The response is under way and still owns w; the cancelled
+				// context ends it
+				tw.mu.Unlock()
+				select {
+				case v := <-panicked:
+					panic(v)
+				case <-done:
+				}
+				return
+			}
+			tw.timedOut = true
+			tw.mu.Unlock()
+			if ctx.Err() == context.DeadlineExceeded {
+				errpage.Write(w, r, http.StatusGatewayTimeout, "Gateway Timeout")
+			}
+		}
+	})
+}
+
+// timeoutWriter passes a response through to w until the deadline passes
+// with nothing written. Headers are kept apart until the response starts so
+// a late handler never touches w's header map. A response that hasn't
+// started by the time ctx ends is refused by the writer itself, so a handler
+// that notices the deadline first can't race the 504.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	ctx         context.Context
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(code)
+}
+
+func (w *timeoutWriter) writeHeaderLocked(code int) {
+	if !w.wroteHeader && w.ctx.Err() != nil {
+		w.timedOut = true
+	}
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	dst := w.w.Header()
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	w.w.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(http.StatusOK)
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.w.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeaderLocked(http.StatusOK)
+	if w.timedOut {
+		return
+	}
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
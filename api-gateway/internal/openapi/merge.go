@@ -0,0 +1,137 @@
+package openapi
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Source is one service's OpenAPI document to merge.
+type Source struct {
+	Name   string                 // service name, used to prefix components
+	Prefix string                 // path prefix, e.g. "/agent-service" ("" keeps paths as-is)
+	Spec   map[string]interface{} // decoded OpenAPI 3 (or Swagger 2) document
+}
+
+// componentSections are the OpenAPI 3 component maps that get namespaced.
+var componentSections = []string{
+	"schemas", "responses", "parameters", "examples", "requestBodies",
+	"headers", "securitySchemes", "links", "callbacks",
+}
+
+var invalidComponentChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// componentPrefix returns the name prefix used for a service's components.
+func componentPrefix(name string) string {
+	return invalidComponentChars.ReplaceAllString(name, "_") + "_"
+}
+
+// Merge combines sources into a single OpenAPI 3 document. Paths are
+// namespaced by each source's Prefix, components are renamed to
+// "<service>_<name>" to avoid collisions (Swagger 2 definitions become
+// component schemas), every $ref is rewritten to match, and tags are
+// de-duplicated by name.
+func Merge(info map[string]interface{}, sources []Source) map[string]interface{} {
+	paths := map[string]interface{}{}
+	components := map[string]interface{}{}
+	var tags []interface{}
+	seenTags := map[string]bool{}
+
+	for _, src := range sources {
+		cp := componentPrefix(src.Name)
+		rewrite := func(v interface{}) interface{} { return rewriteRefs(v, cp) }
+
+		if p, ok := src.Spec["paths"].(map[string]interface{}); ok {
+			for path, item := range p {
+				paths[strings.TrimRight(src.Prefix, "/")+path] = rewrite(item)
+			}
+		}
+
+		if comps, ok := src.Spec["components"].(map[string]interface{}); ok {
+			for _, section := range componentSections {
+				if entries, ok := comps[section].(map[string]interface{}); ok {
+					addComponents(components, section, cp, entries, rewrite)
+				}
+			}
+		}
+		if defs, ok := src.Spec["definitions"].(map[string]interface{}); ok {
+			addComponents(components, "schemas", cp, defs, rewrite)
+		}
+
+		if t, ok := src.Spec["tags"].([]interface{}); ok {
+			for _, tag := range t {
+				m, ok := tag.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := m["name"].(string)
+				if name == "" || seenTags[name] {
+					continue
+				}
+				seenTags[name] = true
+				tags = append(tags, m)
+			}
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	if len(tags) > 0 {
+		doc["tags"] = tags
+	}
+	return doc
+}
+
+func addComponents(components map[string]interface{}, section, prefix string, entries map[string]interface{}, rewrite func(interface{}) interface{}) {
+	dst, ok := components[section].(map[string]interface{})
+	if !ok {
+		dst = map[string]interface{}{}
+		components[section] = dst
+	}
+	for name, v := range entries {
+		dst[prefix+name] = rewrite(v)
+	}
+}
+
+// rewriteRefs returns a deep copy of v with local $refs pointing at the
+// prefixed component names.
+func rewriteRefs(v interface{}, prefix string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if s, ok := val.(string); ok && k == "$ref" {
+				out[k] = rewriteRef(s, prefix)
+				continue
+			}
+			out[k] = rewriteRefs(val, prefix)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = rewriteRefs(val, prefix)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func rewriteRef(ref, prefix string) string {
+	if name, ok := strings.CutPrefix(ref, "#/definitions/"); ok {
+		return "#/components/schemas/" + prefix + name
+	}
+	for _, section := range componentSections {
+		base := "#/components/" + section + "/"
+		if name, ok := strings.CutPrefix(ref, base); ok {
+			return base + prefix + name
+		}
+	}
+	return ref
+}
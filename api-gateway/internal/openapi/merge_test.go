@@ -0,0 +1,87 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, doc string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// lookup walks doc along keys.
+func lookup(doc interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = m[k]
+	}
+	return doc
+}
+
+func TestMergeNamespacesPathsAndComponents(t *testing.T) {
+	agent := decode(t, `{
+		"paths": {"/items": {"get": {"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}}}}}},
+		"components": {"schemas": {"Item": {"type": "object"}}},
+		"tags": [{"name": "items"}]
+	}`)
+	users := decode(t, `{
+		"paths": {"/items": {"get": {"responses": {"200": {"schema": {"$ref": "#/definitions/Item"}}}}}},
+		"definitions": {"Item": {"type": "string"}},
+		"tags": [{"name": "items"}, {"name": "users"}]
+	}`)
+
+	doc := Merge(map[string]interface{}{"title": "all"}, []Source{
+		{Name: "agent-service", Prefix: "/agent-service/", Spec: agent},
+		{Name: "user service", Prefix: "/users", Spec: users},
+	})
+
+	if doc["openapi"] != "3.0.0" || lookup(doc, "info", "title") != "all" {
+		t.Fatalf("header = %v, %v", doc["openapi"], doc["info"])
+	}
+	agentRef := lookup(doc, "paths", "/agent-service/items", "get", "responses", "200", "content", "application/json", "schema", "$ref")
+	if agentRef != "#/components/schemas/agent-service_Item" {
+		t.Errorf("agent $ref = %v", agentRef)
+	}
+	// Swagger 2 definitions become prefixed component schemas
+	usersRef := lookup(doc, "paths", "/users/items", "get", "responses", "200", "schema", "$ref")
+	if usersRef != "#/components/schemas/user_service_Item" {
+		t.Errorf("users $ref = %v", usersRef)
+	}
+	for _, name := range []string{"agent-service_Item", "user_service_Item"} {
+		if lookup(doc, "components", "schemas", name) == nil {
+			t.Errorf("component %s missing", name)
+		}
+	}
+
+	tags, _ := doc["tags"].([]interface{})
+	if len(tags) != 2 {
+		t.Errorf("tags = %v, want items and users once each", tags)
+	}
+}
+
+func TestMergeDoesNotModifySources(t *testing.T) {
+	spec := decode(t, `{"paths": {"/x": {"$ref": "#/components/schemas/X"}}, "components": {"schemas": {"X": {}}}}`)
+	Merge(nil, []Source{{Name: "svc", Spec: spec}})
+	if ref := lookup(spec, "paths", "/x", "$ref"); ref != "#/components/schemas/X" {
+		t.Fatalf("source $ref rewritten to %v", ref)
+	}
+}
+
+func TestMergeKeepsExternalRefs(t *testing.T) {
+	spec := decode(t, `{"paths": {"/x": {"$ref": "https://example.com/common.json#/X"}}}`)
+	doc := Merge(nil, []Source{{Name: "svc", Prefix: "/svc", Spec: spec}})
+	if ref := lookup(doc, "paths", "/svc/x", "$ref"); ref != "https://example.com/common.json#/X" {
+		t.Fatalf("external $ref = %v", ref)
+	}
+	if _, ok := doc["components"]; ok {
+		t.Fatal("components added for a spec without any")
+	}
+}
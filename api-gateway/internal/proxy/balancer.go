@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer orders the base URLs of an app's UP instances for one request;
+// the caller uses the first one that is usable. Implementations must not
+// modify bases.
+type Balancer interface {
+	Order(ctx context.Context, appName string, bases []string) []string
+}
+
+// NewBalancer returns the Balancer for strategy: "random", "least_conn",
+// "affinity" or, for anything else, "round_robin". inflight reports the
+// requests currently in flight to a host and is only used by least_conn.
+func NewBalancer(strategy string, inflight func(host string) int) Balancer {
+	switch strategy {
+	case "random":
+		return randomBalancer{}
+	case "least_conn":
+		return &leastConnBalancer{inflight: inflight}
+	case "affinity":
+		return &affinityBalancer{}
+	}
+	return &roundRobinBalancer{}
+}
+
+type affinityKey struct{}
+
+// WithAffinityKey attaches the session affinity key of a request to ctx.
+func WithAffinityKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityKey{}, key)
+}
+
+func affinityKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(affinityKey{}).(string)
+	return key
+}
+
+// roundRobinBalancer rotates each app's instances by one per call.
+type roundRobinBalancer struct {
+	counters sync.Map // upper-cased app name -> *atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Order(_ context.Context, appName string, bases []string) []string {
+	if len(bases) == 0 {
+		return nil
+	}
+	c, _ := b.counters.LoadOrStore(strings.ToUpper(appName), new(atomic.Uint64))
+	n := int((c.(*atomic.Uint64).Add(1) - 1) % uint64(len(bases)))
+	out := make([]string, 0, len(bases))
+	out = append(out, bases[n:]...)
+	return append(out, bases[:n]...)
+}
+
+// randomBalancer shuffles the instances uniformly.
+type randomBalancer struct{}
+
+func (randomBalancer) Order(_ context.Context, _ string, bases []string) []string {
+	out := append([]string(nil), bases...)
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// leastConnBalancer puts the instances with the fewest requests in flight
+// first; ties are broken round-robin so idle instances share the load.
+type leastConnBalancer struct {
+	roundRobinBalancer
+	inflight func(host string) int
+}
+
+func (b *leastConnBalancer) Order(ctx context.Context, appName string, bases []string) []string {
+	out := b.roundRobinBalancer.Order(ctx, appName, bases)
+	counts := make(map[string]int, len(out))
+	for _, base := range out {
+		counts[base] = b.inflight(hostOf(base))
+	}
+	sort.SliceStable(out, func(i, j int) bool { return counts[out[i]] < counts[out[j]] })
+	return out
+}
+
+// affinityBalancer sends every request with the same affinity key to the
+// same instance using rendezvous hashing: instances are ranked by a hash of
+// key and instance, so adding or removing one only moves the keys that
+// rank it first. Requests without a key are balanced round-robin.
+type affinityBalancer struct {
+	roundRobinBalancer
+}
+
+func (b *affinityBalancer) Order(ctx context.Context, appName string, bases []string) []string {
+	key := affinityKeyFrom(ctx)
+	if key == "" {
+		return b.roundRobinBalancer.Order(ctx, appName, bases)
+	}
+	out := append([]string(nil), bases...)
+	scores := make(map[string]uint64, len(out))
+	for _, base := range out {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		// By host, so the ranking survives changes to the URL's form
+		h.Write([]byte(hostOf(base)))
+		scores[base] = mix64(h.Sum64())
+	}
+	sort.SliceStable(out, func(i, j int) bool { return scores[out[i]] > scores[out[j]] })
+	return out
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone leaves similar inputs with
+// similar high bits, which skews the ranking.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func hostOf(base string) string {
+	if u, err := url.Parse(base); err == nil {
+		return u.Host
+	}
+	return base
+}
+
+// Inflight returns how many requests to host are currently in flight,
+// counting a response until its body is closed.
+func (p *Client) Inflight(host string) int {
+	if c, ok := p.inflight.Load(host); ok {
+		return int(c.(*atomic.Int64).Load())
+	}
+	return 0
+}
+
+// track counts one more request in flight to host; the caller decrements
+// the returned counter when it ends.
+func (p *Client) track(host string) *atomic.Int64 {
+	c, _ := p.inflight.LoadOrStore(host, new(atomic.Int64))
+	n := c.(*atomic.Int64)
+	n.Add(1)
+	return n
+}
+
+// do sends req with c, counting it in flight against its host.
+func (p *Client) do(c *http.Client, req *http.Request) (*http.Response, error) {
+	n := p.track(req.URL.Host)
+	resp, err := c.Do(req)
+	if err != nil {
+		n.Add(-1)
+		return nil, err
+	}
+	resp.Body = &inflightBody{ReadCloser: resp.Body, n: n}
+	return resp, nil
+}
+
+// inflightBody ends its request's in-flight count when closed.
+type inflightBody struct {
+	io.ReadCloser
+	n    *atomic.Int64
+	once sync.Once
+}
+
+func (b *inflightBody) Close() error {
+	b.once.Do(func() { b.n.Add(-1) })
+	return b.ReadCloser.Close()
+}
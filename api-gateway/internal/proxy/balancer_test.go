@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var bases = []string{"http://10.0.0.1:8000", "http://10.0.0.2:8000", "http://10.0.0.3:8000"}
+
+func TestRoundRobinRotatesPerApp(t *testing.T) {
+	b := NewBalancer("round_robin", nil)
+	for i, want := range []string{bases[0], bases[1], bases[2], bases[0]} {
+		if got := b.Order(context.Background(), "agent", bases); got[0] != want || len(got) != len(bases) {
+			t.Fatalf("call %d: Order = %v, want %s first", i, got, want)
+		}
+	}
+	// Each app keeps its own position; app names are case-insensitive
+	if got := b.Order(context.Background(), "users", bases); got[0] != bases[0] {
+		t.Fatalf("first users call = %v", got)
+	}
+	if got := b.Order(context.Background(), "AGENT", bases); got[0] != bases[1] {
+		t.Fatalf("AGENT after four agent calls = %v", got)
+	}
+}
+
+func TestRoundRobinIsEvenUnderConcurrency(t *testing.T) {
+	b := NewBalancer("round_robin", nil)
+	const workers, calls = 8, 300 // a multiple of len(bases)
+	var mu sync.Mutex
+	first := map[string]int{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				got := b.Order(context.Background(), "agent", bases)
+				mu.Lock()
+				first[got[0]]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, base := range bases {
+		if first[base] != workers*calls/len(bases) {
+			t.Fatalf("first choices = %v, want an even split of %d calls", first, workers*calls)
+		}
+	}
+}
+
+func TestLeastConnPrefersIdleInstances(t *testing.T) {
+	inflight := map[string]int{"10.0.0.1:8000": 3, "10.0.0.2:8000": 0, "10.0.0.3:8000": 1}
+	b := NewBalancer("least_conn", func(host string) int { return inflight[host] })
+	want := []string{bases[1], bases[2], bases[0]}
+	if got := b.Order(context.Background(), "agent", bases); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Order = %v, want %v", got, want)
+	}
+
+	// Ties are spread round-robin
+	inflight = map[string]int{}
+	first := map[string]bool{}
+	for range bases {
+		first[b.Order(context.Background(), "agent", bases)[0]] = true
+	}
+	if len(first) != len(bases) {
+		t.Fatalf("idle instances led %v, want each in turn", first)
+	}
+}
+
+func TestRandomKeepsEveryInstance(t *testing.T) {
+	got := NewBalancer("random", nil).Order(context.Background(), "agent", bases)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, bases) {
+		t.Fatalf("Order = %v, want a permutation of %v", got, bases)
+	}
+	if bases[0] != "http://10.0.0.1:8000" {
+		t.Fatal("Order modified its input")
+	}
+}
+
+// instances returns n base URLs.
+func instances(n int) []string {
+	bases := make([]string, n)
+	for i := range bases {
+		bases[i] = fmt.Sprintf("http://10.0.0.%d:8080", i+1)
+	}
+	return bases
+}
+
+// firstChoices maps each of keys to the instance the balancer picks first.
+func firstChoices(b Balancer, keys int, bases []string) map[string]string {
+	out := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		out[key] = b.Order(WithAffinityKey(context.Background(), key), "AGENT", bases)[0]
+	}
+	return out
+}
+
+func TestAffinityIsStable(t *testing.T) {
+	b := NewBalancer("affinity", nil)
+	bases := instances(5)
+	reversed := make([]string, len(bases))
+	for i, base := range bases {
+		reversed[len(bases)-1-i] = base
+	}
+	first, again := firstChoices(b, 200, bases), firstChoices(b, 200, reversed)
+	for key, base := range first {
+		if again[key] != base {
+			t.Fatalf("%s moved from %s to %s when the listing order changed", key, base, again[key])
+		}
+	}
+}
+
+func TestAffinityRemovingInstanceOnlyMovesItsKeys(t *testing.T) {
+	b := NewBalancer("affinity", nil)
+	bases := instances(5)
+	removed := bases[2]
+	before := firstChoices(b, 500, bases)
+	after := firstChoices(b, 500, append(append([]string(nil), bases[:2]...), bases[3:]...))
+	for key, base := range before {
+		if base != removed && after[key] != base {
+			t.Fatalf("%s moved from %s to %s though %s was removed", key, base, after[key], removed)
+		}
+	}
+}
+
+func TestAffinityAddingInstanceOnlyMovesKeysToIt(t *testing.T) {
+	b := NewBalancer("affinity", nil)
+	bases := instances(6)
+	added := bases[5]
+	before := firstChoices(b, 500, bases[:5])
+	after := firstChoices(b, 500, bases)
+	moved := 0
+	for key, base := range before {
+		if after[key] == base {
+			continue
+		}
+		if after[key] != added {
+			t.Fatalf("%s moved from %s to %s, not to the new instance", key, base, after[key])
+		}
+		moved++
+	}
+	// About a sixth of the keys should move; all or none means no spread
+	if moved == 0 || moved > 250 {
+		t.Fatalf("%d of 500 keys moved to the new instance", moved)
+	}
+}
+
+func TestAffinityWithoutKeyRotates(t *testing.T) {
+	b := NewBalancer("affinity", nil)
+	bases := instances(3)
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[b.Order(context.Background(), "AGENT", bases)[0]] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("requests without a key went to %d instances, want 3", len(seen))
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestReadyToTripConsecutive(t *testing.T) {
+	trip := BreakerConfig{Strategy: "consecutive", ConsecutiveFailures: 3}.ReadyToTrip()
+	if trip(gobreaker.Counts{Requests: 10, TotalFailures: 8, ConsecutiveFailures: 2}) {
+		t.Error("tripped after 2 consecutive failures, want 3")
+	}
+	if !trip(gobreaker.Counts{Requests: 3, TotalFailures: 3, ConsecutiveFailures: 3}) {
+		t.Error("did not trip after 3 consecutive failures")
+	}
+}
+
+func TestReadyToTripRatio(t *testing.T) {
+	trip := BreakerConfig{Strategy: "ratio", FailureRatio: 0.5, MinRequests: 4}.ReadyToTrip()
+	for _, tc := range []struct {
+		name                string
+		successes, failures uint32
+		want                bool
+	}{
+		{"below min requests", 0, 3, false},
+		{"at ratio", 2, 2, false},
+		{"above ratio", 1, 3, true},
+		{"spread out, no consecutive run", 4, 6, true},
+	} {
+		counts := gobreaker.Counts{
+			Requests:       tc.successes + tc.failures,
+			TotalSuccesses: tc.successes,
+			TotalFailures:  tc.failures,
+		}
+		if got := trip(counts); got != tc.want {
+			t.Errorf("%s: trip(%+v) = %v, want %v", tc.name, counts, got, tc.want)
+		}
+	}
+}
+
+func TestBreakerOpensAndFailsFast(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	p := New(&http.Client{}, Options{Breaker: BreakerConfig{
+		Interval:            time.Minute,
+		Timeout:             time.Minute,
+		Strategy:            "consecutive",
+		ConsecutiveFailures: 2,
+	}})
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		p.ProxyJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.MethodGet, srv.URL, nil)
+		if i == 2 && rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("call %d = %d, want 503 from the open breaker", i+1, rec.Code)
+		}
+	}
+	if p.State() != gobreaker.StateOpen {
+		t.Fatalf("state = %v, want open", p.State())
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("upstream saw %d requests, want 2", n)
+	}
+}
+
+// newBreakerClient returns a Client whose breaker opens after 2 consecutive
+// failures and stays open for openFor.
+func newBreakerClient(openFor time.Duration) *Client {
+	return New(&http.Client{}, Options{Breaker: BreakerConfig{
+		Timeout:             openFor,
+		Strategy:            "consecutive",
+		ConsecutiveFailures: 2,
+	}})
+}
+
+// upstream answers 500, or hangs until the request is cancelled when the
+// path is /hang.
+func upstream(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hang" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call sends GET path through p, cancelling the request after cancelAfter
+// when it is positive.
+func call(t *testing.T, p *Client, base, path string, cancelAfter time.Duration) error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cancelAfter > 0 {
+		time.AfterFunc(cancelAfter, cancel)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestCancelledCallIsNotCounted(t *testing.T) {
+	srv := upstream(t)
+	p := newBreakerClient(time.Minute)
+
+	if err := call(t, p, srv.URL, "/hang", 20*time.Millisecond); err == nil {
+		t.Fatal("cancelled call succeeded")
+	}
+	if c := p.Counts(); c.TotalFailures != 0 || c.TotalSuccesses != 0 {
+		t.Fatalf("cancelled call was counted: %+v", c)
+	}
+	if s := p.State(); s != gobreaker.StateClosed {
+		t.Fatalf("state = %v, want closed", s)
+	}
+}
+
+func TestCancelledCallDoesNotResetFailures(t *testing.T) {
+	srv := upstream(t)
+	p := newBreakerClient(time.Minute)
+
+	call(t, p, srv.URL, "/fail", 0)
+	call(t, p, srv.URL, "/hang", 20*time.Millisecond)
+	if c := p.Counts(); c.ConsecutiveFailures != 1 {
+		t.Fatalf("consecutive failures = %d after a cancellation, want 1", c.ConsecutiveFailures)
+	}
+	call(t, p, srv.URL, "/fail", 0)
+	if s := p.State(); s != gobreaker.StateOpen {
+		t.Fatalf("state = %v, want open", s)
+	}
+}
+
+func TestCancelledHalfOpenProbeReopens(t *testing.T) {
+	srv := upstream(t)
+	p := newBreakerClient(30 * time.Millisecond)
+
+	call(t, p, srv.URL, "/fail", 0)
+	call(t, p, srv.URL, "/fail", 0)
+	time.Sleep(50 * time.Millisecond)
+	if s := p.State(); s != gobreaker.StateHalfOpen {
+		t.Fatalf("state = %v, want half-open", s)
+	}
+	call(t, p, srv.URL, "/hang", 20*time.Millisecond)
+	if s := p.State(); s != gobreaker.StateOpen {
+		t.Fatalf("state after cancelled probe = %v, want open", s)
+	}
+}
+
+func TestTripFailsFastUntilReset(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+	p := newBreakerClient(time.Minute)
+
+	p.Trip()
+	if s := p.State(); s != gobreaker.StateOpen {
+		t.Fatalf("state after Trip = %v, want open", s)
+	}
+	if err := call(t, p, srv.URL, "/", 0); err != gobreaker.ErrOpenState {
+		t.Fatalf("call while tripped = %v, want ErrOpenState", err)
+	}
+	rec := httptest.NewRecorder()
+	p.ProxyStream(rec, httptest.NewRequest(http.MethodGet, "/agent/stream", nil), http.MethodGet, srv.URL, nil)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("stream while tripped = %d, Retry-After %q, want 503 and 60", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("upstream saw %d requests while tripped", n)
+	}
+
+	p.Reset()
+	if s := p.State(); s != gobreaker.StateClosed {
+		t.Fatalf("state after Reset = %v, want closed", s)
+	}
+	if err := call(t, p, srv.URL, "/", 0); err != nil {
+		t.Fatalf("call after Reset = %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("upstream saw %d requests after Reset, want 1", n)
+	}
+
+	changes, last := p.StateChanges()
+	if changes["closed->open"] != 1 || changes["open->closed"] != 1 {
+		t.Fatalf("state changes = %v", changes)
+	}
+	if last.From != "open" || last.To != "closed" || last.At.IsZero() {
+		t.Fatalf("last change = %+v, want open->closed", last)
+	}
+}
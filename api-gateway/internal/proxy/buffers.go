@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultCopyBufferSize matches io.Copy's internal buffer.
+const defaultCopyBufferSize = 32 * 1024
+
+// bufferPool hands out reusable copy buffers of a fixed size so relaying
+// large responses doesn't allocate a fresh buffer per request.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+	// inUse counts buffers handed out and not yet returned.
+	inUse atomic.Int64
+}
+
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	bp := &bufferPool{size: size}
+	bp.pool.New = func() interface{} {
+		b := make([]byte, bp.size)
+		return &b
+	}
+	return bp
+}
+
+func (bp *bufferPool) get() *[]byte {
+	bp.inUse.Add(1)
+	return bp.pool.Get().(*[]byte)
+}
+
+func (bp *bufferPool) put(b *[]byte) {
+	bp.inUse.Add(-1)
+	bp.pool.Put(b)
+}
+
+// writerOnly hides any io.ReaderFrom on the wrapped writer so that
+// io.CopyBuffer actually uses the pooled buffer.
+type writerOnly struct {
+	io.Writer
+}
+
+// copyBuffered copies src to dst using a pooled buffer.
+func (p *Client) copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := p.buffers.get()
+	defer p.buffers.put(buf)
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"syscall"
+)
+
+// FailoverFunc marks the instance at failed as unusable and returns failed
+// moved to another instance of the same upstream, if there is one: the
+// same path below that instance's base URL, and the same query. It is
+// called after a refused connection and before each retry.
+type FailoverFunc func(ctx context.Context, failed *url.URL) (next string, ok bool)
+
+// SetFailover installs the function asked for another instance after a
+// refused connection or before a retry. It must be called before the
+// Client is used.
+func (p *Client) SetFailover(fn FailoverFunc) {
+	p.failover = fn
+}
+
+// isConnRefused reports whether err means nothing is listening at the
+// upstream address any more (the instance is gone), as opposed to a
+// transient failure worth retrying in place.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH)
+}
+
+// failoverTo points req at another instance and rewinds its body.
+// It reports false when no other instance is available or req's body
+// cannot be replayed.
+func (p *Client) failoverTo(req *http.Request) bool {
+	if p.failover == nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	moved, ok := p.failover(req.Context(), req.URL)
+	if !ok {
+		return false
+	}
+	next, err := url.Parse(moved)
+	if err != nil || p.guard.CheckURL(next) != nil {
+		return false
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		req.Body = body
+	}
+	req.URL = next
+	req.Host = ""
+	return true
+}
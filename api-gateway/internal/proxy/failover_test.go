@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// refusedURL returns the address of a server that has shut down, so
+// connecting to it is refused.
+func refusedURL(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+// tolerantBreaker stays closed through the failures these tests provoke.
+var tolerantBreaker = BreakerConfig{Timeout: time.Minute, ConsecutiveFailures: 10}
+
+// failoverClient fails over at most max times, always to the instance at
+// base URL next.
+func failoverClient(max int, next string, asked *[]string) *Client {
+	p := New(&http.Client{}, Options{MaxFailovers: max, Breaker: tolerantBreaker})
+	p.SetFailover(func(_ context.Context, failed *url.URL) (string, bool) {
+		*asked = append(*asked, failed.Host)
+		return next + failed.RequestURI(), true
+	})
+	return p
+}
+
+func TestDoFailsOverAfterRefusedConnection(t *testing.T) {
+	var uri, body string
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		uri, body = r.RequestURI, string(b)
+	}))
+	defer live.Close()
+	dead := refusedURL(t)
+
+	var asked []string
+	// POST is not retried in place, but a refused connection never reached
+	// the upstream, so moving it to another instance is safe
+	req, _ := http.NewRequest(http.MethodPost, dead+"/agent/run?n=1", strings.NewReader("payload"))
+	resp, err := failoverClient(1, live.URL, &asked).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || uri != "/agent/run?n=1" || body != "payload" {
+		t.Fatalf("status %d, live instance got %s %q; want the request replayed there", resp.StatusCode, uri, body)
+	}
+	if deadURL, _ := url.Parse(dead); len(asked) != 1 || asked[0] != deadURL.Host {
+		t.Fatalf("failover asked about %v, want only %s", asked, deadURL.Host)
+	}
+}
+
+func TestDoStopsAfterMaxFailovers(t *testing.T) {
+	dead := refusedURL(t)
+	for _, max := range []int{0, 2} {
+		var asked []string
+		_, err := doRequest(t, failoverClient(max, dead, &asked), http.MethodGet, dead)
+		if !isConnRefused(err) || len(asked) != max {
+			t.Errorf("MaxFailovers %d: err %v after %d failovers", max, err, len(asked))
+		}
+	}
+}
+
+func TestFailoverRefusesDisallowedHost(t *testing.T) {
+	p := New(&http.Client{}, Options{MaxFailovers: 1, Breaker: tolerantBreaker, Guard: NewHostGuard([]string{"127.0.0.1"})})
+	p.SetFailover(func(context.Context, *url.URL) (string, bool) { return "http://169.254.169.254", true })
+	if _, err := doRequest(t, p, http.MethodGet, refusedURL(t)); !isConnRefused(err) {
+		t.Fatalf("err = %v, want the refused connection rather than a metadata request", err)
+	}
+}
+
+func TestUpstreamHeaderNamesInstanceThatAnswered(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer live.Close()
+	liveURL, _ := url.Parse(live.URL)
+
+	for _, enabled := range []bool{false, true} {
+		p := New(&http.Client{}, Options{MaxFailovers: 1, Breaker: tolerantBreaker, UpstreamHeader: enabled})
+		p.SetFailover(func(context.Context, *url.URL) (string, bool) { return live.URL, true })
+		rec := httptest.NewRecorder()
+		p.ProxyJSON(rec, httptest.NewRequest(http.MethodGet, "/agent", nil), http.MethodGet, refusedURL(t)+"/agent", nil)
+
+		want := ""
+		if enabled {
+			want = liveURL.Host
+		}
+		if got := rec.Header().Get("X-Gateway-Upstream"); rec.Code != http.StatusOK || got != want {
+			t.Errorf("UpstreamHeader %v: status %d, X-Gateway-Upstream %q; want %q", enabled, rec.Code, got, want)
+		}
+	}
+}
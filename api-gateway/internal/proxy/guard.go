@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrHostNotAllowed is returned when an upstream host fails the SSRF guard.
+var ErrHostNotAllowed = errors.New("upstream host not allowed")
+
+// metadataIPs are cloud metadata endpoints outside the link-local ranges.
+var metadataIPs = []net.IP{
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+	net.ParseIP("fd00:ec2::254"),   // AWS IMDS over IPv6
+}
+
+// HostGuard restricts which upstream hosts the proxy may contact.
+//
+// Entries are host names ("agent-service"), wildcard suffixes
+// ("*.svc.cluster.local"), IPs or CIDRs ("10.0.0.0/8"). An empty allowlist
+// permits any host. Link-local and cloud metadata addresses (such as
+// 169.254.169.254) are always refused, both in URLs and after DNS
+// resolution at dial time.
+//
+// To defeat DNS rebinding, an allowed name must also resolve somewhere
+// allowed: into one of the listed IPs/CIDRs when there are any, and
+// otherwise anywhere but loopback.
+type HostGuard struct {
+	names    map[string]bool
+	suffixes []string
+	nets     []*net.IPNet
+}
+
+// NewHostGuard builds a guard from allowlist entries.
+func NewHostGuard(entries []string) *HostGuard {
+	g := &HostGuard{names: make(map[string]bool)}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case strings.HasPrefix(e, "*."):
+			g.suffixes = append(g.suffixes, e[1:])
+		case strings.Contains(e, "/"):
+			if _, n, err := net.ParseCIDR(e); err == nil {
+				g.nets = append(g.nets, n)
+			}
+		default:
+			if ip := net.ParseIP(e); ip != nil {
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				g.nets = append(g.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			g.names[e] = true
+		}
+	}
+	return g
+}
+
+func (g *HostGuard) restricted() bool {
+	return len(g.names) > 0 || len(g.suffixes) > 0 || len(g.nets) > 0
+}
+
+// blockedIP reports whether ip is link-local or a known metadata address.
+func blockedIP(ip net.IP) bool {
+	if ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, m := range metadataIPs {
+		if m.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *HostGuard) ipAllowed(ip net.IP) bool {
+	for _, n := range g.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL verifies that u targets an allowed host.
+func (g *HostGuard) CheckURL(u *url.URL) error {
+	if g == nil {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedIP(ip) || (g.restricted() && !g.ipAllowed(ip)) {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		return nil
+	}
+	if !g.restricted() || g.names[host] {
+		return nil
+	}
+	for _, s := range g.suffixes {
+		if strings.HasSuffix(host, s) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+}
+
+// Control is a net.Dialer Control hook that applies the guard to the
+// address a connection resolved to.
+func (g *HostGuard) Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && !g.dialAllowed(ip) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+	return nil
+}
+
+// dialAllowed applies the policy for resolved addresses.
+func (g *HostGuard) dialAllowed(ip net.IP) bool {
+	switch {
+	case blockedIP(ip):
+		return false
+	case len(g.nets) > 0:
+		return g.ipAllowed(ip)
+	case g.restricted():
+		return !ip.IsLoopback()
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	g := NewHostGuard([]string{"agent-service", "*.svc.cluster.local", "10.0.0.0/8"})
+	for _, tc := range []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://agent-service:8080/x", true},
+		{"http://users.default.svc.cluster.local/x", true},
+		{"http://10.1.2.3/x", true},
+		{"http://other-service/x", false},
+		{"http://192.168.1.1/x", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.100.100.200/latest/meta-data", false},
+	} {
+		u, _ := url.Parse(tc.url)
+		if err := g.CheckURL(u); (err == nil) != tc.allowed {
+			t.Errorf("CheckURL(%s) = %v, want allowed=%v", tc.url, err, tc.allowed)
+		}
+	}
+}
+
+func TestCheckURLUnrestrictedStillBlocksMetadata(t *testing.T) {
+	g := NewHostGuard(nil)
+	for _, raw := range []string{"http://169.254.169.254/", "http://[fd00:ec2::254]/"} {
+		u, _ := url.Parse(raw)
+		if err := g.CheckURL(u); !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("CheckURL(%s) = %v, want ErrHostNotAllowed", raw, err)
+		}
+	}
+	u, _ := url.Parse("http://example.com/")
+	if err := g.CheckURL(u); err != nil {
+		t.Errorf("CheckURL(example.com) = %v", err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		allow   []string
+		address string
+		allowed bool
+	}{
+		{"metadata", nil, "169.254.169.254:80", false},
+		{"metadata allowlisted", []string{"0.0.0.0/0"}, "169.254.169.254:80", false},
+		{"unrestricted", nil, "127.0.0.1:80", true},
+		{"inside cidr", []string{"agent-service", "10.0.0.0/8"}, "10.0.0.5:80", true},
+		{"rebound outside cidr", []string{"agent-service", "10.0.0.0/8"}, "192.168.0.5:80", false},
+		{"names only", []string{"agent-service"}, "10.0.0.5:80", true},
+		{"names only rebound to loopback", []string{"agent-service"}, "127.0.0.1:80", false},
+	} {
+		err := NewHostGuard(tc.allow).Control("tcp4", tc.address, nil)
+		if (err == nil) != tc.allowed {
+			t.Errorf("%s: Control(%s) = %v, want allowed=%v", tc.name, tc.address, err, tc.allowed)
+		}
+	}
+}
+
+// guardedClient dials through g the way the gateway's transport does.
+func guardedClient(g *HostGuard) *http.Client {
+	dialer := &net.Dialer{Timeout: time.Second, Control: g.Control}
+	return &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+}
+
+func TestGuardedDialAllowedHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	resp, err := guardedClient(NewHostGuard([]string{"127.0.0.1"})).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("allowed host refused: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestGuardedDialRefusesRebinding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	// "localhost" passes the name check but resolves outside 10.0.0.0/8
+	g := NewHostGuard([]string{"localhost", "10.0.0.0/8"})
+	target := "http://localhost:" + u.Port()
+	parsed, _ := url.Parse(target)
+	if err := g.CheckURL(parsed); err != nil {
+		t.Fatalf("CheckURL(localhost) = %v", err)
+	}
+	if _, err := guardedClient(g).Get(target); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("dial = %v, want ErrHostNotAllowed", err)
+	}
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// RequestInterceptor can modify an upstream request before it is sent.
+// Returning an error aborts the request with 500.
+type RequestInterceptor interface {
+	InterceptRequest(req *http.Request) error
+}
+
+// ResponseInterceptor can modify an upstream response before it is relayed
+// to the client. Returning an error replaces the response with 502.
+type ResponseInterceptor interface {
+	InterceptResponse(resp *http.Response) error
+}
+
+// Pipeline is the ordered set of interceptors attached to a route.
+// Interceptors run in slice order.
+type Pipeline struct {
+	Request  []RequestInterceptor
+	Response []ResponseInterceptor
+}
+
+// Empty reports whether the pipeline has no interceptors.
+func (pl Pipeline) Empty() bool {
+	return len(pl.Request) == 0 && len(pl.Response) == 0
+}
+
+type pipelineKey struct{}
+
+// WithPipeline attaches pl to ctx; the proxy applies it to requests made
+// with that context.
+func WithPipeline(ctx context.Context, pl Pipeline) context.Context {
+	return context.WithValue(ctx, pipelineKey{}, pl)
+}
+
+func pipelineFrom(ctx context.Context) Pipeline {
+	pl, _ := ctx.Value(pipelineKey{}).(Pipeline)
+	return pl
+}
+
+func (pl Pipeline) interceptRequest(req *http.Request) error {
+	for _, i := range pl.Request {
+		if err := i.InterceptRequest(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pl Pipeline) interceptResponse(resp *http.Response) error {
+	for _, i := range pl.Response {
+		if err := i.InterceptResponse(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HeaderInjector sets fixed headers on every upstream request.
+type HeaderInjector map[string]string
+
+// InterceptRequest implements RequestInterceptor.
+func (h HeaderInjector) InterceptRequest(req *http.Request) error {
+	for k, v := range h {
+		req.Header.Set(k, v)
+	}
+	return nil
+}
+
+// redacted replaces the value of a redacted JSON field.
+const redacted = "[REDACTED]"
+
+// JSONRedactor replaces the values of the named fields, at any depth, in
+// JSON response bodies. Bodies that are not valid JSON, or that have none
+// of the fields, pass through byte for byte; numbers are kept as written.
+type JSONRedactor []string
+
+// InterceptResponse implements ResponseInterceptor.
+func (f JSONRedactor) InterceptResponse(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) == nil && dec.Decode(new(json.RawMessage)) == io.EOF {
+		fields := make(map[string]bool, len(f))
+		for _, name := range f {
+			fields[name] = true
+		}
+		if redact(doc, fields) {
+			if out, err := json.Marshal(doc); err == nil {
+				body = out
+			}
+		}
+	}
+	SetBody(resp, body)
+	return nil
+}
+
+// redact replaces the named fields in v in place and reports whether it
+// found any.
+func redact(v interface{}, fields map[string]bool) bool {
+	found := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if fields[k] {
+				t[k] = redacted
+				found = true
+				continue
+			}
+			found = redact(val, fields) || found
+		}
+	case []interface{}:
+		for _, val := range t {
+			found = redact(val, fields) || found
+		}
+	}
+	return found
+}
+
+// ErrResponseTooLarge is returned by reads of a buffered upstream response
+// that exceeds Options.MaxBuffered.
+var ErrResponseTooLarge = errors.New("upstream response too large")
+
+// LimitBody caps reads of an upstream response body at max bytes; reading
+// past the cap fails with ErrResponseTooLarge. max <= 0 returns body as is.
+func LimitBody(body io.ReadCloser, max int64) io.ReadCloser {
+	if max <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, remaining: max}
+}
+
+// limitedBody fails with ErrResponseTooLarge once more than remaining
+// bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only an upstream that has more to send is too large
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// SetBody replaces resp's body, keeping the length headers consistent.
+// It is meant for response interceptors that rewrite the body.
+func SetBody(resp *http.Response, body []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
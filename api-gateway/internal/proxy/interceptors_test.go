@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingInterceptor rejects every request and response.
+type failingInterceptor struct{}
+
+func (failingInterceptor) InterceptRequest(*http.Request) error   { return errors.New("denied") }
+func (failingInterceptor) InterceptResponse(*http.Response) error { return errors.New("mangled") }
+
+func TestJSONRedactorRedactsNestedFields(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(
+		`{"token":"t1","user":{"name":"ann","password":"p"},"items":[{"token":"t2"}]}`))}
+	if err := (JSONRedactor{"token", "password"}).InterceptResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	want := `{"items":[{"token":"[REDACTED]"}],"token":"[REDACTED]","user":{"name":"ann","password":"[REDACTED]"}}`
+	if string(b) != want {
+		t.Fatalf("body = %s, want %s", b, want)
+	}
+	if resp.ContentLength != int64(len(want)) || resp.Header.Get("Content-Length") == "" {
+		t.Fatalf("length = %d / %q, want %d", resp.ContentLength, resp.Header.Get("Content-Length"), len(want))
+	}
+}
+
+func TestJSONRedactorPassesNonJSONThrough(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader("token=abc"))}
+	(JSONRedactor{"token"}).InterceptResponse(resp)
+	if b, _ := io.ReadAll(resp.Body); string(b) != "token=abc" {
+		t.Fatalf("body = %q", b)
+	}
+}
+
+func TestJSONRedactorKeepsNumbersAndUntouchedBodies(t *testing.T) {
+	for in, want := range map[string]string{
+		// Large IDs survive without a float64 round trip
+		`{"id":9007199254740993,"token":"t","price":1.10}`: `{"id":9007199254740993,"price":1.10,"token":"[REDACTED]"}`,
+		// Nothing to redact: the upstream's bytes are relayed as they were
+		`{ "id": 9007199254740993, "html": "<b>" }`: `{ "id": 9007199254740993, "html": "<b>" }`,
+	} {
+		resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(in))}
+		if err := (JSONRedactor{"token"}).InterceptResponse(resp); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(resp.Body); string(b) != want {
+			t.Errorf("%s redacted to %s, want %s", in, b, want)
+		}
+	}
+}
+
+func TestProxyAppliesRoutePipeline(t *testing.T) {
+	var gotHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Tenant")
+		io.WriteString(w, `{"secret":"s","ok":true}`)
+	}))
+	defer srv.Close()
+
+	pl := Pipeline{
+		Request:  []RequestInterceptor{HeaderInjector{"X-Tenant": "acme"}},
+		Response: []ResponseInterceptor{JSONRedactor{"secret"}},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/agent", nil)
+	rec := httptest.NewRecorder()
+	New(&http.Client{}, Options{}).ProxyJSON(rec, r.WithContext(WithPipeline(r.Context(), pl)), http.MethodGet, srv.URL, nil)
+
+	if gotHeader != "acme" {
+		t.Errorf("upstream X-Tenant = %q", gotHeader)
+	}
+	if body := rec.Body.String(); body != `{"ok":true,"secret":"[REDACTED]"}` {
+		t.Errorf("client got %s", body)
+	}
+}
+
+func TestProxyInterceptorErrors(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		pl       Pipeline
+		status   int
+		upstream bool
+	}{
+		{"request", Pipeline{Request: []RequestInterceptor{failingInterceptor{}}}, http.StatusInternalServerError, false},
+		{"response", Pipeline{Response: []ResponseInterceptor{failingInterceptor{}}}, http.StatusBadGateway, true},
+	} {
+		hit = false
+		rec := httptest.NewRecorder()
+		ctx := WithPipeline(context.Background(), tc.pl)
+		New(&http.Client{}, Options{}).ProxyJSON(rec, httptest.NewRequest(http.MethodGet, "/agent", nil).WithContext(ctx), http.MethodGet, srv.URL, nil)
+		if rec.Code != tc.status || hit != tc.upstream {
+			t.Errorf("%s interceptor error: %d, upstream reached %v; want %d, %v", tc.name, rec.Code, hit, tc.status, tc.upstream)
+		}
+	}
+}
+
+func TestBufferedResponseCapHoldsAcrossRetryAndFailover(t *testing.T) {
+	big := `{"data":"` + strings.Repeat("x", 2048) + `"}`
+	var calls int
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, big)
+			return
+		}
+		io.WriteString(w, r.URL.Query().Get("body"))
+	}))
+	defer flaky.Close()
+
+	pl := Pipeline{Response: []ResponseInterceptor{JSONRedactor{"secret"}}}
+	for _, tc := range []struct {
+		name string
+		opts Options
+		url  func(body string) string
+	}{
+		{"retry", Options{Retry: RetryConfig{MaxAttempts: 2, Backoff: time.Millisecond}}, func(body string) string {
+			calls = 0
+			return flaky.URL + "?body=" + url.QueryEscape(body)
+		}},
+		{"failover", Options{MaxFailovers: 1}, func(body string) string {
+			calls = 1 // the live instance answers at once
+			return refusedURL(t) + "?body=" + url.QueryEscape(body)
+		}},
+	} {
+		for body, want := range map[string]int{`{"ok":true}`: http.StatusOK, big: http.StatusBadGateway} {
+			tc.opts.Breaker, tc.opts.MaxBuffered = tolerantBreaker, 1024
+			p := New(&http.Client{}, tc.opts)
+			p.SetFailover(func(_ context.Context, failed *url.URL) (string, bool) { return flaky.URL + "?" + failed.RawQuery, true })
+			r := httptest.NewRequest(http.MethodGet, "/agent", nil)
+			rec := httptest.NewRecorder()
+			p.ProxyJSON(rec, r.WithContext(WithPipeline(r.Context(), pl)), http.MethodGet, tc.url(body), nil)
+			if rec.Code != want {
+				t.Errorf("%s with a %d-byte response: status %d, want %d", tc.name, len(body), rec.Code, want)
+			}
+		}
+	}
+}
@@ -2,145 +2,757 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sony/gobreaker"
+
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/middleware"
 )
 
+// errSlowClient is returned when a streaming client falls too far behind.
+var errSlowClient = errors.New("client too slow: stream buffer limit exceeded")
+
 // Client handles proxied requests with Circuit Breaker
 type Client struct {
-	client *http.Client
-	cb     *gobreaker.CircuitBreaker
+	client            *http.Client
+	stream            *http.Client // client without the overall timeout
+	cb                atomic.Pointer[gobreaker.TwoStepCircuitBreaker]
+	cbSettings        gobreaker.Settings // to rebuild cb on Reset
+	forcedOpen        atomic.Bool        // set by Trip, cleared by Reset
+	streamMaxBuffered int64
+	retry             RetryConfig
+	guard             *HostGuard
+	buffers           *bufferPool
+	failover          FailoverFunc
+	maxFailovers      int
+	upstreamHeader    bool
+	retryAfter        string   // Retry-After seconds sent while the breaker is open
+	inflight          sync.Map // host -> *atomic.Int64, see Inflight
+	maxBuffered       int64
+
+	changesMu  sync.Mutex
+	changes    map[string]uint64 // "closed->open" -> count, see StateChanges
+	lastChange StateChange
+}
+
+// StateChange is a circuit breaker transition.
+type StateChange struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// Options configures a proxy Client.
+type Options struct {
+	Breaker BreakerConfig
+	// StreamMaxBuffered caps how many bytes of a streamed response may be
+	// read from the upstream ahead of the client. 0 disables the cap and
+	// relays strictly chunk by chunk.
+	StreamMaxBuffered int64
+	Retry             RetryConfig
+	// Guard, when set, is checked before every upstream request.
+	Guard *HostGuard
+	// CopyBufferSize is the size of the pooled buffers used to relay
+	// response bodies (0 = 32KB).
+	CopyBufferSize int
+	// MaxFailovers is how many times a request may move to another
+	// instance after a refused connection (see SetFailover). Refused
+	// connections are never retried against the same address.
+	MaxFailovers int
+	// UpstreamHeader adds X-Gateway-Upstream, the host of the instance that
+	// answered, to proxied responses.
+	UpstreamHeader bool
+	// MaxBuffered caps upstream responses read whole for response
+	// interceptors, in bytes (0 = unlimited). Larger ones get a 502.
+	MaxBuffered int64
+}
+
+// RetryConfig controls retries of transient upstream failures.
+type RetryConfig struct {
+	MaxAttempts int           // total attempts including the first; <= 1 disables retries
+	Backoff     time.Duration // delay before the second attempt, doubled after each retry
+	// Jitter randomizes each delay by up to this fraction (0-1) either way,
+	// so clients that failed together don't retry in lockstep.
+	Jitter float64
+	// NonIdempotent also retries POST and PATCH requests with a replayable
+	// body. Without it only requests carrying an Idempotency-Key are.
+	NonIdempotent bool
+}
+
+// delay returns the wait before a retry whose base delay is d.
+func (rc RetryConfig) delay(d time.Duration) time.Duration {
+	j := min(max(rc.Jitter, 0), 1)
+	if j == 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+}
+
+// BreakerConfig controls when the circuit breaker trips.
+type BreakerConfig struct {
+	Interval            time.Duration // Cyclic period of the closed state
+	Timeout             time.Duration // Duration of open state
+	Strategy            string        // "consecutive" or "ratio"
+	ConsecutiveFailures uint32
+	FailureRatio        float64
+	MinRequests         uint32
+}
+
+// ReadyToTrip returns the gobreaker trip function for the configured strategy.
+func (bc BreakerConfig) ReadyToTrip() func(gobreaker.Counts) bool {
+	if bc.Strategy == "ratio" {
+		return func(counts gobreaker.Counts) bool {
+			// Trip when the failure share over the interval exceeds the
+			// ratio. Cancelled calls are never reported, so only outcomes
+			// count, not Requests.
+			outcomes := counts.TotalSuccesses + counts.TotalFailures
+			if outcomes == 0 || outcomes < bc.MinRequests {
+				return false
+			}
+			return float64(counts.TotalFailures)/float64(outcomes) > bc.FailureRatio
+		}
+	}
+	return func(counts gobreaker.Counts) bool {
+		// Trip after N consecutive failures
+		return counts.ConsecutiveFailures >= bc.ConsecutiveFailures
+	}
 }
 
-// New creates a new Client with default Circuit Breaker settings
-func New(client *http.Client) *Client {
+// New creates a new Client whose Circuit Breaker follows opts.Breaker
+func New(client *http.Client, opts Options) *Client {
+	bc := opts.Breaker
 	st := gobreaker.Settings{
 		Name:        "API Gateway Proxy",
-		MaxRequests: 1,                // Max requests allowed in half-open state
-		Interval:    10 * time.Second, // Cyclic period of the closed state
-		Timeout:     30 * time.Second, // Duration of open state
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			// Trip if 3+ consecutive failures occur
-			return counts.ConsecutiveFailures >= 3
-		},
+		MaxRequests: 1, // Max requests allowed in half-open state
+		Interval:    bc.Interval,
+		Timeout:     bc.Timeout,
+		ReadyToTrip: bc.ReadyToTrip(),
 	}
-	return &Client{
-		client: client,
-		cb:     gobreaker.NewCircuitBreaker(st),
+	p := &Client{
+		client:            client,
+		stream:            &http.Client{Transport: client.Transport, CheckRedirect: client.CheckRedirect, Jar: client.Jar},
+		cbSettings:        st,
+		streamMaxBuffered: opts.StreamMaxBuffered,
+		retry:             opts.Retry,
+		guard:             opts.Guard,
+		buffers:           newBufferPool(opts.CopyBufferSize),
+		maxFailovers:      opts.MaxFailovers,
+		upstreamHeader:    opts.UpstreamHeader,
+		maxBuffered:       opts.MaxBuffered,
+		retryAfter:        strconv.Itoa(int(math.Ceil(bc.Timeout.Seconds()))),
+		changes:           make(map[string]uint64),
 	}
+	p.cbSettings.OnStateChange = p.onStateChange
+	p.cb.Store(gobreaker.NewTwoStepCircuitBreaker(p.cbSettings))
+	return p
 }
 
 // ProxyJSON proxies a JSON request to another service protected by Circuit Breaker
 func (p *Client) ProxyJSON(w http.ResponseWriter, r *http.Request, method, url string, body []byte) {
 	var bodyReader io.Reader
-	if body != nil {
+	if len(body) > 0 || (body != nil && methodHasBody(method)) {
 		bodyReader = bytes.NewReader(body)
 	}
 
 	// Prepare request
 	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	req = req.WithContext(r.Context())
-	req.Header.Set("Accept", "application/json")
-	if method == http.MethodPost {
-		req.Header.Set("Content-Type", "application/json")
+	copyRequestHeaders(req, r)
+	p.forward(w, r, req, false)
+}
+
+// ProxyRequest forwards r to url as-is: its method, end-to-end headers and
+// body go upstream unchanged, and the upstream's headers come back with
+// the response. The body is passed separately so it can be replayed on
+// retries.
+func (p *Client) ProxyRequest(w http.ResponseWriter, r *http.Request, url string, body []byte) {
+	var bodyReader io.Reader
+	if len(body) > 0 {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(r.Method, url, bodyReader)
+	if err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
 	}
+	copyRequestHeaders(req, r)
+	p.forward(w, r, req, true)
+}
 
-	// Execute via Circuit Breaker
-	result, err := p.cb.Execute(func() (interface{}, error) {
-		resp, err := p.client.Do(req)
-		if err != nil {
-			return nil, err
+// copyRequestHeaders gives req the client's end-to-end headers, so
+// Authorization and X-Request-ID reach the upstream. Framing headers are
+// left to req itself, X-Api-Key stays with the gateway that checked it, and
+// Accept-Encoding is dropped: the
+// gateway negotiates compression with clients on its own, and the
+// transport's transparent decompression keeps bodies readable for
+// response interceptors.
+func copyRequestHeaders(req, r *http.Request) {
+	CopyHeaders(req.Header, r.Header)
+	req.Header.Del("Expect")
+	req.Header.Del("X-Api-Key")
+	req.Header.Del("Accept-Encoding")
+	setForwardedHeaders(req.Header, r)
+}
+
+// setForwardedHeaders tells the upstream who the original client was, so
+// it can log real client IPs and build absolute URLs. The peer is appended
+// to any X-Forwarded-For chain already present, so the rightmost entry is
+// always the address the gateway saw. Proto and Host come from
+// ForwardedMiddleware, which only believes them from trusted proxies.
+func setForwardedHeaders(h http.Header, r *http.Request) {
+	if peer, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			peer = strings.Join(prior, ", ") + ", " + peer
+		}
+		h.Set("X-Forwarded-For", peer)
+	}
+	h.Set("X-Forwarded-Proto", middleware.Scheme(r))
+	h.Set("X-Forwarded-Host", middleware.Host(r))
+}
+
+// hopHeaders apply to a single connection and are never forwarded.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// CopyHeaders copies src into dst for relaying a message whose body is
+// re-framed: hop-by-hop headers and Content-Length are left out.
+func CopyHeaders(dst, src http.Header) {
+	copyEndToEnd(dst, src)
+	dst.Del("Content-Length")
+}
+
+// copyEndToEnd copies src into dst, leaving out hop-by-hop headers and
+// any named in src's Connection header.
+func copyEndToEnd(dst, src http.Header) {
+	skip := make(map[string]bool, len(hopHeaders))
+	for _, h := range hopHeaders {
+		skip[h] = true
+	}
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			skip[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
 		}
-		// Treat 5xx as failures for the circuit breaker
-		if resp.StatusCode >= 500 {
-			// We return resp even on error so we can read body/headers if needed,
-			// but we wrap it in error to trigger the CB failure counter.
-			return resp, fmt.Errorf("upstream error: %d", resp.StatusCode)
+	}
+	for k, vv := range src {
+		if !skip[k] {
+			dst[k] = append([]string(nil), vv...)
 		}
-		return resp, nil
-	})
+	}
+}
+
+// methodHasBody reports whether requests with this method carry a body
+// upstream. GET, HEAD, DELETE and friends are forwarded without body or
+// Content-Type.
+func methodHasBody(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue".
+func ExpectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// ProxyUpload forwards the client's request body to the upstream without
+// buffering it. If the client sent "Expect: 100-continue" the header is
+// passed on, so the transport waits for the upstream's 100 Continue before
+// reading the body; net/http only sends the client its 100 Continue on
+// that first read, so the upstream's answer effectively flows back first.
+func (p *Client) ProxyUpload(w http.ResponseWriter, r *http.Request, method, url string) {
+	req, err := http.NewRequest(method, url, r.Body)
+	if err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	req.ContentLength = r.ContentLength
+	copyRequestHeaders(req, r)
+	if ExpectsContinue(r) {
+		req.Header.Set("Expect", "100-continue")
+	}
+	p.forward(w, r, req, false)
+}
+
+// Do executes req through the Circuit Breaker, retrying transient failures.
+// Upstream 5xx responses count as breaker failures but are still returned
+// alongside the error so callers can relay them. Requests the client
+// cancelled never count as failures.
+//
+// Every attempt is a separate breaker execution, so each failed attempt
+// counts towards tripping it. When the breaker rejects an attempt
+// (gobreaker.ErrOpenState or gobreaker.ErrTooManyRequests) Do returns that
+// error immediately, without backing off or trying again: an open breaker
+// always fails fast. Only network errors and 502/503/504 responses to
+// idempotent requests whose body can be replayed are retried (see
+// RetryConfig.NonIdempotent), each retry going to another instance when a
+// Failover is configured and one is available. A refused connection is
+// not retried in place; the request moves to another instance instead,
+// for any method.
+func (p *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := p.guard.CheckURL(req.URL); err != nil {
+		return nil, err
+	}
+	backoff := p.retry.Backoff
+	failovers := 0
+	for attempt := 1; ; attempt++ {
+		resp, err := p.execute(req)
+		if isConnRefused(err) && failovers < p.maxFailovers && p.failoverTo(req) {
+			// The instance is gone: move on to the next one immediately,
+			// without spending a retry attempt or backing off.
+			failovers++
+			attempt--
+			continue
+		}
+		if attempt >= p.retry.MaxAttempts || !p.retryable(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain for connection reuse, but no more than a buffered
+			// response may hold
+			_, _ = io.Copy(io.Discard, LimitBody(resp.Body, p.maxBuffered))
+			resp.Body.Close()
+		}
+		t := time.NewTimer(p.retry.delay(backoff))
+		select {
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		case <-t.C:
+		}
+		backoff *= 2
+		if p.failoverTo(req) {
+			continue // body already rewound
+		}
+		if req.GetBody != nil {
+			body, gerr := req.GetBody()
+			if gerr != nil {
+				return nil, gerr
+			}
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether a failed attempt may be retried.
+func (p *Client) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests || isConnRefused(err) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+	default:
+		if !p.retry.NonIdempotent && req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if resp == nil {
+		return err != nil && req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
 
+// execute runs a single attempt of req through the Circuit Breaker.
+func (p *Client) execute(req *http.Request) (*http.Response, error) {
+	if p.forcedOpen.Load() {
+		return nil, gobreaker.ErrOpenState
+	}
+	if err := req.Context().Err(); errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	return p.guarded(req, func() (*http.Response, error) { return p.do(p.client, req) })
+}
+
+// guarded runs call through the circuit breaker. 5xx answers count as
+// failures and are returned along with an error, so callers can still
+// relay them.
+//
+// A call the client cancelled says nothing about the upstream and is left
+// out of the counts. The exception is a half-open probe: gobreaker refuses
+// further requests until the probe reports, and one that proved nothing
+// opens the breaker again rather than closing it.
+func (p *Client) guarded(req *http.Request, call func() (*http.Response, error)) (*http.Response, error) {
+	cb := p.cb.Load()
+	done, err := cb.Allow()
+	if err != nil {
+		return nil, err
+	}
+	probe := cb.State() == gobreaker.StateHalfOpen
+	resp, err := call()
+	if err == nil && resp.StatusCode >= 500 {
+		err = fmt.Errorf("upstream error: %d", resp.StatusCode)
+	}
+	if !errors.Is(req.Context().Err(), context.Canceled) {
+		done(err == nil)
+	} else if probe {
+		done(false)
+	}
+	return resp, err
+}
+
+// forward executes req on behalf of r through the Circuit Breaker and
+// relays the upstream response, headers included, to w. JSON routes
+// (passthrough false) are sent as application/json and answered as such
+// when the upstream names no Content-Type; passthrough leaves req as is.
+func (p *Client) forward(w http.ResponseWriter, r *http.Request, req *http.Request, passthrough bool) {
+	method := req.Method
+	req = req.WithContext(r.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		// A copied client Content-Type would describe a body that isn't sent
+		req.Header.Del("Content-Type")
+	}
+	if !passthrough {
+		// Client-supplied values win, e.g. a problem+json Accept
+		if req.Header.Get("Accept") == "" {
+			req.Header.Set("Accept", "application/json")
+		}
+		if req.Body != nil && methodHasBody(method) && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	pipeline := pipelineFrom(r.Context())
+	if err := pipeline.interceptRequest(req); err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("request interceptor failed: %v", err))
+		return
+	}
+
+	// Execute via Circuit Breaker
+	resp, err := p.Do(req)
+	// req.URL names the instance actually tried last, after any failover
+	middleware.SetUpstream(r, req.URL.Scheme+"://"+req.URL.Host)
 	switch err {
 	case gobreaker.ErrOpenState:
-		http.Error(w, "Service Unavailable (Circuit Breaker Open)", http.StatusServiceUnavailable)
+		w.Header().Set("Retry-After", p.retryAfter)
+		errpage.WriteCode(w, r, http.StatusServiceUnavailable, "circuit_open", "Service Unavailable (Circuit Breaker Open)")
 		return
 	case gobreaker.ErrTooManyRequests:
-		http.Error(w, "Service Unavailable (Circuit Breaker Half-Open Limit)", http.StatusServiceUnavailable)
+		w.Header().Set("Retry-After", p.retryAfter)
+		errpage.WriteCode(w, r, http.StatusServiceUnavailable, "circuit_half_open", "Service Unavailable (Circuit Breaker Half-Open Limit)")
 		return
 	}
 
-	if result == nil && err != nil {
-		http.Error(w, fmt.Sprintf("Upstream failed: %v", err), http.StatusBadGateway)
+	if resp == nil {
+		if errors.Is(err, context.Canceled) {
+			// The client is gone; there is nobody to answer
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			errpage.Write(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		if errors.Is(err, ErrHostNotAllowed) {
+			errpage.Write(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			errpage.WriteCode(w, r, http.StatusGatewayTimeout, "upstream_timeout", fmt.Sprintf("Upstream timed out: %v", err))
+			return
+		}
+		errpage.WriteCode(w, r, http.StatusBadGateway, "upstream_failed", fmt.Sprintf("Upstream failed: %v", err))
 		return
 	}
-
-	resp, ok := result.(*http.Response)
-	if !ok {
-		// Should not happen if logic matches above
-		http.Error(w, "Internal Proxy Error", http.StatusInternalServerError)
+	defer func() { resp.Body.Close() }()
+	if len(pipeline.Response) > 0 {
+		resp.Body = LimitBody(resp.Body, p.maxBuffered)
+	}
+	if err := pipeline.interceptResponse(resp); err != nil {
+		errpage.Write(w, r, http.StatusBadGateway, fmt.Sprintf("response interceptor failed: %v", err))
 		return
 	}
-	defer resp.Body.Close()
 
-	w.Header().Set("Content-Type", "application/json")
+	// Relay the upstream's headers (Cache-Control, ETag, problem+json
+	// Content-Types...); JSON routes fall back to application/json.
+	CopyHeaders(w.Header(), resp.Header)
+	if !passthrough && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	p.setUpstreamHeader(w, resp)
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	_, _ = p.copyBuffered(w, resp.Body)
+}
+
+// setUpstreamHeader records which instance answered. resp.Request is the
+// request actually sent, so a failover shows the instance it moved to.
+func (p *Client) setUpstreamHeader(w http.ResponseWriter, resp *http.Response) {
+	if p.upstreamHeader && resp.Request != nil {
+		w.Header().Set("X-Gateway-Upstream", resp.Request.URL.Host)
+	}
 }
 
-// ProxyStream proxies a request and streams the response body to the client.
+// ProxyStream proxies a request and streams the response body to the
+// client, flushing after every chunk so server-sent events arrive as the
+// upstream emits them. The upstream request carries r's context, so a
+// client disconnect ends the relay. Streams are not subject to the
+// client's overall timeout, only to the transport's connect, TLS handshake
+// and response header timeouts. A breaker forced open with Trip refuses
+// streams too.
 func (p *Client) ProxyStream(w http.ResponseWriter, r *http.Request, method, url string, body []byte) {
+	if p.forcedOpen.Load() {
+		w.Header().Set("Retry-After", p.retryAfter)
+		errpage.WriteCode(w, r, http.StatusServiceUnavailable, "circuit_open", "Service Unavailable (Circuit Breaker Open)")
+		return
+	}
 	var bodyReader io.Reader
-	if body != nil {
+	if len(body) > 0 || (body != nil && methodHasBody(method)) {
 		bodyReader = bytes.NewReader(body)
 	}
 
 	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	req = req.WithContext(r.Context())
+	copyRequestHeaders(req, r)
 	req.Header.Set("Accept", "text/event-stream")
-	if method == http.MethodPost {
+	if req.Body != nil && methodHasBody(method) && req.Header.Get("Content-Type") == "" {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	// Response interceptors need the whole body, so streams only get the
+	// request side of the route's pipeline.
+	if err := pipelineFrom(r.Context()).interceptRequest(req); err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("request interceptor failed: %v", err))
+		return
+	}
+	if err := p.guard.CheckURL(req.URL); err != nil {
+		errpage.Write(w, r, http.StatusForbidden, err.Error())
+		return
+	}
 
-	resp, err := p.client.Do(req)
+	resp, err := p.do(p.stream, req)
+	middleware.SetUpstream(r, req.URL.Scheme+"://"+req.URL.Host)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		errpage.Write(w, r, http.StatusBadGateway, err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
-	for k, v := range resp.Header {
-		if k != "Content-Length" {
-			w.Header()[k] = v
-		}
+	CopyHeaders(w.Header(), resp.Header)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/event-stream")
 	}
 	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx-style reverse proxies in front of us from buffering
+	w.Header().Set("X-Accel-Buffering", "no")
+	p.setUpstreamHeader(w, resp)
 	w.WriteHeader(resp.StatusCode)
 
-	if flusher, ok := w.(http.Flusher); ok {
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
 		flusher.Flush()
 	}
 
-	_, _ = io.Copy(w, resp.Body)
+	if err := p.copyStream(w, flusher, resp.Body); err == errSlowClient {
+		slog.Warn("[proxy] aborting stream", "method", method, "url", url, "err", err)
+		// Headers are already sent; abort the connection so the client
+		// sees a truncated stream rather than a clean end.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// copyStream relays src to w one chunk at a time, flushing after every
+// write so the client's pace governs the relay. Reads from the upstream
+// may run ahead of the client: the reader blocks once the chunks it has
+// queued fill the pooled buffers set aside for the stream, and
+// errSlowClient is returned when more than streamMaxBuffered bytes are
+// waiting on a client write in progress. A client that isn't writing
+// just hasn't been scheduled yet and is never reported as slow.
+func (p *Client) copyStream(w io.Writer, flusher http.Flusher, src io.Reader) error {
+	write := func(b []byte) error {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if p.streamMaxBuffered <= 0 {
+		bufp := p.buffers.get()
+		defer p.buffers.put(bufp)
+		buf := *bufp
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				if werr := write(buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	type chunk struct {
+		buf *[]byte
+		n   int
+	}
+	// Every queued chunk holds a whole pooled buffer, so the number of
+	// slots bounds memory; the in-flight write and the reader's buffer
+	// make up the remaining two.
+	slots := int(p.streamMaxBuffered/int64(p.buffers.size)) - 1
+	if slots < 1 {
+		slots = 1
+	}
+	chunks := make(chan chunk, slots)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		// Return chunks the client never got; the reader closes chunks
+		// once it stops, which may wait for its current Read.
+		go func() {
+			for c := range chunks {
+				p.buffers.put(c.buf)
+			}
+		}()
+	}()
+	readErr := make(chan error, 1)
+	var queued atomic.Int64 // bytes read but not yet written to the client
+	var writing atomic.Bool // the client is in the middle of a write
+
+	go func() {
+		defer close(chunks)
+		for {
+			buf := p.buffers.get()
+			n, err := src.Read(*buf)
+			if n > 0 {
+				if queued.Add(int64(n)) > p.streamMaxBuffered && writing.Load() {
+					p.buffers.put(buf)
+					readErr <- errSlowClient
+					return
+				}
+				select {
+				case chunks <- chunk{buf, n}:
+				case <-done:
+					p.buffers.put(buf)
+					return
+				}
+			} else {
+				p.buffers.put(buf)
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+
+	for c := range chunks {
+		writing.Store(true)
+		err := write((*c.buf)[:c.n])
+		writing.Store(false)
+		queued.Add(-int64(c.n))
+		p.buffers.put(c.buf)
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-readErr:
+		return err
+	default:
+		return nil
+	}
 }
 
 // State returns the current state of the circuit breaker
 func (p *Client) State() gobreaker.State {
-	return p.cb.State()
+	if p.forcedOpen.Load() {
+		return gobreaker.StateOpen
+	}
+	return p.cb.Load().State()
+}
+
+// onStateChange logs and counts transitions made by the breaker itself.
+func (p *Client) onStateChange(name string, from, to gobreaker.State) {
+	level := slog.LevelInfo
+	if to == gobreaker.StateOpen {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "[proxy] circuit breaker state changed", "service", name, "from", from.String(), "to", to.String())
+	p.recordStateChange(from, to)
+}
+
+func (p *Client) recordStateChange(from, to gobreaker.State) {
+	p.changesMu.Lock()
+	defer p.changesMu.Unlock()
+	p.changes[from.String()+"->"+to.String()]++
+	p.lastChange = StateChange{From: from.String(), To: to.String(), At: time.Now()}
+}
+
+// StateChanges returns how often each transition ("closed->open", ...)
+// happened, and the most recent one (zero At if none has).
+func (p *Client) StateChanges() (map[string]uint64, StateChange) {
+	p.changesMu.Lock()
+	defer p.changesMu.Unlock()
+	counts := make(map[string]uint64, len(p.changes))
+	for k, n := range p.changes {
+		counts[k] = n
+	}
+	return counts, p.lastChange
 }
 
 // Counts returns the current execution counts
 func (p *Client) Counts() gobreaker.Counts {
-	return p.cb.Counts()
+	return p.cb.Load().Counts()
+}
+
+// Reset closes the circuit breaker and clears its counts, undoing a Trip.
+// gobreaker has no reset, so the breaker is replaced with a fresh one.
+func (p *Client) Reset() {
+	if from := p.State(); from != gobreaker.StateClosed {
+		p.recordStateChange(from, gobreaker.StateClosed)
+	}
+	p.cb.Store(gobreaker.NewTwoStepCircuitBreaker(p.cbSettings))
+	p.forcedOpen.Store(false)
+	slog.Info("[proxy] circuit breaker reset to closed")
+}
+
+// Trip forces the circuit breaker open until Reset is called, e.g. for
+// upstream maintenance. Unlike a tripped-by-failures breaker it does not
+// move to half-open on its own.
+func (p *Client) Trip() {
+	if from := p.State(); from != gobreaker.StateOpen {
+		p.recordStateChange(from, gobreaker.StateOpen)
+	}
+	p.forcedOpen.Store(true)
+	slog.Info("[proxy] circuit breaker forced open")
 }
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"my_app/api-gateway/internal/middleware"
+)
+
+// seenRequest is what an upstream received.
+type seenRequest struct {
+	method, contentType, body string
+	contentLength             int64
+	chunked                   bool
+}
+
+// recordingUpstream reports every request it receives on the returned
+// channel.
+func recordingUpstream(t *testing.T) (*httptest.Server, <-chan seenRequest) {
+	t.Helper()
+	seen := make(chan seenRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		seen <- seenRequest{
+			method:        r.Method,
+			contentType:   r.Header.Get("Content-Type"),
+			body:          string(b),
+			contentLength: r.ContentLength,
+			chunked:       len(r.TransferEncoding) > 0,
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, seen
+}
+
+func TestProxyJSONBodylessMethods(t *testing.T) {
+	srv, seen := recordingUpstream(t)
+	p := New(&http.Client{}, Options{})
+	for _, method := range []string{http.MethodGet, http.MethodDelete, http.MethodHead} {
+		rec := httptest.NewRecorder()
+		p.ProxyJSON(rec, httptest.NewRequest(method, "/", nil), method, srv.URL, []byte{})
+		got := <-seen
+		if got.contentType != "" || got.contentLength != 0 || got.chunked || got.body != "" {
+			t.Errorf("%s upstream got %+v, want no body or Content-Type", method, got)
+		}
+	}
+}
+
+func TestProxyJSONSendsBodyForPost(t *testing.T) {
+	srv, seen := recordingUpstream(t)
+	p := New(&http.Client{}, Options{})
+	rec := httptest.NewRecorder()
+	p.ProxyJSON(rec, httptest.NewRequest(http.MethodPost, "/", nil), http.MethodPost, srv.URL, []byte(`{"q":1}`))
+	got := <-seen
+	if got.contentType != "application/json" || got.body != `{"q":1}` || got.contentLength != 7 {
+		t.Fatalf("upstream got %+v", got)
+	}
+}
+
+func TestProxyJSONRelaysResponseHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		upstreamType, want string
+	}{
+		{"application/problem+json", "application/problem+json"},
+		{"", "application/json"},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Connection", "close")
+			if tc.upstreamType != "" {
+				w.Header().Set("Content-Type", tc.upstreamType)
+			} else {
+				w.Header()["Content-Type"] = nil // stop net/http sniffing one
+			}
+			io.WriteString(w, `{}`)
+		}))
+		rec := httptest.NewRecorder()
+		New(&http.Client{}, Options{}).ProxyJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.MethodGet, srv.URL, nil)
+		srv.Close()
+
+		h := rec.Header()
+		if h.Get("Content-Type") != tc.want || h.Get("Cache-Control") != "max-age=60" || h.Get("ETag") != `"v1"` {
+			t.Errorf("upstream Content-Type %q: relayed %v", tc.upstreamType, h)
+		}
+		if h.Get("Connection") != "" {
+			t.Errorf("hop-by-hop Connection header relayed: %v", h)
+		}
+	}
+}
+
+func TestCopyRequestHeadersKeepsOnlyEndToEndHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Authorization", "Bearer t")
+	r.Header.Set("X-Request-ID", "req-1")
+	r.Header.Set("X-Trace-Hint", "a")
+	r.Header.Set("Connection", "X-Trace-Hint")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Expect", "100-continue")
+	r.Header.Set("Content-Length", "12")
+	r.Header.Set("X-Api-Key", "gateway-key")
+
+	req, _ := http.NewRequest(http.MethodPost, "http://upstream", nil)
+	copyRequestHeaders(req, r)
+	if req.Header.Get("Authorization") != "Bearer t" || req.Header.Get("X-Request-ID") != "req-1" {
+		t.Errorf("end-to-end headers lost: %v", req.Header)
+	}
+	for _, h := range []string{"X-Trace-Hint", "Connection", "Keep-Alive", "Accept-Encoding", "Expect", "Content-Length", "X-Api-Key"} {
+		if v := req.Header.Get(h); v != "" {
+			t.Errorf("%s = %q forwarded to the upstream", h, v)
+		}
+	}
+}
+
+func TestCopyHeadersDropsHopByHopAndLength(t *testing.T) {
+	src := http.Header{}
+	src.Set("Content-Type", "application/json")
+	src.Add("Cache-Control", "no-store")
+	src.Add("Cache-Control", "private")
+	src.Set("Content-Length", "42")
+	src.Set("Transfer-Encoding", "chunked")
+	src.Set("Connection", "keep-alive, X-Upstream-Debug")
+	src.Set("X-Upstream-Debug", "1")
+
+	dst := http.Header{}
+	CopyHeaders(dst, src)
+	want := http.Header{
+		"Content-Type":  {"application/json"},
+		"Cache-Control": {"no-store", "private"},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Fatalf("CopyHeaders = %v, want %v", dst, want)
+	}
+}
+
+func TestForwardedHeadersReachUpstream(t *testing.T) {
+	got := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	defer srv.Close()
+
+	trusted := middleware.NewTrustedProxies([]string{"10.0.0.0/8"})
+	p := New(&http.Client{}, Options{})
+	for _, tc := range []struct {
+		name, remote, xff, proto, host string
+	}{
+		{"trusted proxy", "10.0.0.2:5555", "1.2.3.4, 10.0.0.2", "https", "shop.example"},
+		{"untrusted peer", "203.0.113.9:5555", "1.2.3.4, 203.0.113.9", "http", "gateway.internal"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://gateway.internal/agent", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "shop.example")
+		middleware.ForwardedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.ProxyJSON(w, r, http.MethodGet, srv.URL, nil)
+		}), trusted).ServeHTTP(httptest.NewRecorder(), r)
+
+		h := <-got
+		if h.Get("X-Forwarded-For") != tc.xff || h.Get("X-Forwarded-Proto") != tc.proto || h.Get("X-Forwarded-Host") != tc.host {
+			t.Errorf("%s: upstream saw For %q, Proto %q, Host %q; want %q, %q, %q", tc.name,
+				h.Get("X-Forwarded-For"), h.Get("X-Forwarded-Proto"), h.Get("X-Forwarded-Host"), tc.xff, tc.proto, tc.host)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// flakyUpstream answers status for the first failures requests and 200
+// afterwards, counting every request in hits.
+func flakyUpstream(t *testing.T, status, failures int, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(hits.Add(1)) <= failures {
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// retryingClient retries up to attempts times behind a breaker that trips
+// after tripAfter consecutive failures.
+func retryingClient(attempts int, tripAfter uint32) *Client {
+	return New(&http.Client{}, Options{
+		Breaker: BreakerConfig{Timeout: time.Minute, Strategy: "consecutive", ConsecutiveFailures: tripAfter},
+		Retry:   RetryConfig{MaxAttempts: attempts, Backoff: time.Millisecond},
+	})
+}
+
+func doRequest(t *testing.T, p *Client, method, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestDoRetriesTransientFailures(t *testing.T) {
+	var hits atomic.Int32
+	srv := flakyUpstream(t, http.StatusServiceUnavailable, 2, &hits)
+
+	resp, err := doRequest(t, retryingClient(3, 10), http.MethodGet, srv.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Do = %v, %v; want 200 after retries", resp, err)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Fatalf("upstream saw %d attempts, want 3", n)
+	}
+}
+
+func TestDoDoesNotRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name, method string
+		status       int
+	}{
+		{"non-idempotent POST", http.MethodPost, http.StatusServiceUnavailable},
+		{"non-transient 500", http.MethodGet, http.StatusInternalServerError},
+		{"client error", http.MethodGet, http.StatusNotFound},
+	} {
+		var hits atomic.Int32
+		srv := flakyUpstream(t, tc.status, 1, &hits)
+		resp, _ := doRequest(t, retryingClient(3, 10), tc.method, srv.URL)
+		if resp == nil || resp.StatusCode != tc.status || hits.Load() != 1 {
+			t.Errorf("%s: got %v after %d attempts, want %d after 1", tc.name, resp, hits.Load(), tc.status)
+		}
+	}
+}
+
+func TestDoRetriesReplayableBody(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("payload"))
+	resp, err := retryingClient(2, 10).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Fatalf("upstream got bodies %q, want the payload twice", bodies)
+	}
+}
+
+func TestDoFailsFastOnOpenBreaker(t *testing.T) {
+	var hits atomic.Int32
+	srv := flakyUpstream(t, http.StatusServiceUnavailable, 100, &hits)
+
+	start := time.Now()
+	_, err := doRequest(t, retryingClient(5, 1), http.MethodGet, srv.URL)
+	if !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("err = %v, want ErrOpenState once the first failure trips the breaker", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("upstream saw %d attempts, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v to fail", elapsed)
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// endless is an upstream that always has more data ready, counting what
+// has been read from it.
+type endless struct{ read atomic.Int64 }
+
+func (e *endless) Read(b []byte) (int, error) {
+	e.read.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// slowWriter is a client that takes its time over every write.
+type slowWriter struct{ written atomic.Int64 }
+
+func (s *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	s.written.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func TestCopyStreamSlowClientStaysBounded(t *testing.T) {
+	const bufSize, maxBuffered = 4 << 10, 16 << 10
+	p := New(&http.Client{}, Options{CopyBufferSize: bufSize, StreamMaxBuffered: maxBuffered})
+	src, dst := &endless{}, &slowWriter{}
+
+	err := p.copyStream(dst, nil, src)
+	if !errors.Is(err, errSlowClient) {
+		t.Fatalf("copyStream = %v, want errSlowClient", err)
+	}
+	// Everything read is either delivered, queued (at most maxBuffered) or
+	// in the reader's hands (one buffer)
+	if ahead := src.read.Load() - dst.written.Load(); ahead > maxBuffered+bufSize {
+		t.Fatalf("read %d bytes ahead of the client, cap is %d", ahead, maxBuffered)
+	}
+	waitBuffersReturned(t, p)
+}
+
+func TestCopyStreamReturnsBuffers(t *testing.T) {
+	p := New(&http.Client{}, Options{CopyBufferSize: 1 << 10, StreamMaxBuffered: 8 << 10})
+	src := io.LimitReader(&endless{}, 64<<10)
+
+	if err := p.copyStream(io.Discard, nil, src); err != nil {
+		t.Fatal(err)
+	}
+	waitBuffersReturned(t, p)
+}
+
+// trickle is an upstream that hands out a few bytes per read, as an SSE
+// source sending small events does.
+type trickle struct{ left int }
+
+func (t *trickle) Read(b []byte) (int, error) {
+	if t.left == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(b), 64, t.left)
+	t.left -= n
+	return n, nil
+}
+
+func TestCopyStreamSmallReadsUnderCapComplete(t *testing.T) {
+	const total = 16 << 10
+	p := New(&http.Client{}, Options{CopyBufferSize: 1 << 10, StreamMaxBuffered: 8 << 10})
+	dst := &pacedWriter{pause: time.Millisecond}
+
+	// Each read fills a whole pooled buffer's slot with only 64 bytes, so
+	// the queue fills long before the bytes waiting reach the cap.
+	if err := p.copyStream(dst, nil, &trickle{left: total}); err != nil {
+		t.Fatalf("copyStream = %v, want the stream to complete", err)
+	}
+	if got := dst.written.Load(); got != total {
+		t.Fatalf("client got %d bytes, want %d", got, total)
+	}
+	waitBuffersReturned(t, p)
+}
+
+// pacedWriter is a client that pauses before every write.
+type pacedWriter struct {
+	pause   time.Duration
+	written atomic.Int64
+}
+
+func (p *pacedWriter) Write(b []byte) (int, error) {
+	time.Sleep(p.pause)
+	p.written.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func TestCopyStreamReturnsBuffersOnWriteError(t *testing.T) {
+	p := New(&http.Client{}, Options{CopyBufferSize: 1 << 10, StreamMaxBuffered: 8 << 10})
+
+	if err := p.copyStream(failingWriter{}, nil, &endless{}); err == nil {
+		t.Fatal("copyStream succeeded with a failing client")
+	}
+	waitBuffersReturned(t, p)
+}
+
+// failingWriter is a client that has gone away.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+// waitBuffersReturned fails unless every pooled buffer is back in the pool
+// shortly; leftover chunks are returned in the background.
+func waitBuffersReturned(t *testing.T, p *Client) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for p.buffers.inUse.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d buffers not returned to the pool", p.buffers.inUse.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// expectTransport waits long enough for a 100 Continue that a test never
+// sees the body sent because of the timeout.
+func expectTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ExpectContinueTimeout = 5 * time.Second
+	return tr
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// uploadVia sends body to gateway with "Expect: 100-continue".
+func uploadVia(t *testing.T, gateway string, body *countingReader, size int64) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, gateway, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Expect", "100-continue")
+	resp, err := (&http.Client{Transport: expectTransport()}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestProxyUploadPassesExpectAndBody(t *testing.T) {
+	const payload = "large upload body"
+	var expect, got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect = r.Header.Get("Expect")
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	defer upstream.Close()
+
+	p := New(&http.Client{Transport: expectTransport()}, Options{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ProxyUpload(w, r, http.MethodPost, upstream.URL)
+	}))
+	defer gateway.Close()
+
+	resp := uploadVia(t, gateway.URL, &countingReader{r: strings.NewReader(payload)}, int64(len(payload)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if expect != "100-continue" || got != payload {
+		t.Fatalf("upstream got Expect %q, body %q", expect, got)
+	}
+}
+
+func TestProxyUploadRejectedBeforeBodyIsSent(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Refuse without reading, so no 100 Continue is sent
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer upstream.Close()
+
+	p := New(&http.Client{Transport: expectTransport()}, Options{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ProxyUpload(w, r, http.MethodPost, upstream.URL)
+	}))
+	defer gateway.Close()
+
+	const size = 1 << 20
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", size))}
+	resp := uploadVia(t, gateway.URL, body, size)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want the upstream's 413", resp.StatusCode)
+	}
+	if n := body.n.Load(); n != 0 {
+		t.Fatalf("client sent %d body bytes before the upstream's answer", n)
+	}
+}
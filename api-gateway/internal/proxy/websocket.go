@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sony/gobreaker"
+
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/middleware"
+)
+
+// IsWebSocket reports whether r asks to upgrade to a WebSocket.
+func IsWebSocket(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProxyWebSocket relays the WebSocket handshake in r to url (http or https)
+// and, once the upstream switches protocols, copies frames both ways until
+// either side closes or r's context ends. Frames pass through untouched,
+// so close frames and their status codes reach the other side. An upstream
+// that refuses the upgrade has its response relayed as is.
+//
+// The handshake goes through the circuit breaker but not the client's
+// timeout, which would cut off long-lived connections.
+func (p *Client) ProxyWebSocket(w http.ResponseWriter, r *http.Request, url string) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Sec-WebSocket-* are end-to-end; the upgrade itself is hop-by-hop
+	copyRequestHeaders(req, r)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if err := p.guard.CheckURL(req.URL); err != nil {
+		errpage.Write(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// A WebSocket counts as in flight for as long as it is open
+	n := p.track(req.URL.Host)
+	defer n.Add(-1)
+	resp, err := p.handshake(req)
+	middleware.SetUpstream(r, req.URL.Scheme+"://"+req.URL.Host)
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState):
+		w.Header().Set("Retry-After", p.retryAfter)
+		errpage.WriteCode(w, r, http.StatusServiceUnavailable, "circuit_open", "Service Unavailable (Circuit Breaker Open)")
+		return
+	case errors.Is(err, gobreaker.ErrTooManyRequests):
+		w.Header().Set("Retry-After", p.retryAfter)
+		errpage.WriteCode(w, r, http.StatusServiceUnavailable, "circuit_half_open", "Service Unavailable (Circuit Breaker Half-Open Limit)")
+		return
+	case errors.Is(err, context.Canceled):
+		return
+	case resp == nil:
+		errpage.WriteCode(w, r, http.StatusBadGateway, "upstream_failed", fmt.Sprintf("Upstream failed: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		copyEndToEnd(w.Header(), resp.Header)
+		p.setUpstreamHeader(w, resp)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, "websocket upgrade not supported")
+		return
+	}
+	defer conn.Close()
+
+	h := make(http.Header)
+	copyEndToEnd(h, resp.Header)
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	if p.upstreamHeader {
+		h.Set("X-Gateway-Upstream", req.URL.Host)
+	}
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = h.Write(brw)
+	_, _ = brw.WriteString("\r\n")
+	if brw.Flush() != nil {
+		return
+	}
+
+	relay(r.Context(), conn, brw.Reader, backend)
+}
+
+// handshake sends the upgrade request through the circuit breaker.
+func (p *Client) handshake(req *http.Request) (*http.Response, error) {
+	if p.forcedOpen.Load() {
+		return nil, gobreaker.ErrOpenState
+	}
+	transport := p.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return p.guarded(req, func() (*http.Response, error) { return transport.RoundTrip(req) })
+}
+
+// relay copies between the client and the backend until one side closes
+// or ctx ends, then closes both.
+func relay(ctx context.Context, client net.Conn, buffered *bufio.Reader, backend io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent after the handshake may already be buffered
+		_, _ = io.Copy(backend, buffered)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, backend)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	client.Close()
+	backend.Close()
+	<-done
+}
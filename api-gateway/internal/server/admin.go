@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// probeBodyLimit caps how much of the upstream body a probe returns.
+const probeBodyLimit = 4096
+
+// requireAdmin guards h with the X-Admin-Token header. Without a configured
+// ADMIN_TOKEN the endpoint is disabled.
+func requireAdmin(cfg config.Config, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminToken == "" {
+			errpage.Write(w, r, http.StatusForbidden, "admin endpoint disabled: ADMIN_TOKEN not configured")
+			return
+		}
+		token := r.Header.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			errpage.Write(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// probeHandler performs a single call to an upstream service through the
+// normal Eureka resolution and circuit breaker path and reports the result.
+func probeHandler(cfg config.Config, upstreams *upstreamResolver, proxyClient *proxy.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Service string          `json:"service"`
+			Method  string          `json:"method"`
+			Path    string          `json:"path"`
+			Body    json.RawMessage `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			errpage.Write(w, r, http.StatusBadRequest, "invalid probe request: "+err.Error())
+			return
+		}
+		if in.Service == "" {
+			in.Service = cfg.AgentAppName
+		}
+		if in.Method == "" {
+			in.Method = http.MethodGet
+		}
+		in.Method = strings.ToUpper(in.Method)
+		if !strings.HasPrefix(in.Path, "/") {
+			in.Path = "/" + in.Path
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.AdminTimeout)
+		defer cancel()
+
+		static := ""
+		if strings.EqualFold(in.Service, cfg.AgentAppName) {
+			static = cfg.AgentBaseURL
+		}
+		base := upstreams.resolve(ctx, in.Service, static)
+		if base == "" {
+			errpage.WriteCode(w, r, http.StatusServiceUnavailable, "no_upstream", "no base url for service "+in.Service)
+			return
+		}
+
+		var body io.Reader
+		if len(in.Body) > 0 {
+			body = bytes.NewReader(in.Body)
+		}
+		req, err := http.NewRequestWithContext(ctx, in.Method, strings.TrimRight(base, "/")+in.Path, body)
+		if err != nil {
+			errpage.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		result := map[string]interface{}{
+			"service": in.Service,
+			"method":  in.Method,
+			"url":     req.URL.String(),
+		}
+		start := time.Now()
+		resp, err := proxyClient.Do(req)
+		if resp != nil {
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, probeBodyLimit+1))
+			result["status"] = resp.StatusCode
+			result["truncated"] = len(b) > probeBodyLimit
+			if len(b) > probeBodyLimit {
+				b = b[:probeBodyLimit]
+			}
+			result["body"] = string(b)
+		}
+		result["latency_ms"] = time.Since(start).Milliseconds()
+		result["breaker_state"] = proxyClient.State().String()
+		if err != nil {
+			result["error"] = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
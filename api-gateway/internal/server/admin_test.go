@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeRequiresAdminToken(t *testing.T) {
+	probe := `{"path":"/ping"}`
+	rec := httptest.NewRecorder()
+	newTestMux(t, testConfig(t, nil)).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/probe", strings.NewReader(probe)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without ADMIN_TOKEN = %d, want 403", rec.Code)
+	}
+
+	mux := newTestMux(t, testConfig(t, map[string]string{"ADMIN_TOKEN": "s3cret"}))
+	req := httptest.NewRequest(http.MethodPost, "/admin/probe", strings.NewReader(probe))
+	req.Header.Set("X-Admin-Token", "wrong")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d, want 401", rec.Code)
+	}
+}
+
+func TestProbeReportsUpstreamResponse(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/ping" {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "pong")
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"ADMIN_TOKEN":    "s3cret",
+		"AGENT_BASE_URL": agent.URL,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/admin/probe", strings.NewReader(`{"path":"ping"}`))
+	req.Header.Set("X-Admin-Token", "s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var result struct {
+		URL          string `json:"url"`
+		Status       int    `json:"status"`
+		Body         string `json:"body"`
+		Truncated    bool   `json:"truncated"`
+		BreakerState string `json:"breaker_state"`
+		LatencyMS    *int64 `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.URL != agent.URL+"/ping" || result.Status != http.StatusOK || result.Body != "pong" ||
+		result.Truncated || result.BreakerState != "closed" {
+		t.Fatalf("probe result = %+v", result)
+	}
+	// The agent takes 20ms to answer
+	if result.LatencyMS == nil {
+		t.Fatal("probe result has no latency_ms")
+	}
+	if *result.LatencyMS < 20 {
+		t.Fatalf("latency_ms = %d, want the upstream's latency of at least 20ms", *result.LatencyMS)
+	}
+}
+
+func TestProbeIsBoundedByAdminTimeout(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // hangs past any timeout
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"ADMIN_TOKEN":     "s3cret",
+		"ADMIN_TIMEOUT":   "50ms",
+		"REQUEST_TIMEOUT": "1m",
+		"AGENT_BASE_URL":  agent.URL,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/admin/probe", strings.NewReader(`{"path":"/slow"}`))
+	req.Header.Set("X-Admin-Token", "s3cret")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	mux.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("probe took %v with ADMIN_TIMEOUT=50ms", elapsed)
+	}
+	if !strings.Contains(rec.Body.String(), "deadline exceeded") {
+		t.Fatalf("probe result = %s, want a timeout error", rec.Body.String())
+	}
+}
+
+func TestCircuitBreakerTripAndReset(t *testing.T) {
+	mux := newTestMux(t, testConfig(t, map[string]string{"ADMIN_TOKEN": "s3cret"}))
+	post := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	breaker := func() (state string, changes map[string]int) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/circuit-breaker", nil))
+		var body struct {
+			State        string         `json:"state"`
+			StateChanges map[string]int `json:"state_changes"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.State, body.StateChanges
+	}
+
+	for _, path := range []string{"/admin/circuit-breaker/trip", "/admin/circuit-breaker/reset"} {
+		for _, token := range []string{"", "wrong"} {
+			if rec := post(path, token); rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s with token %q = %d, want 401", path, token, rec.Code)
+			}
+		}
+	}
+	if state, _ := breaker(); state != "closed" {
+		t.Fatalf("state after unauthorized requests = %q, want closed", state)
+	}
+
+	for _, tc := range []struct{ path, want string }{
+		{"/admin/circuit-breaker/trip", "open"},
+		{"/admin/circuit-breaker/reset", "closed"},
+	} {
+		rec := post(tc.path, "s3cret")
+		var body struct {
+			State string `json:"state"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK || body.State != tc.want {
+			t.Fatalf("%s = %d %q, want 200 and state %q", tc.path, rec.Code, rec.Body.String(), tc.want)
+		}
+		if state, _ := breaker(); state != tc.want {
+			t.Fatalf("state after %s = %q, want %q", tc.path, state, tc.want)
+		}
+	}
+	if _, changes := breaker(); changes["closed->open"] != 1 || changes["open->closed"] != 1 {
+		t.Fatalf("state changes = %v", changes)
+	}
+}
+
+func TestProbeFailsFastWhileBreakerTripped(t *testing.T) {
+	var hits atomic.Int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"ADMIN_TOKEN":    "s3cret",
+		"AGENT_BASE_URL": agent.URL,
+	}))
+	send := func(method, path, body string) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Admin-Token", "s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	send(http.MethodPost, "/admin/circuit-breaker/trip", "")
+	if got := send(http.MethodPost, "/admin/probe", `{"path":"/ping"}`); !strings.Contains(got, "circuit breaker is open") {
+		t.Fatalf("probe while tripped = %s, want the open-breaker error", got)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("agent saw %d requests while tripped", n)
+	}
+
+	send(http.MethodPost, "/admin/circuit-breaker/reset", "")
+	if got := send(http.MethodPost, "/admin/probe", `{"path":"/ping"}`); !strings.Contains(got, `"status":200`) {
+		t.Fatalf("probe after reset = %s, want the agent's 200", got)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("agent saw %d requests after reset, want 1", n)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// dependencyProbeTimeout bounds each individual check.
+const dependencyProbeTimeout = 2 * time.Second
+
+// dependencyReportTimeout bounds one full round of probes.
+const dependencyReportTimeout = 10 * time.Second
+
+// check is the outcome of one probe.
+type check struct {
+	OK        bool   `json:"ok"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// dependencyStatus describes one upstream as the gateway sees it.
+type dependencyStatus struct {
+	Name       string `json:"name"`
+	BaseURL    string `json:"base_url,omitempty"`
+	Resolvable check  `json:"resolvable"` // found in Eureka
+	Reachable  check  `json:"reachable"`  // TCP connect to the base URL
+	Healthy    check  `json:"healthy"`    // GET <base>/health returned 2xx
+}
+
+// dependencyReport is the /health/dependencies response body.
+type dependencyReport struct {
+	Status       string             `json:"status"` // "ok" or "degraded"
+	Eureka       check              `json:"eureka"`
+	Dependencies []dependencyStatus `json:"dependencies"`
+	CheckedAt    string             `json:"checked_at"`
+}
+
+// dependencyChecker probes Eureka and each configured upstream. Reports are
+// cached for ttl so frequent polling does not turn into a probe storm.
+// Upstream URLs are subject to guard, like proxied requests.
+type dependencyChecker struct {
+	cfg        config.Config
+	upstreams  *upstreamResolver
+	httpClient *http.Client
+	guard      *proxy.HostGuard
+	ttl        time.Duration
+	timeout    time.Duration // bounds one round of probes (0 = dependencyReportTimeout)
+
+	mu       sync.Mutex
+	last     *dependencyReport
+	lastAt   time.Time
+	inflight *dependencyProbe
+}
+
+// dependencyProbe is one round of probes shared by its waiters.
+type dependencyProbe struct {
+	done chan struct{} // closed once rep is set
+	rep  *dependencyReport
+}
+
+// report returns the cached report, probing again once it is older than ttl.
+// It returns nil if ctx ends before a report is available.
+func (d *dependencyChecker) report(ctx context.Context) *dependencyReport {
+	d.mu.Lock()
+	if d.last != nil && time.Since(d.lastAt) < d.ttl {
+		rep := d.last
+		d.mu.Unlock()
+		return rep
+	}
+	p := d.inflight
+	if p == nil {
+		p = d.startProbeLocked()
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.rep
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// startProbeLocked probes on a context detached from any request, so one
+// caller going away does not fail the others. A round cut short by its
+// timeout is returned to its waiters but not cached. d.mu must be held.
+func (d *dependencyChecker) startProbeLocked() *dependencyProbe {
+	p := &dependencyProbe{done: make(chan struct{})}
+	d.inflight = p
+	timeout := d.timeout
+	if timeout <= 0 {
+		timeout = dependencyReportTimeout
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		p.rep = d.probe(ctx)
+		complete := ctx.Err() == nil
+		cancel()
+
+		d.mu.Lock()
+		if complete {
+			d.last, d.lastAt = p.rep, time.Now()
+		}
+		d.inflight = nil
+		d.mu.Unlock()
+		close(p.done)
+	}()
+	return p
+}
+
+func (d *dependencyChecker) probe(ctx context.Context) *dependencyReport {
+	rep := &dependencyReport{
+		Status:    "ok",
+		Eureka:    timed(func() error { return dialAny(ctx, d.cfg.EurekaServerURLs) }),
+		CheckedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if !rep.Eureka.OK {
+		rep.Status = "degraded"
+	}
+
+	type upstream struct{ appName, staticURL string }
+	upstreams := []upstream{{d.cfg.AgentAppName, d.cfg.AgentBaseURL}}
+	if d.cfg.AgentStreamAppName != d.cfg.AgentAppName || d.cfg.AgentStreamBaseURL != d.cfg.AgentBaseURL {
+		upstreams = append(upstreams, upstream{d.cfg.AgentStreamAppName, d.cfg.AgentStreamBaseURL})
+	}
+	for _, u := range upstreams {
+		st := dependencyStatus{Name: u.appName}
+		var resolved string
+		st.Resolvable = timed(func() error {
+			var err error
+			resolved, err = d.upstreams.eureka.ResolveBaseURL(ctx, u.appName)
+			return err
+		})
+		st.BaseURL = resolved
+		if st.BaseURL == "" {
+			st.BaseURL = u.staticURL
+		}
+		st.Reachable = timed(func() error { return dial(ctx, st.BaseURL, d.guard) })
+		st.Healthy = timed(func() error { return d.health(ctx, st.BaseURL) })
+		// An upstream missing from Eureka is degraded even when a static
+		// fallback answers: the gateway no longer sees its instances
+		if !st.Resolvable.OK || !st.Reachable.OK || !st.Healthy.OK {
+			rep.Status = "degraded"
+		}
+		rep.Dependencies = append(rep.Dependencies, st)
+	}
+	return rep
+}
+
+// health GETs base/health and expects a 2xx answer.
+func (d *dependencyChecker) health(ctx context.Context, base string) error {
+	if base == "" {
+		return errNoBaseURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, dependencyProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+"/health", nil)
+	if err != nil {
+		return err
+	}
+	if err := d.guard.CheckURL(req.URL); err != nil {
+		return err
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+var errNoBaseURL = errors.New("no base url")
+
+// dialAny succeeds if any of rawURLs accepts a TCP connection.
+func dialAny(ctx context.Context, rawURLs []string) error {
+	err := errNoBaseURL
+	for _, u := range rawURLs {
+		if err = dial(ctx, u, nil); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// dial opens (and closes) a TCP connection to rawURL's host, refusing hosts
+// that guard (if any) does not allow.
+func dial(ctx context.Context, rawURL string, guard *proxy.HostGuard) error {
+	if rawURL == "" {
+		return errNoBaseURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if err := guard.CheckURL(u); err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	dialer := net.Dialer{Timeout: dependencyProbeTimeout}
+	if guard != nil {
+		dialer.Control = guard.Control
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// timed runs fn and records its outcome and latency.
+func timed(fn func() error) check {
+	start := time.Now()
+	err := fn()
+	c := check{OK: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
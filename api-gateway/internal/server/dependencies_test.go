@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// testChecker probes a static agent at agentURL with Eureka unreachable.
+func testChecker(agentURL string, guard *proxy.HostGuard) *dependencyChecker {
+	down := eureka.NewEurekaClient([]string{"http://127.0.0.1:1/eureka"}, time.Second)
+	return &dependencyChecker{
+		cfg:        config.Config{AgentAppName: "agent", AgentBaseURL: agentURL, EurekaServerURLs: []string{"http://127.0.0.1:1/eureka"}},
+		upstreams:  newUpstreamResolver(down, proxy.NewBalancer("round_robin", nil), 0),
+		httpClient: &http.Client{},
+		guard:      guard,
+		ttl:        time.Minute,
+	}
+}
+
+func TestDependencyReportIsCached(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer srv.Close()
+	d := testChecker(srv.URL, nil)
+
+	rep := d.report(context.Background())
+	if rep == nil || !rep.Dependencies[0].Healthy.OK {
+		t.Fatalf("report = %+v, want a healthy agent", rep)
+	}
+	if again := d.report(context.Background()); again != rep || hits.Load() != 1 {
+		t.Fatalf("second report probed again (%d health checks)", hits.Load())
+	}
+}
+
+func TestDependencyReportTimedOutIsNotCached(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	d := testChecker(srv.URL, nil)
+	d.timeout = 50 * time.Millisecond
+
+	if rep := d.report(context.Background()); rep == nil || rep.Dependencies[0].Healthy.OK {
+		t.Fatalf("report = %+v, want the slow agent unhealthy", rep)
+	}
+	d.report(context.Background())
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("agent probed %d times, want a timed-out round not to be cached", n)
+	}
+}
+
+func TestDependencyReportWaiterCanGiveUp(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+	d := testChecker(srv.URL, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if rep := d.report(ctx); rep != nil {
+		t.Fatalf("report = %+v, want nil once the caller's context ends", rep)
+	}
+}
+
+func TestDependencyProbeHonorsHostGuard(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer srv.Close()
+	d := testChecker(srv.URL, proxy.NewHostGuard([]string{"agent-service"}))
+
+	st := d.report(context.Background()).Dependencies[0]
+	if st.Reachable.OK || st.Healthy.OK || !strings.Contains(st.Healthy.Error, proxy.ErrHostNotAllowed.Error()) {
+		t.Fatalf("status = %+v, want the disallowed host refused", st)
+	}
+	if hits.Load() != 0 {
+		t.Fatal("probe reached a host outside the allowlist")
+	}
+}
+
+func TestDependenciesEndpointIsBoundedByAdminTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_BASE_URL": srv.URL,
+		"ADMIN_TIMEOUT":  "100ms",
+	}))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/dependencies", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v with ADMIN_TIMEOUT=100ms", elapsed)
+	}
+	var rep dependencyReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d %q: %v", rec.Code, rec.Body.String(), err)
+	}
+	if rep.Status != "degraded" || rep.Eureka.OK || len(rep.Dependencies) != 1 {
+		t.Fatalf("report = %+v, want Eureka down and one dependency", rep)
+	}
+	if st := rep.Dependencies[0]; st.BaseURL != srv.URL || !st.Reachable.OK || st.Healthy.OK {
+		t.Errorf("agent = %+v, want reachable but not healthy", st)
+	}
+}
+
+// registryWith serves a Eureka registry holding app AGENT at agentURL; any
+// other app is unknown.
+func registryWith(t *testing.T, agentURL string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/apps/AGENT") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"application": {"name": "AGENT", "instance": {"status": "UP", "homePageUrl": %q}}}`, agentURL+"/")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDependencyReportWithReachableEureka(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer agent.Close()
+	registry := registryWith(t, agent.URL)
+
+	for _, tc := range []struct {
+		name, appName, staticURL string
+		resolvable               bool
+		status                   string
+	}{
+		{"registered", "AGENT", "", true, "ok"},
+		{"static fallback only", "OTHER", agent.URL, false, "degraded"},
+	} {
+		d := &dependencyChecker{
+			cfg: config.Config{
+				AgentAppName:       tc.appName,
+				AgentBaseURL:       tc.staticURL,
+				AgentStreamAppName: tc.appName,
+				AgentStreamBaseURL: tc.staticURL,
+				EurekaServerURLs:   []string{registry.URL},
+			},
+			upstreams:  newUpstreamResolver(eureka.NewEurekaClient([]string{registry.URL}, time.Second), proxy.NewBalancer("round_robin", nil), 0),
+			httpClient: &http.Client{},
+			ttl:        time.Minute,
+		}
+		rep := d.report(context.Background())
+		if rep == nil || !rep.Eureka.OK || len(rep.Dependencies) != 1 {
+			t.Fatalf("%s: report = %+v, want Eureka up and one dependency", tc.name, rep)
+		}
+		st := rep.Dependencies[0]
+		if st.Resolvable.OK != tc.resolvable || st.BaseURL != agent.URL || !st.Reachable.OK || !st.Healthy.OK {
+			t.Errorf("%s: agent = %+v, want resolvable %v and a healthy %s", tc.name, st, tc.resolvable, agent.URL)
+		}
+		if rep.Status != tc.status {
+			t.Errorf("%s: status = %q, want %q", tc.name, rep.Status, tc.status)
+		}
+	}
+}
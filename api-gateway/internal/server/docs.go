@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/openapi"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// gatewaySpec returns the API Gateway's own OpenAPI document, generated
+// from the documented routes in the registry.
+func gatewaySpec(routes *RouteRegistry) map[string]interface{} {
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "API Gateway",
+			"description": "API Gateway for MLOps Platform",
+			"version":     Version,
+		},
+		"paths": routes.openAPIPaths(),
+	}
+}
+
+// docsFetchWorkers bounds how many upstream specs are fetched at once.
+const docsFetchWorkers = 4
+
+// serviceSpec is one entry of the aggregated docs response. Services whose
+// spec could not be fetched are listed with Error and no Spec.
+type serviceSpec struct {
+	Name   string                 `json:"name"`
+	Spec   map[string]interface{} `json:"spec,omitempty"`
+	URL    string                 `json:"url,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Prefix string                 `json:"-"` // path namespace in the merged document
+}
+
+// specProxyPath is where the gateway serves a service's spec, so browsers
+// fetch it same-origin.
+func specProxyPath(name string) string {
+	return "/api-docs/" + name + "/openapi.json"
+}
+
+// writeDoc serves an OpenAPI document as JSON with the configured CORS and
+// caching headers. The ETag is a hash of the encoded document, so a client
+// whose If-None-Match still matches gets a 304 without the body.
+func writeDoc(w http.ResponseWriter, r *http.Request, cfg config.Config, doc interface{}) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		errpage.Write(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	h := w.Header()
+	setDocHeaders(h, cfg)
+	h.Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// writeDocsError answers a docs request whose aggregate was not ready in
+// time. A cancelled request gets nothing, since its client is gone.
+func writeDocsError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	errpage.Write(w, r, http.StatusGatewayTimeout, "timed out aggregating service specs")
+}
+
+// setDocHeaders sets the CORS and caching headers every served document
+// carries.
+func setDocHeaders(h http.Header, cfg config.Config) {
+	if cfg.DocsAllowOrigin != "" {
+		h.Set("Access-Control-Allow-Origin", cfg.DocsAllowOrigin)
+		if cfg.DocsAllowOrigin != "*" {
+			h.Add("Vary", "Origin")
+		}
+	}
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.DocsMaxAge.Seconds())))
+}
+
+// etagMatches reports whether an If-None-Match header value names etag,
+// comparing weakly as RFC 9110 requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// docsAggregator collects OpenAPI documents from the gateway and the
+// upstream services it routes to.
+type docsAggregator struct {
+	cfg        config.Config
+	upstreams  *upstreamResolver
+	httpClient *http.Client
+}
+
+// fetchSpec downloads and decodes an OpenAPI document.
+func (d *docsAggregator) fetchSpec(ctx context.Context, specURL string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, specURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", specURL, resp.Status)
+	}
+	var spec map[string]interface{}
+	if err := json.NewDecoder(proxy.LimitBody(resp.Body, d.cfg.MaxRequestBody)).Decode(&spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// collect returns the gateway's entry followed by one entry per configured
+// service, in configuration order. Specs are fetched concurrently by up to
+// docsFetchWorkers workers, each fetch bounded by the request timeout.
+func (d *docsAggregator) collect(ctx context.Context) []serviceSpec {
+	specs := []serviceSpec{{
+		Name: "api-gateway",
+		URL:  "/openapi.json",
+	}}
+
+	if ctx.Err() != nil {
+		// The caller went away; skip the upstream round trips
+		return specs
+	}
+
+	results := make([]serviceSpec, len(d.cfg.Services))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(docsFetchWorkers, len(d.cfg.Services)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = d.collectOne(ctx, d.cfg.Services[i])
+			}
+		}()
+	}
+	for i := range d.cfg.Services {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return append(specs, results...)
+}
+
+// collectOne fetches one service's spec, reporting failures in the entry.
+func (d *docsAggregator) collectOne(ctx context.Context, svc config.ServiceConfig) serviceSpec {
+	entry := serviceSpec{Name: svc.Name, Prefix: "/" + svc.Name}
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.RequestTimeout)
+	defer cancel()
+	base := d.upstreams.resolve(ctx, svc.AppName, svc.BaseURL)
+	if base == "" {
+		entry.Error = "no instance available"
+		return entry
+	}
+	spec, err := d.fetchSpec(ctx, strings.TrimRight(base, "/")+svc.SpecPath)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Spec = spec
+	// Proxy URL instead of the direct one to avoid CORS issues
+	entry.URL = specProxyPath(svc.Name)
+	return entry
+}
+
+// merged returns one OpenAPI document combining the gateway's own spec
+// with the given specs.
+func merged(gateway map[string]interface{}, specs []serviceSpec) map[string]interface{} {
+	var sources []openapi.Source
+	for _, s := range specs {
+		spec := s.Spec
+		if spec == nil && s.Name == "api-gateway" {
+			spec = gateway
+		}
+		if spec == nil {
+			continue // fetch failed
+		}
+		sources = append(sources, openapi.Source{Name: s.Name, Prefix: s.Prefix, Spec: spec})
+	}
+	return openapi.Merge(map[string]interface{}{
+		"title":       "MLOps Platform",
+		"description": "Merged OpenAPI document for the API Gateway and its services",
+		"version":     "1.0.0",
+	}, sources)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// docsFetchTimeout bounds one round of upstream spec fetches.
+const docsFetchTimeout = 5 * time.Second
+
+// docsSnapshot is an aggregated spec list and when it was fetched.
+type docsSnapshot struct {
+	specs       []serviceSpec
+	generatedAt time.Time
+	// stale is set when every upstream fetch failed and the last good
+	// aggregate is served instead of the gateway-only result.
+	stale bool
+}
+
+// hasUpstreams reports whether any upstream spec in specs was fetched.
+func hasUpstreams(specs []serviceSpec) bool {
+	for _, s := range specs {
+		if s.Name != "api-gateway" && s.Spec != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// docsCache keeps the last aggregated spec list for ttl. Concurrent callers
+// share a single round of upstream fetches; once an entry goes stale it is
+// still served while a background refresh replaces it. The last aggregate
+// that included upstream specs is remembered separately and served when a
+// later fetch reaches no upstream at all.
+type docsCache struct {
+	ttl     time.Duration // 0 disables caching (the last-good fallback still applies)
+	collect func(ctx context.Context) []serviceSpec
+
+	mu       sync.Mutex
+	current  *docsSnapshot
+	lastGood *docsSnapshot
+	inflight *docsFetch
+	gen      uint64 // bumped by flush to discard in-flight results
+}
+
+// docsFetch is one round of upstream fetches shared by its waiters.
+type docsFetch struct {
+	done   chan struct{} // closed once snap is set
+	snap   *docsSnapshot
+	cancel context.CancelFunc
+	// waiters counts requests blocked on the fetch. When the last one
+	// disconnects the fetch is cancelled; background refreshes, which
+	// nobody waits on, always run to completion.
+	waiters int
+}
+
+func newDocsCache(ttl time.Duration, collect func(ctx context.Context) []serviceSpec) *docsCache {
+	return &docsCache{ttl: ttl, collect: collect}
+}
+
+// get returns the cached aggregate, fetching it if nothing usable is cached.
+// It fails with ctx's error if ctx ends before a result is available.
+func (c *docsCache) get(ctx context.Context) (*docsSnapshot, error) {
+	if c.ttl <= 0 {
+		fctx, cancel := context.WithTimeout(ctx, docsFetchTimeout)
+		defer cancel()
+		snap := &docsSnapshot{specs: c.collect(fctx), generatedAt: time.Now()}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			slog.Debug("[docs] aggregate request cancelled, aborted upstream fetches")
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.storeLocked(snap, c.gen), nil
+	}
+
+	c.mu.Lock()
+	if snap := c.current; snap != nil {
+		if time.Since(snap.generatedAt) >= c.ttl && c.inflight == nil {
+			c.startFetchLocked()
+		}
+		c.mu.Unlock()
+		return snap, nil
+	}
+	f := c.inflight
+	if f == nil {
+		f = c.startFetchLocked()
+	}
+	f.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.snap, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			slog.Debug("[docs] aggregate request cancelled, aborting upstream fetches", "err", ctx.Err())
+			f.cancel()
+			if c.inflight == f {
+				c.inflight = nil
+			}
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// startFetchLocked refreshes the cache in the background. The fetch is
+// detached from any single request so one caller going away does not fail
+// the others waiting on it. c.mu must be held.
+func (c *docsCache) startFetchLocked() *docsFetch {
+	ctx, cancel := context.WithTimeout(context.Background(), docsFetchTimeout)
+	f := &docsFetch{done: make(chan struct{}), cancel: cancel}
+	c.inflight = f
+	gen := c.gen
+	go func() {
+		defer cancel()
+		snap := &docsSnapshot{specs: c.collect(ctx), generatedAt: time.Now()}
+
+		c.mu.Lock()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// Every waiter left; the partial result is not worth caching
+			f.snap = snap
+		} else {
+			f.snap = c.storeLocked(snap, gen)
+		}
+		if c.inflight == f {
+			c.inflight = nil
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// storeLocked records a fresh fetch and returns what callers should see:
+// the fetch itself, or the last good aggregate flagged stale when no
+// upstream answered. A fetch started before a flush is not cached.
+// c.mu must be held.
+func (c *docsCache) storeLocked(snap *docsSnapshot, gen uint64) *docsSnapshot {
+	if hasUpstreams(snap.specs) {
+		c.lastGood = snap
+	} else if c.lastGood != nil {
+		snap = &docsSnapshot{specs: c.lastGood.specs, generatedAt: c.lastGood.generatedAt, stale: true}
+	}
+	if c.gen == gen && c.ttl > 0 {
+		c.current = snap
+	}
+	return snap
+}
+
+// flush drops the cached entry so the next call fetches fresh specs. The
+// last good aggregate is kept as the outage fallback.
+func (c *docsCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = nil
+	c.inflight = nil
+	c.gen++
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCollect returns the gateway's spec plus one upstream spec named
+// after how many times it has been called.
+func countingCollect(calls *atomic.Int32) func(context.Context) []serviceSpec {
+	return func(context.Context) []serviceSpec {
+		n := calls.Add(1)
+		return []serviceSpec{{Name: "api-gateway"}, {Name: strconv.Itoa(int(n)), Spec: map[string]interface{}{}}}
+	}
+}
+
+// mustGet returns c's aggregate for a request that never goes away.
+func mustGet(t *testing.T, c *docsCache) *docsSnapshot {
+	t.Helper()
+	snap, err := c.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func TestDocsCacheServesWithinTTL(t *testing.T) {
+	var calls atomic.Int32
+	c := newDocsCache(time.Minute, countingCollect(&calls))
+
+	first := mustGet(t, c)
+	again := mustGet(t, c)
+	if calls.Load() != 1 {
+		t.Fatalf("collected %d times, want 1 within the TTL", calls.Load())
+	}
+	if first.specs[1].Name != "1" || again != first {
+		t.Fatalf("got %+v then %+v", first, again)
+	}
+}
+
+func TestDocsCacheSharesOneFetchAmongConcurrentCallers(t *testing.T) {
+	const callers = 8
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := newDocsCache(time.Minute, func(ctx context.Context) []serviceSpec {
+		<-release
+		return countingCollect(&calls)(ctx)
+	})
+
+	got := make(chan *docsSnapshot, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			snap, err := c.get(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			got <- snap
+		}()
+	}
+	// Hold the upstream until every caller is waiting on the fetch
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		waiting := c.inflight != nil && c.inflight.waiters == callers
+		c.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("callers did not all join one fetch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	first := <-got
+	for i := 1; i < callers; i++ {
+		if snap := <-got; snap != first {
+			t.Fatalf("callers got different aggregates: %+v and %+v", first, snap)
+		}
+	}
+	if n := calls.Load(); n != 1 || first.specs[1].Name != "1" {
+		t.Fatalf("collected %d times for %d concurrent callers, want 1", n, callers)
+	}
+}
+
+func TestDocsCacheRefreshesStaleEntryInBackground(t *testing.T) {
+	var calls atomic.Int32
+	c := newDocsCache(time.Millisecond, countingCollect(&calls))
+	mustGet(t, c)
+	time.Sleep(5 * time.Millisecond)
+
+	// The expired entry is still served while the refresh runs
+	if snap := mustGet(t, c); snap.specs[1].Name != "1" {
+		t.Fatalf("expired get = %+v, want the cached specs", snap.specs)
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no background refresh")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDocsCacheFlushAndDisabled(t *testing.T) {
+	var calls atomic.Int32
+	c := newDocsCache(time.Minute, countingCollect(&calls))
+	mustGet(t, c)
+	c.flush()
+	if snap := mustGet(t, c); snap.specs[1].Name != "2" {
+		t.Fatalf("get after flush = %+v, want fresh specs", snap.specs)
+	}
+
+	calls.Store(0)
+	off := newDocsCache(0, countingCollect(&calls))
+	mustGet(t, off)
+	mustGet(t, off)
+	if calls.Load() != 2 {
+		t.Fatalf("collected %d times with caching disabled, want 2", calls.Load())
+	}
+}
+
+func TestDocsCacheServesLastGoodWhenUpstreamsAreDown(t *testing.T) {
+	var down atomic.Bool
+	collect := func(context.Context) []serviceSpec {
+		if down.Load() {
+			return []serviceSpec{{Name: "api-gateway"}, {Name: "agent", Error: "connection refused"}}
+		}
+		return []serviceSpec{{Name: "api-gateway"}, {Name: "agent", Spec: map[string]interface{}{}}}
+	}
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		down.Store(false)
+		c := newDocsCache(ttl, collect)
+		good := mustGet(t, c)
+
+		down.Store(true)
+		c.flush()
+		snap := mustGet(t, c)
+		if !snap.stale || len(snap.specs) != 2 || !snap.generatedAt.Equal(good.generatedAt) {
+			t.Errorf("ttl %v: outage served %+v, want the last good aggregate flagged stale", ttl, snap)
+		}
+
+		down.Store(false)
+		c.flush()
+		if snap := mustGet(t, c); snap.stale {
+			t.Errorf("ttl %v: still stale after upstreams recovered", ttl)
+		}
+	}
+}
+
+// blockingCollect signals started, then waits for release or for its
+// context to end, reporting the context's error on aborted.
+func blockingCollect(started chan<- struct{}, release <-chan struct{}, aborted chan<- error) func(context.Context) []serviceSpec {
+	return func(ctx context.Context) []serviceSpec {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			aborted <- ctx.Err()
+		}
+		return []serviceSpec{{Name: "api-gateway"}, {Name: "agent", Spec: map[string]interface{}{}}}
+	}
+}
+
+func TestDocsCacheAbortsFetchWhenEveryWaiterLeaves(t *testing.T) {
+	started, release, aborted := make(chan struct{}, 1), make(chan struct{}), make(chan error, 1)
+	defer close(release)
+	c := newDocsCache(time.Minute, blockingCollect(started, release, aborted))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if snap, err := c.get(ctx); err != context.Canceled {
+		t.Fatalf("cancelled get = %+v, %v; want context.Canceled", snap, err)
+	}
+	select {
+	case err := <-aborted:
+		if err != context.Canceled {
+			t.Fatalf("fetch ended with %v, want it cancelled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upstream fetch kept running after its only waiter left")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current != nil {
+		t.Fatal("aborted fetch was cached")
+	}
+}
+
+func TestDocsCacheKeepsFetchWhileAWaiterRemains(t *testing.T) {
+	started, release, aborted := make(chan struct{}, 1), make(chan struct{}), make(chan error, 1)
+	c := newDocsCache(time.Minute, blockingCollect(started, release, aborted))
+
+	got := make(chan *docsSnapshot)
+	go func() {
+		snap, _ := c.get(context.Background())
+		got <- snap
+	}()
+	<-started
+	// A second waiter joins the running fetch and leaves at once
+	leaving, leave := context.WithCancel(context.Background())
+	leave()
+	if _, err := c.get(leaving); err != context.Canceled {
+		t.Fatalf("leaving get = %v, want context.Canceled", err)
+	}
+	close(release)
+
+	if snap := <-got; len(snap.specs) != 2 {
+		t.Fatalf("remaining waiter got %+v", snap)
+	}
+	select {
+	case err := <-aborted:
+		t.Fatalf("fetch aborted (%v) with a waiter left", err)
+	default:
+	}
+}
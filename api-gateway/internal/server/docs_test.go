@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+)
+
+// serveSpec fetches svc's spec through specProxy with cfg.
+func serveSpec(t *testing.T, cfg config.Config, spec http.HandlerFunc, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	upstream := httptest.NewServer(spec)
+	t.Cleanup(upstream.Close)
+	svc := config.ServiceConfig{Name: "users", BaseURL: upstream.URL, SpecPath: "/openapi.json"}
+	h := specProxy(cfg, svc, newUpstreamResolver(nil, nil, 0), upstream.Client())
+
+	req := httptest.NewRequest(http.MethodGet, specProxyPath("users"), nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestSpecProxyUsesDocHeaders(t *testing.T) {
+	cfg := config.Config{RequestTimeout: time.Second, DocsAllowOrigin: "https://docs.example.com", DocsMaxAge: 90 * time.Second}
+	spec := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write([]byte(`{"openapi":"3.0.0"}`))
+	}
+
+	rec := serveSpec(t, cfg, spec, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	h := rec.Header()
+	if got := h.Get("Access-Control-Allow-Origin"); got != cfg.DocsAllowOrigin {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, cfg.DocsAllowOrigin)
+	}
+	if got := h.Get("Cache-Control"); got != "public, max-age=90" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	etag := h.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	if rec := serveSpec(t, cfg, spec, etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("status with matching If-None-Match = %d, want 304", rec.Code)
+	}
+}
+
+func TestSpecProxyPassesErrorsThrough(t *testing.T) {
+	cfg := config.Config{RequestTimeout: time.Second, DocsAllowOrigin: "*"}
+	rec := serveSpec(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no spec here", http.StatusNotFound)
+	}, "")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("got %d, ACAO %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestGatewaySpecListsRegisteredRoutes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestMux(t, testConfig(t, nil)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string                                         `json:"openapi"`
+		Paths   map[string]map[string]struct{ Summary string } `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct{ path, method, summary string }{
+		{"/agent", "post", "Get recommendations from the agent service"},
+		{"/agent/stream", "post", "Stream recommendations from the agent service"},
+		{"/openapi.json", "get", "API Gateway OpenAPI spec"},
+	} {
+		if got := doc.Paths[want.path][want.method].Summary; got != want.summary {
+			t.Errorf("%s %s summary = %q, want %q", want.method, want.path, got, want.summary)
+		}
+	}
+	if _, ok := doc.Paths["/agent"]["get"]; ok {
+		t.Error("/agent is documented for GET, which the route doesn't accept")
+	}
+	// Routes registered without a summary stay out of the spec
+	if _, ok := doc.Paths["/health/ready"]; ok {
+		t.Error("/health/ready has no summary but is documented")
+	}
+}
@@ -4,46 +4,95 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/errpage"
 	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/middleware"
 	"my_app/api-gateway/internal/proxy"
 	"my_app/api-gateway/internal/swagger"
 )
 
-// NewMux registers all HTTP handlers.
-func NewMux(cfg config.Config, eureka *eureka.Client, proxyClient *proxy.Client, httpClient *http.Client) *http.ServeMux {
+// Build metadata reported by /version, / and the status page, set at
+// build time with -ldflags "-X my_app/api-gateway/internal/server.Version=...".
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// NewMux registers all HTTP handlers. Background work it starts, such as
+// active health checks, runs until ctx ends.
+func NewMux(ctx context.Context, cfg config.Config, eureka *eureka.Client, proxyClient *proxy.Client, httpClient *http.Client, readiness *Readiness, reloader *Reloader) *http.ServeMux {
 	mux := http.NewServeMux()
+	routes := NewRouteRegistry(mux)
+	routes.sloSuccess = parseSLOSuccess(cfg.SLOSuccessCodes)
+	if cfg.LBStrategy == "affinity" {
+		routes.affinityKey = affinityKeyFunc(cfg.LBAffinityKey)
+	}
+	upstreams := newUpstreamResolver(eureka, proxy.NewBalancer(cfg.LBStrategy, proxyClient.Inflight), cfg.AgentStaticWeight)
+	proxyClient.SetFailover(upstreams.failover)
+	upstreams.zone = cfg.AvailabilityZone
+	if cfg.HealthCheckInterval > 0 {
+		upstreams.health = newHealthChecker(eureka, upstreamApps(cfg), httpClient, cfg.HealthCheckInterval, cfg.HealthCheckUnhealthyThreshold)
+		go upstreams.health.run(ctx)
+	}
+	docs := &docsAggregator{cfg: cfg, upstreams: upstreams, httpClient: httpClient}
+	docsCache := newDocsCache(cfg.DocsCacheTTL, docs.collect)
+	guard := proxy.NewHostGuard(cfg.UpstreamAllowedHosts)
+	shadow := newShadower(cfg.ShadowBaseURL, cfg.ShadowPercent, httpClient, guard, cfg.RequestTimeout, cfg.ShadowForwardCredentials)
+	deps := &dependencyChecker{
+		cfg:        cfg,
+		upstreams:  upstreams,
+		httpClient: httpClient,
+		guard:      guard,
+		ttl:        cfg.DependencyCacheTTL,
+		timeout:    cfg.AdminTimeout,
+	}
+
 	// Root path - show service info
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	routes.Handle(Route{Pattern: "/"}, func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+			errpage.Write(w, r, http.StatusNotFound, "not found")
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		info := map[string]interface{}{
 			"service": "API Gateway",
-			"version": "1.0.0",
+			"version": Version,
 			"status":  "running",
 			"endpoints": map[string]string{
 				"health":          "/health",
+				"ready":           "/ready",
+				"info":            cfg.StatusPagePath,
+				"version":         "/version",
+				"dependencies":    "/health/dependencies",
 				"swagger-ui":      "/swagger-ui",
 				"openapi":         "/openapi.json",
 				"aggregate":       "/api-docs/aggregate",
+				"merged":          "/api-docs/merged",
 				"agent":           "/agent",
 				"agent-stream":    "/agent/stream",
 				"circuit-breaker": "/admin/circuit-breaker",
+				"routes":          "/admin/routes",
+				"instances":       "/admin/instances",
+				"probe":           "/admin/probe",
+				"cache-flush":     "/admin/cache/aggregate/flush",
+				"reload":          "/admin/reload",
+				"config":          "/admin/config",
 			},
 		}
 		json.NewEncoder(w).Encode(info)
 	})
 
 	// Circuit Breaker Status
-	mux.HandleFunc("/admin/circuit-breaker", func(w http.ResponseWriter, r *http.Request) {
+	routes.Handle(Route{Pattern: "/admin/circuit-breaker", Methods: []string{http.MethodGet}}, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		counts := proxyClient.Counts()
 		status := map[string]interface{}{
@@ -56,196 +105,480 @@ func NewMux(cfg config.Config, eureka *eureka.Client, proxyClient *proxy.Client,
 				"consecutive_failures":  counts.ConsecutiveFailures,
 			},
 		}
-		json.NewEncoder(w).Encode(status)
+		changes, last := proxyClient.StateChanges()
+		status["state_changes"] = changes
+		if !last.At.IsZero() {
+			status["last_state_change"] = last
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false) // keep "closed->open" readable
+		enc.Encode(status)
 	})
 
+	// Current configuration, as last (re)loaded, with secrets redacted
+	routes.Handle(Route{Pattern: "/admin/config", Methods: []string{http.MethodGet}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reloader.Current().Redacted())
+	}))
+
+	// Manual breaker control for incident response
+	routes.Handle(Route{Pattern: "/admin/circuit-breaker/reset", Methods: []string{http.MethodPost}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		proxyClient.Reset()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"state": proxyClient.State().String()})
+	}))
+	routes.Handle(Route{Pattern: "/admin/circuit-breaker/trip", Methods: []string{http.MethodPost}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		proxyClient.Trip()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"state": proxyClient.State().String()})
+	}))
+
+	// Effective routing table
+	routes.Handle(Route{Pattern: "/admin/routes", Methods: []string{http.MethodGet}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		type routeInfo struct {
+			Pattern  string   `json:"pattern"`
+			Methods  []string `json:"methods"`
+			Summary  string   `json:"summary,omitempty"`
+			Upstream string   `json:"upstream,omitempty"`
+			Rewrite  string   `json:"rewrite,omitempty"`
+			Timeout  string   `json:"timeout,omitempty"`
+		}
+		registered := routes.Routes()
+		list := make([]routeInfo, 0, len(registered))
+		for _, rt := range registered {
+			info := routeInfo{
+				Pattern:  rt.Pattern,
+				Methods:  rt.Methods,
+				Summary:  rt.Summary,
+				Upstream: rt.Upstream,
+				Rewrite:  rt.Rewrite,
+			}
+			if info.Methods == nil {
+				info.Methods = []string{}
+			}
+			if rt.Timeout > 0 {
+				info.Timeout = rt.Timeout.String()
+			}
+			list = append(list, info)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"routes": list,
+			"count":  len(list),
+		})
+	}))
+
+	// Every instance Eureka holds for the configured upstream apps, with
+	// the active health check's view of each when enabled
+	routes.Handle(Route{Pattern: "/admin/instances", Methods: []string{http.MethodGet}, Timeout: cfg.AdminTimeout}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.AdminTimeout)
+		defer cancel()
+		type instanceInfo struct {
+			InstanceID  string          `json:"instanceId"`
+			Status      string          `json:"status"`
+			IPAddr      string          `json:"ipAddr"`
+			Port        int             `json:"port"`
+			HomePageURL string          `json:"homePageUrl"`
+			Health      *instanceHealth `json:"health,omitempty"`
+		}
+		type appInstances struct {
+			App       string         `json:"app"`
+			Instances []instanceInfo `json:"instances"`
+			Error     string         `json:"error,omitempty"`
+		}
+		apps := []appInstances{}
+		for _, app := range upstreamApps(cfg) {
+			entry := appInstances{App: app, Instances: []instanceInfo{}}
+			instances, err := eureka.ListInstances(ctx, app)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			for _, inst := range instances {
+				info := instanceInfo{
+					InstanceID:  inst.InstanceID,
+					Status:      inst.Status,
+					IPAddr:      inst.IPAddr,
+					Port:        inst.Port.Value,
+					HomePageURL: inst.HomePageURL,
+				}
+				if h, ok := upstreams.health.lookup(hostOf(inst.BaseURL())); ok {
+					info.Health = &h
+				}
+				entry.Instances = append(entry.Instances, info)
+			}
+			apps = append(apps, entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"apps":  apps,
+			"count": len(apps),
+		})
+	}))
+
+	// Probe a single upstream call through resolution + breaker
+	routes.Handle(Route{Pattern: "/admin/probe", Methods: []string{http.MethodPost}, Timeout: cfg.AdminTimeout}, requireAdmin(cfg, probeHandler(cfg, upstreams, proxyClient)))
+
 	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	routes.Handle(Route{Pattern: "/health", Summary: "Health check"}, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_, _ = w.Write([]byte(`{"status":"ok"}`))
 	})
 
-	// OpenAPI spec for API Gateway
-	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+	// Build metadata, for checking which build a deployment runs
+	routes.Handle(Route{Pattern: "/version", Methods: []string{http.MethodGet}, Summary: "Build version"}, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-	spec := `{
-  "openapi": "3.0.0",
-  "info": {
-    "title": "API Gateway",
-    "description": "API Gateway for MLOps Platform",
-    "version": "1.0.0"
-  },
-  "paths": {
-    "/health": {
-      "get": {
-        "summary": "Health check",
-        "responses": {"200": {"description": "OK"}}
-      }
-    },
-    "/agent": {
-      "post": {
-        "summary": "Get agent recommendations",
-        "responses": {"200": {"description": "OK"}}
-      }
-    },
-    "/agent/stream": {
-      "post": {
-        "summary": "Stream agent recommendations",
-        "responses": {"200": {"description": "OK"}}
-      }
-    }
-  }
-}`
-		_, _ = w.Write([]byte(spec))
+		json.NewEncoder(w).Encode(map[string]string{
+			"version":    Version,
+			"commit":     Commit,
+			"build_time": BuildTime,
+		})
 	})
 
-	// Aggregation endpoint: collect OpenAPI specs from all services
-	mux.HandleFunc("/api-docs/aggregate", func(w http.ResponseWriter, r *http.Request) {
+	// Dependency view: Eureka plus each upstream's resolution, TCP and /health
+	routes.Handle(Route{Pattern: "/health/dependencies", Methods: []string{http.MethodGet}, Timeout: cfg.AdminTimeout, Summary: "Dependency health"}, func(w http.ResponseWriter, r *http.Request) {
+		// A round of probes is bounded by ADMIN_TIMEOUT, and reports what
+		// it found by then
+		rep := deps.report(r.Context())
+		if rep == nil {
+			return // client went away
+		}
 		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	})
 
-		type serviceSpec struct {
-			Name string      `json:"name"`
-			Spec interface{} `json:"spec"`
-			URL  string      `json:"url,omitempty"`
+	// Status page in Spring Boot actuator /info shape, advertised to Eureka
+	// as statusPageUrl for Java tooling
+	routes.Handle(Route{Pattern: cfg.StatusPagePath, Methods: []string{http.MethodGet}}, func(w http.ResponseWriter, r *http.Request) {
+		status := "UP"
+		if !readiness.Ready() {
+			status = "OUT_OF_SERVICE"
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"app": map[string]string{
+				"name":        cfg.AppName,
+				"description": "API Gateway for MLOps Platform",
+				"version":     Version,
+			},
+			"build": map[string]string{
+				"name":    strings.ToLower(cfg.AppName),
+				"version": Version,
+				"commit":  Commit,
+				"time":    BuildTime,
+			},
+			"instance": map[string]string{
+				"instanceId": cfg.InstanceID,
+				"status":     status,
+			},
+		})
+	})
 
-		var specs []serviceSpec
-
-		// 1. Add API Gateway's own spec
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
+	// Readiness: unlike /health (liveness), 503 until serving and
+	// registered with Eureka, again once draining begins on shutdown, and
+	// while the agent upstream can't be resolved.
+	ready := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case readiness.Draining():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"draining"}`))
+		case !readiness.Registered():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"not ready","reason":"not registered with eureka"}`))
+		case !readiness.Ready():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"not ready"}`))
+		case upstreams.resolve(r.Context(), cfg.AgentAppName, cfg.AgentBaseURL) == "":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"degraded","reason":"no agent upstream resolvable"}`))
+		default:
+			_, _ = w.Write([]byte(`{"status":"ready"}`))
+		}
+	}
+	routes.Handle(Route{Pattern: "/ready", Summary: "Readiness check"}, ready)
+	routes.Handle(Route{Pattern: "/health/ready"}, ready)
 
-		specs = append(specs, serviceSpec{
-			Name: "api-gateway",
-			URL:  "/openapi.json",
-		})
+	// OpenAPI spec for API Gateway, generated once every route is registered
+	var gatewayDoc map[string]interface{}
+	routes.Handle(Route{Pattern: "/openapi.json", Methods: []string{http.MethodGet}, Summary: "API Gateway OpenAPI spec"}, func(w http.ResponseWriter, r *http.Request) {
+		writeDoc(w, r, cfg, gatewayDoc)
+	})
 
-		// 2. Try to fetch Agent service spec via Eureka
-		agentBase := cfg.AgentBaseURL
-		if u, err := eureka.ResolveBaseURL(ctx, cfg.AgentAppName); err == nil {
-			agentBase = u
-		}
-
-		if agentBase != "" {
-			// Fetch Agent's OpenAPI spec to verify it exists
-			agentSpecURL := strings.TrimRight(agentBase, "/") + "/openapi.json"
-			req, err := http.NewRequestWithContext(ctx, http.MethodGet, agentSpecURL, nil)
-			if err == nil {
-				req.Header.Set("Accept", "application/json")
-				resp, err := httpClient.Do(req)
-				if err == nil && resp.StatusCode == 200 {
-					var agentSpec interface{}
-					if err := json.NewDecoder(resp.Body).Decode(&agentSpec); err == nil {
-						// Use proxy URL instead of direct URL to avoid CORS issues
-						specs = append(specs, serviceSpec{
-							Name: "agent-service",
-							Spec: agentSpec,
-							URL:  "/api-docs/agent/openapi.json", // Proxy endpoint, not direct URL
-						})
-					}
-					resp.Body.Close()
-				}
-			}
+	// Aggregation endpoint: collect OpenAPI specs from all services
+	routes.Handle(Route{Pattern: "/api-docs/aggregate", Methods: []string{http.MethodGet}, Summary: "Aggregated service OpenAPI specs"}, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), docsFetchTimeout)
+		defer cancel()
+		snap, err := docsCache.get(ctx)
+		if err != nil {
+			writeDocsError(w, r, err)
+			return
 		}
 
 		// Return aggregated response
 		result := map[string]interface{}{
-			"services": specs,
-			"count":    len(specs),
+			"services":     snap.specs,
+			"count":        len(snap.specs),
+			"generated_at": snap.generatedAt.UTC().Format(time.RFC3339),
+		}
+		if snap.stale {
+			// Upstreams are unreachable; this is the last good aggregate
+			result["stale"] = true
+			result["age_seconds"] = int(time.Since(snap.generatedAt).Seconds())
 		}
-		json.NewEncoder(w).Encode(result)
+		writeDoc(w, r, cfg, result)
 	})
 
-	// Proxy endpoint for Agent's OpenAPI spec (to avoid CORS issues)
-	mux.HandleFunc("/api-docs/agent/openapi.json", func(w http.ResponseWriter, r *http.Request) {
-		base := cfg.AgentBaseURL
-		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+	// Merged endpoint: one OpenAPI document namespaced by service
+	routes.Handle(Route{Pattern: "/api-docs/merged", Methods: []string{http.MethodGet}, Summary: "Merged OpenAPI document"}, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), docsFetchTimeout)
 		defer cancel()
-		if u, err := eureka.ResolveBaseURL(ctx, cfg.AgentAppName); err == nil {
-			base = u
-		}
-		if base == "" {
-			http.Error(w, "agent service not available", 503)
-			return
-		}
-
-		// Fetch Agent's OpenAPI spec and proxy it
-		agentSpecURL := strings.TrimRight(base, "/") + "/openapi.json"
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, agentSpecURL, nil)
+		snap, err := docsCache.get(ctx)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeDocsError(w, r, err)
 			return
 		}
-		req.Header.Set("Accept", "application/json")
-		resp, err := httpClient.Do(req)
+		doc := merged(gatewayDoc, snap.specs)
+		doc["x-generated-at"] = snap.generatedAt.UTC().Format(time.RFC3339)
+		if snap.stale {
+			doc["x-stale"] = true
+		}
+		writeDoc(w, r, cfg, doc)
+	})
+
+	// Re-read configuration, same as SIGHUP
+	routes.Handle(Route{Pattern: "/admin/reload", Methods: []string{http.MethodPost}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		changes, err := reloader.Reload()
 		if err != nil {
-			http.Error(w, err.Error(), 502)
+			errpage.Write(w, r, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
-		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"changed": changes,
+			"count":   len(changes),
+		})
+	}))
 
-		// Copy headers
-		for k, v := range resp.Header {
-			if k != "Content-Length" {
-				w.Header()[k] = v
-			}
+	// Drop the cached aggregate so the next docs request refetches
+	routes.Handle(Route{Pattern: "/admin/cache/aggregate/flush", Methods: []string{http.MethodPost}}, requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) {
+		docsCache.flush()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"flushed"}`))
+	}))
+
+	// Proxy endpoints for service OpenAPI specs (to avoid CORS issues)
+	for _, svc := range cfg.Services {
+		h := specProxy(cfg, svc, upstreams, httpClient)
+		routes.Handle(Route{
+			Pattern:  specProxyPath(svc.Name),
+			Methods:  []string{http.MethodGet},
+			Upstream: svc.AppName,
+			Rewrite:  svc.SpecPath,
+			Timeout:  cfg.RequestTimeout,
+			Summary:  svc.Name + " OpenAPI spec",
+		}, h)
+		if svc.Name == "agent-service" {
+			// Path the agent's spec was served at before DOCS_SERVICES
+			routes.Handle(Route{
+				Pattern:  "/api-docs/agent/openapi.json",
+				Methods:  []string{http.MethodGet},
+				Upstream: svc.AppName,
+				Rewrite:  svc.SpecPath,
+				Timeout:  cfg.RequestTimeout,
+			}, h)
 		}
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(resp.StatusCode)
-		_, _ = io.Copy(w, resp.Body)
-	})
+	}
 
 	// Swagger UI endpoint
-	mux.HandleFunc("/swagger-ui", func(w http.ResponseWriter, r *http.Request) {
+	uiHTML := swagger.GetUIHTML(cfg.SwaggerUIVersion, cfg.SwaggerUITheme)
+	routes.Handle(Route{Pattern: "/swagger-ui", Methods: []string{http.MethodGet}}, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
-		_, _ = w.Write([]byte(swagger.GetUIHTML()))
+		_, _ = w.Write([]byte(uiHTML))
 	})
 
 	// Proxy: POST /agent -> Agent-service POST /recommendations
-	mux.HandleFunc("/agent", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", 405)
+	agentRoute := Route{
+		Pattern:  "/agent",
+		Methods:  []string{http.MethodPost},
+		Upstream: cfg.AgentAppName,
+		Rewrite:  "/recommendations",
+		Timeout:  cfg.RequestTimeout,
+		Summary:  "Get recommendations from the agent service",
+		Pipeline: agentPipeline(cfg),
+	}
+	routes.Handle(agentRoute, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), agentRoute.Timeout)
+		defer cancel()
+		base := upstreams.resolve(ctx, agentRoute.Upstream, cfg.AgentBaseURL)
+		if base == "" {
+			errpage.WriteCode(w, r, http.StatusInternalServerError, "no_upstream", "no agent service base url")
 			return
 		}
-		base := cfg.AgentBaseURL
-		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
-		defer cancel()
-		if u, err := eureka.ResolveBaseURL(ctx, cfg.AgentAppName); err == nil {
-			base = u
+		if proxy.ExpectsContinue(r) {
+			// Large upload: stream it so the 100 Continue handshake reaches the upstream
+			proxyClient.ProxyUpload(w, r, http.MethodPost, base+agentRoute.Rewrite)
+			return
 		}
-		if base == "" {
-			http.Error(w, "no agent service base url", 500)
+		body, ok := readBody(w, r)
+		if !ok {
 			return
 		}
-		body, _ := io.ReadAll(r.Body)
 		if len(bytes.TrimSpace(body)) == 0 {
 			body = []byte(`{}`)
 		}
-		proxyClient.ProxyJSON(w, r, http.MethodPost, base+"/recommendations", body)
+		shadowDone := shadow.mirror(r, agentRoute.Rewrite, body)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		// Deferred so the shadow's goroutine is released even if the
+		// proxy panics
+		defer func() { shadowDone(sw.status) }()
+		proxyClient.ProxyJSON(sw, r, http.MethodPost, base+agentRoute.Rewrite, body)
 	})
 
 	// Proxy: POST /agent/stream -> Agent-service POST /recommendations/stream
-	mux.HandleFunc("/agent/stream", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", 405)
-			return
-		}
-		base := cfg.AgentBaseURL
-		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+	streamRoute := Route{
+		Pattern:  "/agent/stream",
+		Methods:  []string{http.MethodPost},
+		Upstream: cfg.AgentStreamAppName,
+		Rewrite:  "/recommendations/stream",
+		Timeout:  cfg.RequestTimeout,
+		Summary:  "Stream recommendations from the agent service",
+		Pipeline: agentPipeline(cfg),
+	}
+	routes.Handle(streamRoute, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), streamRoute.Timeout)
 		defer cancel()
-		if u, err := eureka.ResolveBaseURL(ctx, cfg.AgentAppName); err == nil {
-			base = u
-		}
+		base := upstreams.resolve(ctx, streamRoute.Upstream, cfg.AgentStreamBaseURL)
 		if base == "" {
-			http.Error(w, "no agent service base url", 500)
+			errpage.WriteCode(w, r, http.StatusInternalServerError, "no_upstream", "no agent stream service base url")
+			return
+		}
+		body, ok := readBody(w, r)
+		if !ok {
 			return
 		}
-		body, _ := io.ReadAll(r.Body)
 		if len(bytes.TrimSpace(body)) == 0 {
 			body = []byte(`{}`)
 		}
-		proxyClient.ProxyStream(w, r, http.MethodPost, base+"/recommendations/stream", body)
+		proxyClient.ProxyStream(w, r, http.MethodPost, base+streamRoute.Rewrite, body)
 	})
 
+	registerProxyRoutes(routes, upstreams, proxyClient, cfg.RequestTimeout, parseProxyRoutes(cfg.ProxyRoutes))
+
+	gatewayDoc = gatewaySpec(routes)
+
 	return mux
 }
+
+// readBody reads r's body whole, answering 413 when it exceeds the
+// MAX_REQUEST_BODY cap and 400 when it can't be read.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		errpage.Write(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+	} else {
+		errpage.Write(w, r, http.StatusBadRequest, "reading request body failed")
+	}
+	return nil, false
+}
+
+// upstreamApps returns the Eureka app names the gateway routes to, once
+// each, in configuration order.
+func upstreamApps(cfg config.Config) []string {
+	var apps []string
+	seen := map[string]bool{}
+	add := func(app string) {
+		if app != "" && !seen[strings.ToUpper(app)] {
+			seen[strings.ToUpper(app)] = true
+			apps = append(apps, app)
+		}
+	}
+	add(cfg.AgentAppName)
+	add(cfg.AgentStreamAppName)
+	for _, svc := range cfg.Services {
+		add(svc.AppName)
+	}
+	for _, pr := range parseProxyRoutes(cfg.ProxyRoutes) {
+		if !strings.HasPrefix(pr.App, "http://") && !strings.HasPrefix(pr.App, "https://") {
+			add(pr.App)
+		}
+	}
+	return apps
+}
+
+// specProxy serves svc's OpenAPI spec from its current instance. A JSON
+// spec goes through writeDoc like the gateway's own documents; anything
+// else is passed through with the same CORS and caching headers.
+func specProxy(cfg config.Config, svc config.ServiceConfig, upstreams *upstreamResolver, httpClient *http.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+		base := upstreams.resolve(ctx, svc.AppName, svc.BaseURL)
+		if base == "" {
+			errpage.Write(w, r, http.StatusServiceUnavailable, svc.Name+" not available")
+			return
+		}
+
+		// Fetch the service's OpenAPI spec and proxy it
+		specURL := strings.TrimRight(base, "/") + svc.SpecPath
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, specURL, nil)
+		if err != nil {
+			errpage.Write(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			errpage.Write(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				errpage.Write(w, r, http.StatusBadGateway, err.Error())
+				return
+			}
+			if json.Valid(body) {
+				writeDoc(w, r, cfg, json.RawMessage(body))
+				return
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		proxy.CopyHeaders(w.Header(), resp.Header)
+		setDocHeaders(w.Header(), cfg)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}
+}
+
+// parseSLOSuccess compiles the per-route SLO success overrides, skipping
+// invalid entries.
+func parseSLOSuccess(codes map[string]string) map[string]middleware.StatusSet {
+	out := make(map[string]middleware.StatusSet, len(codes))
+	for pattern, list := range codes {
+		set, err := middleware.ParseStatusSet(list)
+		if err != nil {
+			slog.Warn("[config] invalid SLO_SUCCESS_CODES entry", "route", pattern, "err", err)
+			continue
+		}
+		out[pattern] = set
+	}
+	return out
+}
+
+// agentPipeline builds the interceptors configured for agent routes.
+func agentPipeline(cfg config.Config) proxy.Pipeline {
+	var pl proxy.Pipeline
+	if len(cfg.AgentRequestHeaders) > 0 {
+		pl.Request = append(pl.Request, proxy.HeaderInjector(cfg.AgentRequestHeaders))
+	}
+	if len(cfg.AgentRedactFields) > 0 {
+		pl.Response = append(pl.Response, proxy.JSONRedactor(cfg.AgentRedactFields))
+	}
+	return pl
+}
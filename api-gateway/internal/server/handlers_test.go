@@ -0,0 +1,473 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/middleware"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// testConfig loads the configuration from env, which is set for the test
+// on top of a Eureka server that is never reached.
+func testConfig(t *testing.T, env map[string]string) config.Config {
+	t.Helper()
+	t.Setenv("EUREKA_SERVER_URL", "http://127.0.0.1:1/eureka")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestMux builds the gateway's mux for cfg.
+func newTestMux(t *testing.T, cfg config.Config) http.Handler {
+	t.Helper()
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	return NewMux(t.Context(), cfg,
+		eureka.NewEurekaClient(cfg.EurekaServerURLs, time.Second),
+		proxy.New(httpClient, proxy.Options{}),
+		httpClient,
+		&Readiness{},
+		NewReloader(cfg),
+	)
+}
+
+func TestAdminRoutesListsRegisteredRoutes(t *testing.T) {
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_APP_NAME":  "AGENT-SVC",
+		"REQUEST_TIMEOUT": "42s",
+		"ADMIN_TOKEN":     "s3cret",
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	req.Header.Set("X-Admin-Token", "s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	type routeInfo struct {
+		Pattern  string   `json:"pattern"`
+		Methods  []string `json:"methods"`
+		Upstream string   `json:"upstream"`
+		Rewrite  string   `json:"rewrite"`
+		Timeout  string   `json:"timeout"`
+	}
+	var body struct {
+		Routes []routeInfo `json:"routes"`
+		Count  int         `json:"count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Count != len(body.Routes) {
+		t.Errorf("count = %d, but %d routes listed", body.Count, len(body.Routes))
+	}
+	byPattern := make(map[string]routeInfo)
+	for _, rt := range body.Routes {
+		byPattern[rt.Pattern] = rt
+	}
+
+	agent, ok := byPattern["/agent"]
+	if !ok {
+		t.Fatalf("/agent missing from %+v", body.Routes)
+	}
+	if len(agent.Methods) != 1 || agent.Methods[0] != http.MethodPost ||
+		agent.Upstream != "AGENT-SVC" || agent.Rewrite != "/recommendations" || agent.Timeout != "42s" {
+		t.Errorf("/agent = %+v", agent)
+	}
+	health, ok := byPattern["/health"]
+	if !ok || health.Methods == nil || len(health.Methods) != 0 {
+		t.Errorf("/health = %+v, %v; want listed with an empty method list", health, ok)
+	}
+	if _, ok := byPattern["/admin/routes"]; !ok {
+		t.Error("/admin/routes does not list itself")
+	}
+}
+
+func TestRouteRejectsDisallowedMethod(t *testing.T) {
+	mux := newTestMux(t, testConfig(t, nil))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/routes", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Fatalf("DELETE /admin/routes = %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestAgentSpecFetchedFromConfiguredPath(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/api-docs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"openapi":"3.0.0","info":{"title":"agent"}}`)
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_BASE_URL":     agent.URL,
+		"AGENT_OPENAPI_PATH": "v3/api-docs", // leading slash added
+	}))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api-docs/agent/openapi.json", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"title":"agent"`) {
+		t.Fatalf("got %d %q, want the agent's spec", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMergedDocsNamespaceTheAgent(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"openapi":"3.0.0","paths":{"/recommendations":{"post":{}}},"components":{"schemas":{"Item":{}}}}`)
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{"AGENT_BASE_URL": agent.URL}))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api-docs/merged", nil))
+
+	var doc struct {
+		Paths      map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("%d %q: %v", rec.Code, rec.Body.String(), err)
+	}
+	for _, path := range []string{"/health", "/agent-service/recommendations"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("merged paths %v lack %s", doc.Paths, path)
+		}
+	}
+	if _, ok := doc.Components.Schemas["agent-service_Item"]; !ok {
+		t.Errorf("schemas = %v, want agent-service_Item", doc.Components.Schemas)
+	}
+}
+
+func TestStatusPageFollowsReadiness(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"STATUS_PAGE_PATH": "actuator/info", "INSTANCE_ID": "gw-7"})
+	readiness := &Readiness{}
+	readiness.SetRegistered(true)
+	httpClient := &http.Client{}
+	mux := NewMux(t.Context(), cfg, eureka.NewEurekaClient(cfg.EurekaServerURLs, time.Second), proxy.New(httpClient, proxy.Options{}), httpClient, readiness, NewReloader(cfg))
+
+	for _, ready := range []bool{false, true} {
+		readiness.SetReady(ready)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/actuator/info", nil))
+		var body struct {
+			App      map[string]string `json:"app"`
+			Instance map[string]string `json:"instance"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("ready=%v: %d %v", ready, rec.Code, err)
+		}
+		want := "OUT_OF_SERVICE"
+		if ready {
+			want = "UP"
+		}
+		if body.Instance["status"] != want || body.Instance["instanceId"] != "gw-7" || body.App["version"] != Version {
+			t.Errorf("ready=%v: status page = %+v", ready, body)
+		}
+	}
+}
+
+func TestAgentStreamUsesItsOwnUpstream(t *testing.T) {
+	paths := make(chan string, 1)
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		io.WriteString(w, "data: done\n\n")
+	}))
+	defer stream.Close()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("agent backend got %s", r.URL.Path)
+	}))
+	defer agent.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_BASE_URL":        agent.URL,
+		"AGENT_STREAM_APP_NAME": "agent-stream",
+		"AGENT_STREAM_BASE_URL": stream.URL,
+	}))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/agent/stream", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := <-paths; got != "/recommendations/stream" {
+		t.Fatalf("stream backend got %s", got)
+	}
+}
+
+func TestAgentStreamForwardsOnlyVerifiedScheme(t *testing.T) {
+	got := make(chan http.Header, 1)
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+		io.WriteString(w, "data: done\n\n")
+	}))
+	defer stream.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{"AGENT_STREAM_BASE_URL": stream.URL}))
+	handler := middleware.ForwardedMiddleware(mux, middleware.NewTrustedProxies([]string{"10.0.0.0/8"}))
+	for _, tc := range []struct {
+		name, remote, proto, host string
+	}{
+		{"trusted proxy", "10.0.0.2:5555", "https", "shop.example"},
+		{"untrusted peer", "203.0.113.9:5555", "http", "gateway.internal"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://gateway.internal/agent/stream", strings.NewReader(`{}`))
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "shop.example")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		h := <-got
+		if h.Get("X-Forwarded-Proto") != tc.proto || h.Get("X-Forwarded-Host") != tc.host {
+			t.Errorf("%s: stream upstream saw Proto %q, Host %q; want %q, %q", tc.name,
+				h.Get("X-Forwarded-Proto"), h.Get("X-Forwarded-Host"), tc.proto, tc.host)
+		}
+	}
+}
+
+func TestAgentStreamDefaultsToAgent(t *testing.T) {
+	for _, tc := range []struct {
+		env              map[string]string
+		wantApp, wantURL string
+	}{
+		{map[string]string{"AGENT_BASE_URL": "http://agent:8000/"}, "agent", "http://agent:8000"},
+		// A separate app does not inherit the agent's static URL
+		{map[string]string{"AGENT_BASE_URL": "http://agent:8000", "AGENT_STREAM_APP_NAME": "streamer"}, "streamer", ""},
+		{map[string]string{"AGENT_STREAM_BASE_URL": "http://stream:9000/"}, "agent", "http://stream:9000"},
+	} {
+		t.Run(tc.wantApp+tc.wantURL, func(t *testing.T) {
+			tc.env["AGENT_APP_NAME"] = "agent"
+			cfg := testConfig(t, tc.env)
+			if cfg.AgentStreamAppName != tc.wantApp || cfg.AgentStreamBaseURL != tc.wantURL {
+				t.Errorf("%v: stream upstream %q at %q, want %q at %q", tc.env, cfg.AgentStreamAppName, cfg.AgentStreamBaseURL, tc.wantApp, tc.wantURL)
+			}
+		})
+	}
+}
+
+func TestDrainingFailsReadinessButNotLiveness(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"AGENT_BASE_URL": "http://agent:8000"})
+	readiness := &Readiness{}
+	readiness.SetRegistered(true)
+	readiness.SetReady(true)
+	httpClient := &http.Client{}
+	mux := NewMux(t.Context(), cfg, eureka.NewEurekaClient(cfg.EurekaServerURLs, time.Second), proxy.New(httpClient, proxy.Options{}), httpClient, readiness, NewReloader(cfg))
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	if code, _ := get("/health/ready"); code != http.StatusOK {
+		t.Fatalf("ready before draining: %d", code)
+	}
+
+	readiness.StartDraining()
+	for _, path := range []string{"/ready", "/health/ready"} {
+		if code, body := get(path); code != http.StatusServiceUnavailable || !strings.Contains(body, "draining") {
+			t.Errorf("%s while draining = %d %s", path, code, body)
+		}
+	}
+	for _, path := range []string{"/health", "/"} {
+		if code, _ := get(path); code != http.StatusOK {
+			t.Errorf("%s while draining = %d, want it still served", path, code)
+		}
+	}
+}
+
+func TestPreStopDelayOverridesQuietPeriod(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"SHUTDOWN_QUIET_PERIOD": "7s"})
+	if cfg.ShutdownQuietPeriod != 7*time.Second {
+		t.Fatalf("SHUTDOWN_QUIET_PERIOD alone gives %v", cfg.ShutdownQuietPeriod)
+	}
+	cfg = testConfig(t, map[string]string{"PRE_STOP_DELAY": "12s"})
+	if cfg.ShutdownQuietPeriod != 12*time.Second {
+		t.Fatalf("PRE_STOP_DELAY gives %v, want 12s", cfg.ShutdownQuietPeriod)
+	}
+}
+
+func TestShadowGetsCopyClientGetsPrimary(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"from":"primary"}`)
+	}))
+	defer primary.Close()
+	type mirrored struct {
+		path, body, marker string
+	}
+	got := make(chan mirrored, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- mirrored{r.URL.Path, string(b), r.Header.Get("X-Shadow-Request")}
+		w.WriteHeader(http.StatusTeapot) // never seen by the client
+	}))
+	defer shadow.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_BASE_URL":      primary.URL,
+		"AGENT_STATIC_WEIGHT": "100",
+		"SHADOW_BASE_URL":     shadow.URL,
+		"SHADOW_PERCENT":      "100",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/agent", strings.NewReader(`{"q":"shoes"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "primary") {
+		t.Fatalf("client got %d %q, want the primary response", rec.Code, rec.Body.String())
+	}
+	select {
+	case m := <-got:
+		if m.path != "/recommendations" || m.body != `{"q":"shoes"}` || m.marker != "true" {
+			t.Fatalf("shadow got %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow never received the request")
+	}
+}
+
+func TestShadowStripsCredentialsUnlessForwarded(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+
+	for forward, want := range map[string]string{"false": "", "true": "secret"} {
+		got := make(chan http.Header, 1)
+		shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got <- r.Header
+		}))
+		mux := newTestMux(t, testConfig(t, map[string]string{
+			"AGENT_BASE_URL":             primary.URL,
+			"AGENT_STATIC_WEIGHT":        "100",
+			"SHADOW_BASE_URL":            shadow.URL,
+			"SHADOW_PERCENT":             "100",
+			"SHADOW_FORWARD_CREDENTIALS": forward,
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/agent", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "secret")
+		req.Header.Set("X-Api-Key", "secret")
+		req.Header.Set("Cookie", "secret")
+		req.Header.Set("X-Trace-Hint", "a")
+		req.Header.Set("Connection", "X-Trace-Hint")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		select {
+		case h := <-got:
+			for _, name := range []string{"Authorization", "X-Api-Key", "Cookie"} {
+				if h.Get(name) != want {
+					t.Errorf("SHADOW_FORWARD_CREDENTIALS=%s: shadow %s = %q, want %q", forward, name, h.Get(name), want)
+				}
+			}
+			// Hop-by-hop headers belong to the client's connection only
+			for _, name := range []string{"X-Trace-Hint", "Keep-Alive"} {
+				if v := h.Get(name); v != "" {
+					t.Errorf("SHADOW_FORWARD_CREDENTIALS=%s: shadow got %s = %q", forward, name, v)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("SHADOW_FORWARD_CREDENTIALS=%s: shadow never received the request", forward)
+		}
+		shadow.Close()
+	}
+}
+
+func TestShadowHonorsHostGuard(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	var mirrored atomic.Int32
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { mirrored.Add(1) }))
+	_, port, _ := net.SplitHostPort(shadow.Listener.Addr().String())
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"AGENT_BASE_URL":         primary.URL,
+		"AGENT_STATIC_WEIGHT":    "100",
+		"SHADOW_BASE_URL":        "http://localhost:" + port, // a name the allowlist lacks
+		"SHADOW_PERCENT":         "100",
+		"UPSTREAM_ALLOWED_HOSTS": "127.0.0.1",
+	}))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/agent", strings.NewReader(`{}`)))
+	shadow.Close()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("client got %d, want the primary response", rec.Code)
+	}
+	if n := mirrored.Load(); n != 0 {
+		t.Fatalf("shadow outside UPSTREAM_ALLOWED_HOSTS got %d requests", n)
+	}
+}
+
+func TestFlushReachesClientThroughWrappers(t *testing.T) {
+	release, flushed := make(chan struct{}), make(chan error, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		sw.Header().Set("Content-Type", "text/plain")
+		io.WriteString(sw, "first\n")
+		flushed <- http.NewResponseController(sw).Flush()
+		<-release // the client must see the chunk while the handler still runs
+		io.WriteString(sw, "second\n")
+	})
+	srv := httptest.NewServer(middleware.StructuredLoggingMiddleware(middleware.NewGzip(5).Middleware(handler), nil))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	defer close(release)
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(zr).ReadString('\n')
+	if err != nil || line != "first\n" {
+		t.Fatalf("read %q, %v before the handler finished", line, err)
+	}
+	if err := <-flushed; err != nil {
+		t.Fatalf("Flush = %v", err)
+	}
+}
+
+func TestAdminListingsRequireToken(t *testing.T) {
+	mux := newTestMux(t, testConfig(t, map[string]string{"ADMIN_TOKEN": "s3cret"}))
+	for _, path := range []string{"/admin/instances", "/admin/routes"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without token = %d, want 401", path, rec.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Admin-Token", "s3cret")
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s with token = %d, want 200", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/eureka"
+)
+
+// instanceHealth is what active health checks know about one instance.
+type instanceHealth struct {
+	App                 string `json:"app"`
+	InstanceID          string `json:"instanceId"`
+	URL                 string `json:"url"`
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastCheck           string `json:"lastCheck,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+}
+
+// healthChecker probes every UP instance of the upstream apps at its
+// healthCheckUrl, so instances that died between Eureka lease expirations
+// are skipped before a request finds out. An instance turns unhealthy
+// after threshold failed probes in a row and healthy again on the first
+// success; new instances start out healthy.
+type healthChecker struct {
+	eureka     *eureka.Client
+	apps       []string
+	httpClient *http.Client
+	interval   time.Duration
+	threshold  int
+
+	mu    sync.Mutex
+	table map[string]*instanceHealth // instance host -> latest state
+}
+
+func newHealthChecker(eurekaClient *eureka.Client, apps []string, httpClient *http.Client, interval time.Duration, threshold int) *healthChecker {
+	return &healthChecker{
+		eureka:     eurekaClient,
+		apps:       apps,
+		httpClient: httpClient,
+		interval:   interval,
+		threshold:  threshold,
+		table:      make(map[string]*instanceHealth),
+	}
+}
+
+// run probes all instances now and then every interval until ctx ends.
+func (h *healthChecker) run(ctx context.Context) {
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+	for {
+		h.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkAll runs one round of probes. Instances no longer listed are
+// forgotten; an app whose listing failed keeps its previous entries.
+func (h *healthChecker) checkAll(ctx context.Context) {
+	type target struct {
+		host string
+		inst eureka.EurekaInstance
+		app  string
+	}
+	var targets []target
+	keep := make(map[string]bool)
+	for _, app := range h.apps {
+		instances, err := h.eureka.ListInstances(ctx, app)
+		if err != nil {
+			slog.Debug("[health] listing instances failed, keeping previous state", "app", app, "err", err)
+			h.mu.Lock()
+			for host, ent := range h.table {
+				if strings.EqualFold(ent.App, app) {
+					keep[host] = true
+				}
+			}
+			h.mu.Unlock()
+			continue
+		}
+		for _, inst := range instances {
+			base := inst.BaseURL()
+			if base == "" || !strings.EqualFold(inst.Status, "UP") {
+				continue
+			}
+			host := hostOf(base)
+			keep[host] = true
+			targets = append(targets, target{host, inst, app})
+		}
+	}
+
+	h.mu.Lock()
+	for host := range h.table {
+		if !keep[host] {
+			delete(h.table, host)
+		}
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url := t.inst.HealthCheckURL
+			if url == "" {
+				url = strings.TrimRight(t.inst.BaseURL(), "/") + "/health"
+			}
+			h.record(t.host, t.app, t.inst.InstanceID, url, h.probe(ctx, url))
+		}()
+	}
+	wg.Wait()
+}
+
+// probe GETs url and expects a 2xx answer.
+func (h *healthChecker) probe(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (h *healthChecker) record(host, app, instanceID, url string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ent, ok := h.table[host]
+	if !ok {
+		ent = &instanceHealth{Healthy: true}
+		h.table[host] = ent
+	}
+	ent.App, ent.InstanceID, ent.URL = app, instanceID, url
+	ent.LastCheck = time.Now().UTC().Format(time.RFC3339)
+	if err == nil {
+		if !ent.Healthy {
+			slog.Info("[health] instance recovered", "app", app, "instance", host)
+		}
+		ent.Healthy, ent.ConsecutiveFailures, ent.LastError = true, 0, ""
+		return
+	}
+	ent.ConsecutiveFailures++
+	ent.LastError = err.Error()
+	if ent.Healthy && ent.ConsecutiveFailures >= h.threshold {
+		ent.Healthy = false
+		slog.Warn("[health] instance unhealthy", "app", app, "instance", host, "failures", ent.ConsecutiveFailures, "err", err)
+	}
+}
+
+// unhealthy reports whether the instance at host failed its recent probes.
+// Hosts that were never probed count as healthy.
+func (h *healthChecker) unhealthy(host string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ent, ok := h.table[host]
+	return ok && !ent.Healthy
+}
+
+// lookup returns a copy of host's entry.
+func (h *healthChecker) lookup(host string) (instanceHealth, bool) {
+	if h == nil {
+		return instanceHealth{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ent, ok := h.table[host]
+	if !ok {
+		return instanceHealth{}, false
+	}
+	return *ent, true
+}
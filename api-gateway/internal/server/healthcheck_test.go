@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/eureka"
+)
+
+func TestHealthCheckerMarksFailingInstanceUntilStopped(t *testing.T) {
+	var probes atomic.Int32
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer instance.Close()
+	u, _ := url.Parse(instance.URL)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"application": {"name": "AGENT", "instance": {"status": "UP", "ipAddr": %q, "port": {"$": %s}, "healthCheckUrl": %q}}}`,
+			u.Hostname(), u.Port(), instance.URL+"/health")
+	}))
+	defer registry.Close()
+
+	h := newHealthChecker(eureka.NewEurekaClient([]string{registry.URL}, time.Second), []string{"agent"}, instance.Client(), 5*time.Millisecond, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		h.run(ctx)
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !h.unhealthy(u.Host) {
+		if time.Now().After(deadline) {
+			t.Fatalf("instance still healthy after %d failed probes", probes.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if ent, _ := h.lookup(u.Host); ent.ConsecutiveFailures < 2 || ent.LastError == "" {
+		t.Fatalf("entry = %+v", ent)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("health checks kept running after their context ended")
+	}
+	n := probes.Load()
+	time.Sleep(20 * time.Millisecond)
+	if probes.Load() != n {
+		t.Fatal("instance probed after the checker stopped")
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// ProxyRoute forwards every request under Prefix to an upstream, keeping
+// the method, the rest of the path, the query string and the body.
+type ProxyRoute struct {
+	Prefix string // path prefix, e.g. "/svc/"; a trailing slash is implied
+	App    string // Eureka app name, or a base URL for a static upstream
+	// StripPrefix drops Prefix before forwarding, so /svc/users/1 reaches
+	// the upstream as /users/1.
+	StripPrefix bool
+}
+
+// parseProxyRoutes turns PROXY_ROUTES entries ("/svc=users-service,strip")
+// into routes, skipping invalid ones. Routes are ordered longest prefix
+// first, then lexically, so the result does not depend on map order.
+func parseProxyRoutes(entries map[string]string) []ProxyRoute {
+	var out []ProxyRoute
+	for prefix, spec := range entries {
+		parts := strings.Split(spec, ",")
+		pr := ProxyRoute{Prefix: prefix, App: strings.TrimSpace(parts[0])}
+		for _, opt := range parts[1:] {
+			switch strings.TrimSpace(opt) {
+			case "strip":
+				pr.StripPrefix = true
+			default:
+				slog.Warn("[config] PROXY_ROUTES: unknown option", "prefix", prefix, "option", opt)
+			}
+		}
+		if !strings.HasPrefix(pr.Prefix, "/") || pr.Prefix == "/" || pr.App == "" {
+			slog.Warn("[config] PROXY_ROUTES: need a prefix other than / and an upstream, skipping", "prefix", prefix)
+			continue
+		}
+		out = append(out, pr)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i].Prefix) != len(out[j].Prefix) {
+			return len(out[i].Prefix) > len(out[j].Prefix)
+		}
+		return out[i].Prefix < out[j].Prefix
+	})
+	return out
+}
+
+// RegisterProxyRoutes adds a catch-all handler on mux for each ProxyRoute,
+// resolving Eureka apps with cfg's load-balancing strategy and zone. NewMux
+// registers PROXY_ROUTES itself; this is for building a mux by hand. Calls
+// are bounded by cfg.RequestTimeout.
+func RegisterProxyRoutes(mux *http.ServeMux, cfg config.Config, eurekaClient *eureka.Client, proxyClient *proxy.Client, prs []ProxyRoute) {
+	upstreams := newUpstreamResolver(eurekaClient, proxy.NewBalancer(cfg.LBStrategy, proxyClient.Inflight), 0)
+	upstreams.zone = cfg.AvailabilityZone
+	registerProxyRoutes(NewRouteRegistry(mux), upstreams, proxyClient, cfg.RequestTimeout, prs)
+}
+
+// registerProxyRoutes adds a catch-all handler for each ProxyRoute.
+// Upstreams are resolved like the hand-wired routes, so they share the
+// Eureka cache, failover and the routing table shown at /admin/routes.
+func registerProxyRoutes(routes *RouteRegistry, upstreams *upstreamResolver, proxyClient *proxy.Client, timeout time.Duration, prs []ProxyRoute) {
+	for _, pr := range prs {
+		prefix := strings.TrimSuffix(pr.Prefix, "/")
+		app, static := pr.App, ""
+		if strings.HasPrefix(app, "http://") || strings.HasPrefix(app, "https://") {
+			app, static = "", strings.TrimSuffix(app, "/")
+		}
+		rt := Route{Pattern: prefix + "/", Upstream: pr.App, Timeout: timeout, Summary: "Proxy to " + pr.App}
+		strip := pr.StripPrefix
+		routes.Handle(rt, func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), rt.Timeout)
+			defer cancel()
+			base := static
+			if app != "" {
+				base = upstreams.resolve(ctx, app, "")
+			}
+			if base == "" {
+				errpage.WriteCode(w, r, http.StatusBadGateway, "no_upstream", "no instance of "+pr.App)
+				return
+			}
+			path := r.URL.EscapedPath()
+			if strip {
+				path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+			}
+			target := base + path
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			if proxy.IsWebSocket(r) {
+				// Not bounded by the route timeout: the session lasts until a side closes
+				proxyClient.ProxyWebSocket(w, r, target)
+				return
+			}
+			body, ok := readBody(w, r)
+			if !ok {
+				return
+			}
+			proxyClient.ProxyRequest(w, r.WithContext(ctx), target, body)
+		})
+	}
+}
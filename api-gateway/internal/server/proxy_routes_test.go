@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/proxy"
+)
+
+func TestParseProxyRoutesOrdersLongestPrefixFirst(t *testing.T) {
+	got := parseProxyRoutes(map[string]string{
+		"/svc":       "users-service,strip",
+		"/svc/admin": "admin-service",
+		"/api":       "http://10.0.0.5:8080",
+		"/":          "everything",
+		"nope":       "users-service",
+		"/empty":     "",
+	})
+	want := []ProxyRoute{
+		{Prefix: "/svc/admin", App: "admin-service"},
+		{Prefix: "/api", App: "http://10.0.0.5:8080"},
+		{Prefix: "/svc", App: "users-service", StripPrefix: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseProxyRoutes = %+v, want %+v", got, want)
+	}
+}
+
+func TestProxyRoutesForwardToStaticUpstream(t *testing.T) {
+	seen := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	mux := newTestMux(t, testConfig(t, map[string]string{
+		"PROXY_ROUTES": "/legacy=" + upstream.URL + ",strip;/keep=" + upstream.URL,
+	}))
+	for path, want := range map[string]string{
+		"/legacy/users/1?x=1": "/users/1?x=1",
+		"/keep/users/1":       "/keep/users/1",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, rec.Code)
+			continue
+		}
+		if got := <-seen; got != want {
+			t.Errorf("%s reached the upstream as %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestRegisterProxyRoutesResolvesThroughEureka(t *testing.T) {
+	type request struct{ method, uri, body string }
+	seen := make(chan request, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		seen <- request{r.Method, r.URL.RequestURI(), string(b)}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+	host, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+
+	var asked string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"application": {"name": "USERS-SERVICE", "instance": {"status": "UP", "ipAddr": %q, "port": {"$": %s}}}}`, host, port)
+	}))
+	defer registry.Close()
+
+	mux := http.NewServeMux()
+	RegisterProxyRoutes(mux, testConfig(t, nil), eureka.NewEurekaClient([]string{registry.URL}, time.Second),
+		proxy.New(&http.Client{}, proxy.Options{}), []ProxyRoute{{Prefix: "/svc/", App: "users-service", StripPrefix: true}})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/svc/users/1?notify=1", strings.NewReader(`{"name":"ann"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got, want := <-seen, (request{http.MethodPut, "/users/1?notify=1", `{"name":"ann"}`}); got != want {
+		t.Errorf("upstream got %+v, want %+v", got, want)
+	}
+	if asked != "/apps/USERS-SERVICE" {
+		t.Errorf("registry asked for %s", asked)
+	}
+}
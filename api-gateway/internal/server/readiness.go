@@ -0,0 +1,46 @@
+package server
+
+import "sync/atomic"
+
+// Readiness tracks whether the gateway should receive traffic: it is
+// ready once it is serving and registered with Eureka (see SetRegistered).
+//
+// Draining is entered on shutdown: readiness fails so load balancers stop
+// sending traffic, while liveness stays healthy and requests keep being
+// served until the server actually stops.
+type Readiness struct {
+	ready      atomic.Bool
+	registered atomic.Bool
+	draining   atomic.Bool
+}
+
+// SetReady marks the gateway ready or not ready.
+func (r *Readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+// SetRegistered records that Eureka registration succeeded. Gateways
+// running without registration set it at startup.
+func (r *Readiness) SetRegistered(registered bool) {
+	r.registered.Store(registered)
+}
+
+// Registered reports whether SetRegistered(true) was called.
+func (r *Readiness) Registered() bool {
+	return r.registered.Load()
+}
+
+// StartDraining marks the gateway as draining; it no longer reports ready.
+func (r *Readiness) StartDraining() {
+	r.draining.Store(true)
+}
+
+// Draining reports whether shutdown draining has begun.
+func (r *Readiness) Draining() bool {
+	return r.draining.Load()
+}
+
+// Ready reports whether the gateway is ready to receive traffic.
+func (r *Readiness) Ready() bool {
+	return r.ready.Load() && r.registered.Load() && !r.draining.Load()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+
+	"my_app/api-gateway/internal/config"
+)
+
+// Change describes one configuration value altered by a reload.
+type Change struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// hotValue is a configuration value that can change without a restart.
+type hotValue struct {
+	name  string
+	get   func(config.Config) string
+	apply func(config.Config)
+}
+
+// Reloader re-reads the environment configuration and applies the values
+// registered as hot-reloadable. Everything else needs a restart. It backs
+// both SIGHUP and POST /admin/reload.
+type Reloader struct {
+	mu   sync.Mutex
+	cfg  config.Config
+	load func() (config.Config, error)
+	hot  []hotValue
+}
+
+// NewReloader starts from the configuration the process booted with.
+func NewReloader(cfg config.Config) *Reloader {
+	return &Reloader{cfg: cfg, load: config.Load}
+}
+
+// Hot registers a hot-reloadable value: get renders it for comparison and
+// apply installs a new configuration when it changed.
+func (rl *Reloader) Hot(name string, get func(config.Config) string, apply func(config.Config)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.hot = append(rl.hot, hotValue{name: name, get: get, apply: apply})
+}
+
+// Current returns the configuration as last loaded. Values that aren't
+// hot-reloadable take effect only at the next restart.
+func (rl *Reloader) Current() config.Config {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.cfg
+}
+
+// Reload reloads the configuration and returns the values that changed.
+// An invalid configuration is rejected and the current one kept.
+func (rl *Reloader) Reload() ([]Change, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	next, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{}
+	for _, v := range rl.hot {
+		old, cur := v.get(rl.cfg), v.get(next)
+		if old == cur {
+			continue
+		}
+		v.apply(next)
+		changes = append(changes, Change{Name: v.name, Old: old, New: cur})
+		slog.Info("[reload] value changed", "name", v.name, "old", old, "new", cur)
+	}
+	rl.cfg = next
+	return changes, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/config"
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/proxy"
+)
+
+func TestReloadAppliesChangedHotValues(t *testing.T) {
+	rl := NewReloader(testConfig(t, map[string]string{"RATE_LIMIT_RPS": "100"}))
+	var applied []float64
+	rl.Hot("rate_limit", func(c config.Config) string {
+		return fmt.Sprintf("%g/s", c.RateLimitRPS)
+	}, func(c config.Config) {
+		applied = append(applied, c.RateLimitRPS)
+	})
+
+	t.Setenv("RATE_LIMIT_RPS", "5")
+	changes, err := rl.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != (Change{Name: "rate_limit", Old: "100/s", New: "5/s"}) {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if len(applied) != 1 || applied[0] != 5 {
+		t.Fatalf("applied %v, want the new limit once", applied)
+	}
+
+	// Nothing changed since the last reload
+	if changes, err := rl.Reload(); err != nil || len(changes) != 0 || len(applied) != 1 {
+		t.Fatalf("second reload: changes = %+v, %v, applied %v", changes, err, applied)
+	}
+
+	// An invalid configuration is rejected without applying anything
+	t.Setenv("RATE_LIMIT_RPS", "lots")
+	if _, err := rl.Reload(); err == nil || len(applied) != 1 {
+		t.Fatalf("invalid reload: err %v, applied %v", err, applied)
+	}
+	if rl.cfg.RateLimitRPS != 5 {
+		t.Fatalf("current RateLimitRPS = %v after a rejected reload, want 5", rl.cfg.RateLimitRPS)
+	}
+}
+
+func TestReloaderCurrentFollowsReloads(t *testing.T) {
+	rl := NewReloader(config.Config{RateLimitRPS: 100, LogLevel: "info"})
+	rl.load = func() (config.Config, error) {
+		return config.Config{RateLimitRPS: 5, LogLevel: "debug"}, nil
+	}
+	if got := rl.Current().RateLimitRPS; got != 100 {
+		t.Fatalf("RateLimitRPS before reload = %v, want 100", got)
+	}
+	if _, err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cur := rl.Current(); cur.RateLimitRPS != 5 || cur.LogLevel != "debug" {
+		t.Fatalf("after reload got RPS %v, level %q", cur.RateLimitRPS, cur.LogLevel)
+	}
+}
+
+func TestReloadEndpointRequiresTokenAndListsChanges(t *testing.T) {
+	cfg := testConfig(t, map[string]string{"ADMIN_TOKEN": "s3cret", "RATE_LIMIT_RPS": "100"})
+	rl := NewReloader(cfg)
+	rl.Hot("rate_limit", func(c config.Config) string { return fmt.Sprintf("%g/s", c.RateLimitRPS) }, func(config.Config) {})
+	httpClient := &http.Client{}
+	mux := NewMux(t.Context(), cfg, eureka.NewEurekaClient(cfg.EurekaServerURLs, time.Second), proxy.New(httpClient, proxy.Options{}), httpClient, &Readiness{}, rl)
+	t.Setenv("RATE_LIMIT_RPS", "5")
+
+	reload := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized} {
+		if rec := reload(token); rec.Code != want {
+			t.Errorf("token %q: %d, want %d", token, rec.Code, want)
+		}
+	}
+	if rl.Current().RateLimitRPS != 100 {
+		t.Fatal("an unauthorized request reloaded the configuration")
+	}
+
+	rec := reload("s3cret")
+	var body struct {
+		Changed []Change `json:"changed"`
+		Count   int      `json:"count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d %q: %v", rec.Code, rec.Body.String(), err)
+	}
+	if body.Count != 1 || len(body.Changed) != 1 || body.Changed[0] != (Change{Name: "rate_limit", Old: "100/s", New: "5/s"}) {
+		t.Fatalf("reload response = %+v", body)
+	}
+
+	t.Setenv("RATE_LIMIT_RPS", "lots")
+	if rec := reload("s3cret"); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "RATE_LIMIT_RPS") {
+		t.Errorf("invalid reload = %d %q, want 422 naming the bad value", rec.Code, rec.Body.String())
+	}
+}
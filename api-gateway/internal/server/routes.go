@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/errpage"
+	"my_app/api-gateway/internal/middleware"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// Route describes a single entry in the gateway routing table.
+type Route struct {
+	Pattern  string        // mux pattern, e.g. "/agent"
+	Methods  []string      // allowed methods; empty means any method
+	Upstream string        // upstream app name (Eureka) or base URL
+	Rewrite  string        // upstream path the request is forwarded to
+	Timeout  time.Duration // per-request timeout for the upstream call
+	Summary  string        // documents the route in /openapi.json; "" keeps it out
+	// Pipeline holds the interceptors applied to this route's proxied calls.
+	Pipeline proxy.Pipeline
+	// SLOSuccess lists the statuses logged as slo_success (nil = 2xx/3xx).
+	SLOSuccess middleware.StatusSet
+}
+
+// allows reports whether the route accepts the given method.
+// HEAD is implied by GET.
+func (rt Route) allows(method string) bool {
+	if len(rt.Methods) == 0 {
+		return true
+	}
+	for _, m := range rt.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+		if m == http.MethodGet && method == http.MethodHead {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteRegistry records every route registered on the mux so the
+// effective routing table can be inspected at runtime.
+type RouteRegistry struct {
+	mux    *http.ServeMux
+	mu     sync.RWMutex
+	routes []Route
+	// sloSuccess overrides Route.SLOSuccess by pattern (SLO_SUCCESS_CODES).
+	sloSuccess map[string]middleware.StatusSet
+	// affinityKey extracts a request's session affinity key, if any.
+	affinityKey func(r *http.Request) string
+}
+
+// NewRouteRegistry creates a registry that registers handlers on mux.
+func NewRouteRegistry(mux *http.ServeMux) *RouteRegistry {
+	return &RouteRegistry{mux: mux}
+}
+
+// Handle registers h for the route, enforcing its method allowlist.
+func (rr *RouteRegistry) Handle(rt Route, h http.HandlerFunc) {
+	if set, ok := rr.sloSuccess[rt.Pattern]; ok {
+		rt.SLOSuccess = set
+	}
+	rr.mu.Lock()
+	rr.routes = append(rr.routes, rt)
+	rr.mu.Unlock()
+
+	rr.mux.HandleFunc(rt.Pattern, func(w http.ResponseWriter, r *http.Request) {
+		middleware.SetRoute(r, rt.Pattern)
+		middleware.SetSLOSuccess(r, rt.SLOSuccess)
+		if !rt.allows(r.Method) {
+			w.Header().Set("Allow", strings.Join(rt.Methods, ", "))
+			errpage.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !rt.Pipeline.Empty() {
+			r = r.WithContext(proxy.WithPipeline(r.Context(), rt.Pipeline))
+		}
+		if rr.affinityKey != nil {
+			if key := rr.affinityKey(r); key != "" {
+				r = r.WithContext(proxy.WithAffinityKey(r.Context(), key))
+			}
+		}
+		h(w, r)
+	})
+}
+
+// openAPIPaths builds the OpenAPI "paths" object for the documented
+// routes. A route without Methods is documented as GET; a prefix pattern
+// such as "/svc/" becomes "/svc/{path}".
+func (rr *RouteRegistry) openAPIPaths() map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, rt := range rr.Routes() {
+		if rt.Summary == "" {
+			continue
+		}
+		path := rt.Pattern
+		var params []interface{}
+		if strings.HasSuffix(path, "/") && path != "/" {
+			path += "{path}"
+			params = append(params, map[string]interface{}{
+				"name": "path", "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		methods := rt.Methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		for _, m := range methods {
+			op := map[string]interface{}{
+				"summary":   rt.Summary,
+				"responses": map[string]interface{}{"200": map[string]interface{}{"description": "OK"}},
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+			item[strings.ToLower(m)] = op
+		}
+	}
+	return paths
+}
+
+// Routes returns a snapshot of the registered routes in registration order.
+func (rr *RouteRegistry) Routes() []Route {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	out := make([]Route, len(rr.routes))
+	copy(out, rr.routes)
+	return out
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"my_app/api-gateway/internal/proxy"
+)
+
+// maxInflightShadows caps concurrent shadow requests; extra samples are
+// dropped rather than queued so a slow shadow cannot build up load.
+const maxInflightShadows = 32
+
+// shadowCredentialHeaders are stripped from shadow requests unless the
+// shadower forwards credentials.
+var shadowCredentialHeaders = []string{"Authorization", "X-Api-Key", "Cookie"}
+
+// shadower mirrors a sample of requests to a shadow upstream. Shadow
+// requests run in the background with their own timeout, their responses
+// are discarded, and only a status mismatch with the primary is logged, so
+// the shadow can never affect what the client sees. Like proxied requests,
+// shadow requests only go to hosts guard allows.
+type shadower struct {
+	baseURL string
+	percent int // 0-100 of requests mirrored
+	client  *http.Client
+	guard   *proxy.HostGuard
+	timeout time.Duration
+	intn    func(n int) int
+	slots   chan struct{}
+	// forwardCredentials keeps shadowCredentialHeaders on shadow requests.
+	forwardCredentials bool
+}
+
+func newShadower(baseURL string, percent int, client *http.Client, guard *proxy.HostGuard, timeout time.Duration, forwardCredentials bool) *shadower {
+	if baseURL == "" || percent <= 0 {
+		return nil
+	}
+	return &shadower{
+		baseURL: baseURL,
+		percent: percent,
+		client:  client,
+		guard:   guard,
+		timeout: timeout,
+		intn:    rand.IntN,
+		slots:   make(chan struct{}, maxInflightShadows),
+
+		forwardCredentials: forwardCredentials,
+	}
+}
+
+// mirror sends a copy of r (with body) to path on the shadow upstream if r
+// is sampled. It returns a function to call with the primary response
+// status once known; it is a no-op when r is not mirrored.
+func (s *shadower) mirror(r *http.Request, path string, body []byte) func(primaryStatus int) {
+	noop := func(int) {}
+	if s == nil || s.intn(100) >= s.percent {
+		return noop
+	}
+	target, err := url.Parse(s.baseURL + path)
+	if err == nil {
+		err = s.guard.CheckURL(target)
+	}
+	if err != nil {
+		slog.Warn("[shadow] refused", "method", r.Method, "path", path, "err", err)
+		return noop
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		slog.Warn("[shadow] dropped: too many shadow requests in flight", "method", r.Method, "path", path)
+		return noop
+	}
+
+	header := make(http.Header)
+	proxy.CopyHeaders(header, r.Header)
+	if !s.forwardCredentials {
+		for _, h := range shadowCredentialHeaders {
+			header.Del(h)
+		}
+	}
+	primary := make(chan int, 1)
+	go func() {
+		defer func() { <-s.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, r.Method, target.String(), bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header = header
+		req.Header.Set("X-Shadow-Request", "true")
+		status := 0
+		resp, err := s.client.Do(req)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			status = resp.StatusCode
+		}
+		want := <-primary
+		switch {
+		case err != nil:
+			slog.Warn("[shadow] request failed", "method", r.Method, "path", path, "err", err, "primary", want)
+		case status != want:
+			slog.Info("[shadow] status mismatch", "method", r.Method, "path", path, "shadow", status, "primary", want)
+		}
+	}()
+	return func(status int) { primary <- status }
+}
+
+// statusWriter records the status written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/middleware"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// instanceDownTTL is how long an instance that refused a connection is
+// skipped before being tried again.
+const instanceDownTTL = 30 * time.Second
+
+// upstreamResolver picks the base URL for an upstream app. Normally the
+// Eureka-discovered instance wins and the static URL is only a fallback;
+// with staticWeight > 0 that percentage of calls goes to the static URL
+// directly, which lets traffic be shifted gradually during a migration.
+//
+// Instances that refused a connection are marked down for instanceDownTTL
+// and skipped, so the proxy can fail over to the next one. With active
+// health checks on, instances failing them are skipped the same way.
+type upstreamResolver struct {
+	eureka       *eureka.Client
+	balancer     proxy.Balancer
+	staticWeight int // 0-100
+	intn         func(n int) int
+	health       *healthChecker // nil when active health checks are off
+	zone         string         // preferred instance zone, "" for any
+
+	mu   sync.Mutex
+	down map[string]time.Time // instance host -> when it may be tried again
+	apps map[string]*knownApp // app name -> what failover needs to know
+}
+
+// knownApp is what the resolver last saw of an app. hosts is replaced on
+// every lookup, so instances that left the registry are forgotten.
+type knownApp struct {
+	static string            // static fallback URL
+	hosts  map[string]string // instance host -> base URL
+}
+
+func newUpstreamResolver(eurekaClient *eureka.Client, balancer proxy.Balancer, staticWeight int) *upstreamResolver {
+	return &upstreamResolver{
+		eureka:       eurekaClient,
+		balancer:     balancer,
+		staticWeight: staticWeight,
+		intn:         rand.IntN,
+		down:         make(map[string]time.Time),
+		apps:         make(map[string]*knownApp),
+	}
+}
+
+// resolve returns the base URL to use for appName, or "" if none is known.
+func (u *upstreamResolver) resolve(ctx context.Context, appName, staticURL string) string {
+	if staticURL != "" && u.staticWeight > 0 && u.intn(100) < u.staticWeight && !u.isDown(staticURL) {
+		if appName != "" {
+			u.mu.Lock()
+			if _, ok := u.apps[appName]; !ok {
+				u.apps[appName] = &knownApp{static: staticURL, hosts: map[string]string{hostOf(staticURL): staticURL}}
+			}
+			u.mu.Unlock()
+		}
+		return staticURL
+	}
+	return u.pick(ctx, appName, staticURL, "")
+}
+
+// pick returns the first Eureka instance of appName, in the balancer's
+// order, that is not marked down, then staticURL, then an instance that is
+// marked down (better than nothing). The host exclude is never returned.
+// With a zone set, other zones are only considered when no instance in it
+// is usable.
+func (u *upstreamResolver) pick(ctx context.Context, appName, staticURL, exclude string) string {
+	var lastResort string
+	var bases []string
+	if appName != "" { // "" is a static-only upstream
+		all, _ := u.eureka.BaseURLs(ctx, appName)
+		u.remember(appName, staticURL, all)
+		local, remote := u.byZone(appName, all)
+		bases = u.balancer.Order(ctx, appName, local)
+		if !u.anyUsable(bases, exclude) {
+			bases = append(bases, u.balancer.Order(ctx, appName, remote)...)
+		}
+	}
+	for _, base := range bases {
+		if hostOf(base) == exclude {
+			continue
+		}
+		if !u.isDown(base) {
+			return base
+		}
+		if lastResort == "" {
+			lastResort = base
+		}
+	}
+	if staticURL != "" && hostOf(staticURL) != exclude {
+		return staticURL
+	}
+	return lastResort
+}
+
+// failover marks the instance at failed down and returns failed moved to
+// another instance of the same app. It implements proxy.FailoverFunc.
+func (u *upstreamResolver) failover(ctx context.Context, failed *url.URL) (string, bool) {
+	now := time.Now()
+	u.mu.Lock()
+	for host, until := range u.down {
+		if now.After(until) {
+			delete(u.down, host)
+		}
+	}
+	u.down[failed.Host] = now.Add(instanceDownTTL)
+	var appName, staticURL, failedBase string
+	for name, a := range u.apps {
+		if base, ok := a.hosts[failed.Host]; ok {
+			appName, staticURL, failedBase = name, a.static, base
+			break
+		}
+	}
+	u.mu.Unlock()
+	if failedBase == "" {
+		return "", false
+	}
+	slog.Warn("[upstream] instance failed, marked down", "app", appName, "instance", failed.Host, "for", instanceDownTTL)
+	u.eureka.Invalidate(appName)
+	next := u.pick(ctx, appName, staticURL, failed.Host)
+	if next == "" {
+		return "", false
+	}
+	return rebase(failed, failedBase, next), true
+}
+
+// rebase moves u from the instance at base from to the one at base to,
+// keeping the path below the base and the query.
+func rebase(u *url.URL, from, to string) string {
+	f, err := url.Parse(from)
+	if err != nil {
+		return to
+	}
+	t, err := url.Parse(to)
+	if err != nil {
+		return to
+	}
+	moved := *t
+	moved.Path = strings.TrimSuffix(t.Path, "/") + strings.TrimPrefix(u.Path, strings.TrimSuffix(f.Path, "/"))
+	moved.RawPath = ""
+	moved.RawQuery = u.RawQuery
+	return moved.String()
+}
+
+// byZone splits appName's instances at bases into those in u's zone and
+// the rest. With no zone configured every instance counts as local.
+func (u *upstreamResolver) byZone(appName string, bases []string) (local, remote []string) {
+	if u.zone == "" {
+		return bases, nil
+	}
+	for _, base := range bases {
+		if strings.EqualFold(u.eureka.Zone(appName, base), u.zone) {
+			local = append(local, base)
+		} else {
+			remote = append(remote, base)
+		}
+	}
+	return local, remote
+}
+
+// anyUsable reports whether any of bases is neither exclude nor down.
+func (u *upstreamResolver) anyUsable(bases []string, exclude string) bool {
+	for _, base := range bases {
+		if hostOf(base) != exclude && !u.isDown(base) {
+			return true
+		}
+	}
+	return false
+}
+
+// remember records the instances just listed for appName, replacing what
+// was known before. Static-only upstreams have nothing to fail over to and
+// are not recorded.
+func (u *upstreamResolver) remember(appName, staticURL string, bases []string) {
+	hosts := make(map[string]string, len(bases)+1)
+	for _, base := range append(bases, staticURL) {
+		if base != "" {
+			hosts[hostOf(base)] = base
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.apps[appName] = &knownApp{static: staticURL, hosts: hosts}
+}
+
+func (u *upstreamResolver) isDown(base string) bool {
+	host := hostOf(base)
+	if u.health.unhealthy(host) {
+		return true
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	until, ok := u.down[host]
+	if ok && time.Now().After(until) {
+		delete(u.down, host)
+		return false
+	}
+	return ok
+}
+
+func hostOf(base string) string {
+	if p, err := url.Parse(base); err == nil {
+		return p.Host
+	}
+	return base
+}
+
+// affinityKeyFunc returns how LB_AFFINITY_KEY extracts a request's key:
+// "header:<Name>" reads that header, "ip" uses the client IP.
+func affinityKeyFunc(spec string) func(r *http.Request) string {
+	if name, ok := strings.CutPrefix(spec, "header:"); ok {
+		return func(r *http.Request) string { return r.Header.Get(name) }
+	}
+	return middleware.ClientIP
+}
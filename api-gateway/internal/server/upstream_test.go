@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"my_app/api-gateway/internal/eureka"
+	"my_app/api-gateway/internal/proxy"
+)
+
+// fakeEureka serves a registry where every app has one UP instance at
+// http://10.0.0.1:8000.
+func fakeEureka(t *testing.T) *eureka.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"application": {"name": "AGENT", "instance": {"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}}}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return eureka.NewEurekaClient([]string{srv.URL}, time.Second)
+}
+
+func TestResolveSplitsByStaticWeight(t *testing.T) {
+	const static = "http://static:8000"
+	u := newUpstreamResolver(fakeEureka(t), proxy.NewBalancer("round_robin", nil), 30)
+	for roll, want := range map[int]string{
+		0:  static,
+		29: static,
+		30: "http://10.0.0.1:8000",
+		99: "http://10.0.0.1:8000",
+	} {
+		u.intn = func(int) int { return roll }
+		if got := u.resolve(context.Background(), "agent", static); got != want {
+			t.Errorf("roll %d: resolve = %q, want %q", roll, got, want)
+		}
+	}
+}
+
+func TestResolveWithoutWeightPrefersEureka(t *testing.T) {
+	u := newUpstreamResolver(fakeEureka(t), proxy.NewBalancer("round_robin", nil), 0)
+	u.intn = func(int) int { t.Fatal("rolled with weight 0"); return 0 }
+	if got := u.resolve(context.Background(), "agent", "http://static:8000"); got != "http://10.0.0.1:8000" {
+		t.Fatalf("resolve = %q", got)
+	}
+}
+
+func TestResolveFallsBackToStatic(t *testing.T) {
+	u := newUpstreamResolver(eureka.NewEurekaClient([]string{"http://127.0.0.1:1/eureka"}, time.Second), proxy.NewBalancer("round_robin", nil), 0)
+	if got := u.resolve(context.Background(), "agent", "http://static:8000"); got != "http://static:8000" {
+		t.Fatalf("resolve = %q, want the static URL when Eureka is down", got)
+	}
+}
+
+func TestAgentStaticWeightIsClamped(t *testing.T) {
+	for v, want := range map[string]int{"-5": 0, "40": 40, "250": 100} {
+		if got := testConfig(t, map[string]string{"AGENT_STATIC_WEIGHT": v}).AgentStaticWeight; got != want {
+			t.Errorf("AGENT_STATIC_WEIGHT=%s gives %d, want %d", v, got, want)
+		}
+	}
+}
+
+// twoInstanceEureka serves a registry where every app has UP instances at
+// 10.0.0.1:8000 and 10.0.0.2:8000, in that order.
+func twoInstanceEureka(t *testing.T) *eureka.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"application": {"name": "AGENT", "instance": [
+			{"status": "UP", "ipAddr": "10.0.0.1", "port": {"$": 8000}},
+			{"status": "UP", "ipAddr": "10.0.0.2", "port": {"$": 8000}}
+		]}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return eureka.NewEurekaClient([]string{srv.URL}, time.Second)
+}
+
+// inOrder is a Balancer that keeps the registry order, so tests can tell
+// which instance is picked.
+type inOrder struct{}
+
+func (inOrder) Order(_ context.Context, _ string, bases []string) []string { return bases }
+
+func TestResolveRotatesAcrossInstances(t *testing.T) {
+	u := newUpstreamResolver(twoInstanceEureka(t), proxy.NewBalancer("round_robin", nil), 0)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, u.resolve(context.Background(), "agent", ""))
+	}
+	want := []string{"http://10.0.0.1:8000", "http://10.0.0.2:8000", "http://10.0.0.1:8000", "http://10.0.0.2:8000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolve gave %v, want %v", got, want)
+	}
+}
+
+func TestFailoverMarksInstanceDown(t *testing.T) {
+	u := newUpstreamResolver(twoInstanceEureka(t), inOrder{}, 0)
+	first := u.resolve(context.Background(), "agent", "http://static:8000")
+	if first != "http://10.0.0.1:8000" {
+		t.Fatalf("resolve = %q", first)
+	}
+
+	next, ok := u.failover(context.Background(), &url.URL{Scheme: "http", Host: "10.0.0.1:8000"})
+	if !ok || next != "http://10.0.0.2:8000" {
+		t.Fatalf("failover = %q, %v; want the other instance", next, ok)
+	}
+	// Later requests skip the refused instance until instanceDownTTL passes
+	if got := u.resolve(context.Background(), "agent", "http://static:8000"); got != "http://10.0.0.2:8000" {
+		t.Fatalf("resolve after failover = %q", got)
+	}
+	u.down["10.0.0.1:8000"] = time.Now().Add(-time.Second)
+	if got := u.resolve(context.Background(), "agent", "http://static:8000"); got != first {
+		t.Fatalf("resolve after the down period = %q, want %q", got, first)
+	}
+}
+
+func TestFailoverKeepsPathBelowBase(t *testing.T) {
+	u := newUpstreamResolver(twoInstanceEureka(t), proxy.NewBalancer("round_robin", nil), 0)
+	u.resolve(context.Background(), "agent", "http://static:8000/v1")
+	u.failover(context.Background(), &url.URL{Host: "10.0.0.1:8000"})
+
+	failed, _ := url.Parse("http://10.0.0.2:8000/recommendations?q=1")
+	if next, _ := u.failover(context.Background(), failed); next != "http://static:8000/v1/recommendations?q=1" {
+		t.Fatalf("failover = %q, want the path moved below the static base", next)
+	}
+}
+
+func TestResolverForgetsInstancesThatLeft(t *testing.T) {
+	u := newUpstreamResolver(twoInstanceEureka(t), proxy.NewBalancer("round_robin", nil), 0)
+	u.resolve(context.Background(), "agent", "")
+	u.apps["agent"].hosts["10.0.0.9:8000"] = "http://10.0.0.9:8000" // listed once, gone since
+	u.down["10.0.0.8:8000"] = time.Now().Add(-time.Second)
+
+	u.resolve(context.Background(), "agent", "")
+	if _, ok := u.failover(context.Background(), &url.URL{Host: "10.0.0.9:8000"}); ok {
+		t.Fatal("failed over from an instance no longer in the registry")
+	}
+	if len(u.apps["agent"].hosts) != 2 {
+		t.Errorf("known instances = %v, want the two listed", u.apps["agent"].hosts)
+	}
+	if _, ok := u.down["10.0.0.8:8000"]; ok {
+		t.Errorf("down = %v, want expired entries pruned", u.down)
+	}
+}
+
+func TestFailoverFallsBackToStaticThenGivesUp(t *testing.T) {
+	u := newUpstreamResolver(twoInstanceEureka(t), proxy.NewBalancer("round_robin", nil), 0)
+	u.resolve(context.Background(), "agent", "http://static:8000")
+	u.failover(context.Background(), &url.URL{Host: "10.0.0.1:8000"})
+
+	if next, _ := u.failover(context.Background(), &url.URL{Host: "10.0.0.2:8000"}); next != "http://static:8000" {
+		t.Fatalf("failover = %q, want the static URL once every instance is down", next)
+	}
+	if _, ok := u.failover(context.Background(), &url.URL{Host: "unknown:80"}); ok {
+		t.Fatal("failed over from a host the resolver never handed out")
+	}
+}
+
+// zonedEureka serves AGENT's UP instances, given as base URL -> zone.
+func zonedEureka(t *testing.T, zones map[string]string) *eureka.Client {
+	t.Helper()
+	var insts []map[string]interface{}
+	for base, zone := range zones {
+		insts = append(insts, map[string]interface{}{
+			"instanceId":  base,
+			"status":      "UP",
+			"homePageUrl": base,
+			"metadata":    map[string]string{"zone": zone},
+		})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/apps/AGENT") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"application": map[string]interface{}{"name": "AGENT", "instance": insts},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return eureka.NewEurekaClient([]string{srv.URL}, time.Second)
+}
+
+// zonedResolver resolves AGENT from instances a and b in zone-a and c in
+// zone-b, preferring zone.
+func zonedResolver(t *testing.T, zone string) *upstreamResolver {
+	t.Helper()
+	client := zonedEureka(t, map[string]string{
+		"http://a:8080": "zone-a",
+		"http://b:8080": "zone-a",
+		"http://c:8080": "zone-b",
+	})
+	u := newUpstreamResolver(client, proxy.NewBalancer("round_robin", nil), 0)
+	u.zone = zone
+	return u
+}
+
+// picks resolves AGENT n times and counts the instances returned.
+func picks(u *upstreamResolver, n int) map[string]int {
+	seen := map[string]int{}
+	for i := 0; i < n; i++ {
+		seen[u.resolve(context.Background(), "AGENT", "")]++
+	}
+	return seen
+}
+
+func TestResolvePrefersSameZone(t *testing.T) {
+	seen := picks(zonedResolver(t, "zone-a"), 20)
+	if seen["http://c:8080"] != 0 {
+		t.Fatalf("cross-zone instance used while local ones are up: %v", seen)
+	}
+	if seen["http://a:8080"] == 0 || seen["http://b:8080"] == 0 {
+		t.Fatalf("local instances not both used: %v", seen)
+	}
+}
+
+func TestResolveFallsBackAcrossZones(t *testing.T) {
+	u := zonedResolver(t, "zone-a")
+	u.mu.Lock()
+	u.down["a:8080"] = time.Now().Add(time.Minute)
+	u.down["b:8080"] = time.Now().Add(time.Minute)
+	u.mu.Unlock()
+
+	if seen := picks(u, 5); seen["http://c:8080"] != 5 {
+		t.Fatalf("with zone-a down, got %v, want only the zone-b instance", seen)
+	}
+}
+
+func TestResolveWithoutZoneUsesEveryInstance(t *testing.T) {
+	if seen := picks(zonedResolver(t, ""), 30); len(seen) != 3 {
+		t.Fatalf("got %v, want all three instances", seen)
+	}
+}
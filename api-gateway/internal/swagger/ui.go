@@ -1,22 +1,68 @@
 package swagger
 
-// getSwaggerUIHTML returns the Swagger UI HTML page
-func GetUIHTML() string {
-	return `<!DOCTYPE html>
+import (
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// DefaultVersion is the swagger-ui-dist release served when none is configured.
+const DefaultVersion = "5.9.0"
+
+var versionRe = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+
+// ValidVersion reports whether v looks like a swagger-ui-dist release (e.g. 5.9.0).
+func ValidVersion(v string) bool {
+	return versionRe.MatchString(v)
+}
+
+// ValidTheme reports whether theme is a supported UI theme.
+func ValidTheme(theme string) bool {
+	return theme == "light" || theme == "dark"
+}
+
+// themeCSS holds the extra styles applied for each theme.
+var themeCSS = map[string]string{
+	"light": `body { margin:0; background: #fafafa; }`,
+	"dark": `body { margin:0; background: #1b1b1b; }
+        .swagger-ui { filter: invert(88%) hue-rotate(180deg); }
+        .swagger-ui img, .swagger-ui .microlight { filter: invert(100%) hue-rotate(180deg); }`,
+}
+
+var uiTemplate = template.Must(template.New("swagger-ui").Parse(uiHTML))
+
+// GetUIHTML returns the Swagger UI HTML page for the given swagger-ui-dist
+// version and theme. Invalid values fall back to the defaults.
+func GetUIHTML(version, theme string) string {
+	if !ValidVersion(version) {
+		version = DefaultVersion
+	}
+	if !ValidTheme(theme) {
+		theme = "light"
+	}
+	var b strings.Builder
+	_ = uiTemplate.Execute(&b, map[string]interface{}{
+		"Version": version,
+		"Theme":   template.CSS(themeCSS[theme]),
+	})
+	return b.String()
+}
+
+const uiHTML = `<!DOCTYPE html>
 <html>
 <head>
     <title>API Documentation - MLOps Platform</title>
-    <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@5.9.0/swagger-ui.css" />
+    <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui.css" />
     <style>
         html { box-sizing: border-box; overflow: -moz-scrollbars-vertical; overflow-y: scroll; }
         *, *:before, *:after { box-sizing: inherit; }
-        body { margin:0; background: #fafafa; }
+        {{.Theme}}
     </style>
 </head>
 <body>
     <div id="swagger-ui"></div>
-    <script src="https://unpkg.com/swagger-ui-dist@5.9.0/swagger-ui-bundle.js"></script>
-    <script src="https://unpkg.com/swagger-ui-dist@5.9.0/swagger-ui-standalone-preset.js"></script>
+    <script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-bundle.js"></script>
+    <script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-standalone-preset.js"></script>
     <script>
         window.onload = function() {
             fetch('/api-docs/aggregate')
@@ -62,4 +108,3 @@ func GetUIHTML() string {
     </script>
 </body>
 </html>`
-}
@@ -0,0 +1,51 @@
+package swagger
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetUIHTMLUsesConfiguredVersion(t *testing.T) {
+	html := GetUIHTML("5.17.14", "light")
+	for _, want := range []string{
+		"https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui.css",
+		"https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML does not reference %s", want)
+		}
+	}
+	if strings.Contains(html, "@"+DefaultVersion+"/") {
+		t.Errorf("HTML still references the default version %s", DefaultVersion)
+	}
+}
+
+func TestGetUIHTMLTheme(t *testing.T) {
+	if dark := GetUIHTML(DefaultVersion, "dark"); !strings.Contains(dark, "invert(88%)") {
+		t.Error("dark theme styles missing")
+	}
+	if light := GetUIHTML(DefaultVersion, "light"); strings.Contains(light, "invert(") {
+		t.Error("light theme has dark styles")
+	}
+}
+
+func TestGetUIHTMLFallsBackOnInvalidInput(t *testing.T) {
+	html := GetUIHTML(`5.9.0"><script>alert(1)</script>`, "neon")
+	if strings.Contains(html, "alert(1)") || !strings.Contains(html, "@"+DefaultVersion+"/") {
+		t.Error("invalid version was not replaced by the default")
+	}
+}
+
+func TestValidVersion(t *testing.T) {
+	for v, want := range map[string]bool{
+		"5.9.0":         true,
+		"5.10.3-beta.1": true,
+		"5.9":           false,
+		"latest":        false,
+		"5.9.0/../x":    false,
+	} {
+		if got := ValidVersion(v); got != want {
+			t.Errorf("ValidVersion(%q) = %v, want %v", v, got, want)
+		}
+	}
+}